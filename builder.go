@@ -2,7 +2,9 @@ package enmime
 
 import (
 	"bytes"
+	htmltemplate "html/template"
 	"io"
+	"io/fs"
 	"maps"
 	"math/rand"
 	"mime"
@@ -11,8 +13,12 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/inbucket/html2text"
 	"github.com/jhillyerd/enmime/v2/internal/stringutil"
 	"github.com/pkg/errors"
 )
@@ -24,14 +30,28 @@ import (
 type MailBuilder struct {
 	to, cc, bcc          []mail.Address
 	from                 mail.Address
+	sender               mail.Address
 	replyTo              []mail.Address
+	dispNotificationTo   []mail.Address
 	subject              string
 	date                 time.Time
+	messageID            string
+	messageIDDomain      string
+	inReplyTo            []string
+	references           []string
+	importance           string
+	priority             string
 	header               textproto.MIMEHeader
 	text, html           []byte
+	flowed               bool
+	textFromHTML         bool
+	inlineCSS            bool
+	embedImages          bool
+	embedFS              fs.FS
 	inlines, attachments []*Part
 	err                  error
 	randSource           rand.Source
+	smtpUTF8             bool
 }
 
 // Builder returns an empty MailBuilder struct.
@@ -45,6 +65,17 @@ func (p MailBuilder) RandSeed(seed int64) MailBuilder {
 	return p
 }
 
+// SMTPUTF8 returns a copy of MailBuilder that produces RFC 6532 internationalized messages when
+// enabled, for delivery through mail servers supporting the SMTPUTF8 extension.  Addresses may
+// contain UTF-8 local parts and IDN domains, and all headers are written as raw UTF-8.
+//
+// When disabled, the default, IDN domains in address headers are converted to punycode, and Build
+// fails if an address has a UTF-8 local part.
+func (p MailBuilder) SMTPUTF8(enabled bool) MailBuilder {
+	p.smtpUTF8 = enabled
+	return p
+}
+
 // Error returns the stored error from a file attachment/inline read or nil.
 func (p MailBuilder) Error() error {
 	return p.err
@@ -72,6 +103,147 @@ func (p *MailBuilder) GetFrom() mail.Address {
 	return p.from
 }
 
+// Sender returns a copy of MailBuilder with the specified Sender header, identifying the agent
+// responsible for transmitting the message on behalf of the From address.
+func (p MailBuilder) Sender(name, addr string) MailBuilder {
+	p.sender = mail.Address{Name: name, Address: addr}
+	return p
+}
+
+// GetSender returns the stored sender header.
+func (p *MailBuilder) GetSender() mail.Address {
+	return p.sender
+}
+
+// MessageID returns a copy of MailBuilder with the specified Message-ID header.  id may be given
+// with or without angle brackets, and must be of the form left@right.  When no ID is set, or it is
+// set to an empty string, Build generates a random one; see MessageIDDomain.
+func (p MailBuilder) MessageID(id string) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	if strings.TrimSpace(id) == "" {
+		p.messageID = ""
+		return p
+	}
+	ids, err := parseMessageIDs(id)
+	if err == nil && len(ids) != 1 {
+		err = errors.Errorf("expected a single message ID: %q", id)
+	}
+	if err != nil {
+		p.err = err
+		return p
+	}
+	p.messageID = ids[0]
+	return p
+}
+
+// GetMessageID returns the stored Message-ID, without angle brackets, or an empty string if it
+// will be generated by Build.
+func (p *MailBuilder) GetMessageID() string {
+	return p.messageID
+}
+
+// MessageIDDomain returns a copy of MailBuilder that generates Message-ID and Content-ID values
+// in the specified domain.  The domain of the From address is used by default.  Generated IDs use
+// the random source configured by RandSeed.
+func (p MailBuilder) MessageIDDomain(domain string) MailBuilder {
+	p.messageIDDomain = domain
+	return p
+}
+
+// InReplyTo returns a copy of MailBuilder with the specified In-Reply-To header, listing the
+// message IDs of the messages being replied to.  IDs may be given with or without angle brackets,
+// and several may be given in a single whitespace separated string.
+func (p MailBuilder) InReplyTo(ids ...string) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	parsed, err := parseMessageIDs(ids...)
+	if err != nil {
+		p.err = err
+		return p
+	}
+	p.inReplyTo = parsed
+	return p
+}
+
+// GetInReplyTo returns a copy of the stored In-Reply-To message IDs, without angle brackets.
+func (p *MailBuilder) GetInReplyTo() []string {
+	return slices.Clone(p.inReplyTo)
+}
+
+// References returns a copy of MailBuilder with the specified References header, listing the
+// message IDs of the thread being replied to, oldest first.  IDs are given as for InReplyTo.
+func (p MailBuilder) References(ids ...string) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	parsed, err := parseMessageIDs(ids...)
+	if err != nil {
+		p.err = err
+		return p
+	}
+	p.references = parsed
+	return p
+}
+
+// GetReferences returns a copy of the stored References message IDs, without angle brackets.
+func (p *MailBuilder) GetReferences() []string {
+	return slices.Clone(p.references)
+}
+
+// DispositionNotificationTo returns a copy of MailBuilder with this name & address appended to the
+// Disposition-Notification-To header, requesting a read receipt (RFC 8098).  name may be empty.
+func (p MailBuilder) DispositionNotificationTo(name, addr string) MailBuilder {
+	if len(addr) > 0 {
+		p.dispNotificationTo = append(p.dispNotificationTo, mail.Address{Name: name, Address: addr})
+	}
+	return p
+}
+
+// GetDispositionNotificationTo returns a copy of the stored Disposition-Notification-To addresses.
+func (p *MailBuilder) GetDispositionNotificationTo() []mail.Address {
+	return slices.Clone(p.dispNotificationTo)
+}
+
+// Importance returns a copy of MailBuilder with the specified Importance header, one of "high",
+// "normal" or "low" (RFC 2156).  An empty string removes the header.
+func (p MailBuilder) Importance(importance string) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	importance = strings.ToLower(importance)
+	switch importance {
+	case "", "high", "normal", "low":
+		p.importance = importance
+	default:
+		p.err = errors.Errorf("invalid importance: %q", importance)
+	}
+	return p
+}
+
+// Priority returns a copy of MailBuilder with the specified Priority header, one of "urgent",
+// "normal" or "non-urgent" (RFC 2156).  An empty string removes the header.
+func (p MailBuilder) Priority(priority string) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	priority = strings.ToLower(priority)
+	switch priority {
+	case "", "urgent", "normal", "non-urgent":
+		p.priority = priority
+	default:
+		p.err = errors.Errorf("invalid priority: %q", priority)
+	}
+	return p
+}
+
 // Subject returns a copy of MailBuilder with the specified Subject header.
 func (p MailBuilder) Subject(subject string) MailBuilder {
 	p.subject = subject
@@ -198,6 +370,13 @@ func (p MailBuilder) Text(body []byte) MailBuilder {
 	return p
 }
 
+// FlowedText returns a copy of MailBuilder that will encode its text/plain Part as RFC 3676
+// format=flowed when flowed is true, wrapping long lines with soft line breaks at 78 columns.
+func (p MailBuilder) FlowedText(flowed bool) MailBuilder {
+	p.flowed = flowed
+	return p
+}
+
 // GetText returns a copy of the stored text/plain part.
 func (p *MailBuilder) GetText() []byte {
 	text := make([]byte, 0, len(p.text))
@@ -211,6 +390,76 @@ func (p MailBuilder) HTML(body []byte) MailBuilder {
 	return p
 }
 
+// TextTemplate returns a copy of MailBuilder that will use the output of the text template applied
+// to data for its text/plain Part.  Any error executing the template is returned by Error and Build.
+func (p MailBuilder) TextTemplate(tmpl *texttemplate.Template, data any) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		p.err = errors.WithMessagef(err, "failed to execute text template %q", tmpl.Name())
+		return p
+	}
+	return p.Text(buf.Bytes())
+}
+
+// HTMLTemplate returns a copy of MailBuilder that will use the output of the HTML template applied
+// to data for its text/html Part.  Any error executing the template is returned by Error and Build.
+func (p MailBuilder) HTMLTemplate(tmpl *htmltemplate.Template, data any) MailBuilder {
+	// Only allow first p.err value
+	if p.err != nil {
+		return p
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		p.err = errors.WithMessagef(err, "failed to execute HTML template %q", tmpl.Name())
+		return p
+	}
+	return p.HTML(buf.Bytes())
+}
+
+// TextFromHTML returns a copy of MailBuilder that, when enabled and no text has been provided,
+// generates the text/plain Part from the HTML body using html2text.
+func (p MailBuilder) TextFromHTML(enabled bool) MailBuilder {
+	p.textFromHTML = enabled
+	return p
+}
+
+// InlineCSS returns a copy of MailBuilder that, when enabled, applies the rules of <style>
+// elements within the HTML body to the style attributes of the elements they match, as many mail
+// clients ignore style sheets.  Rules that cannot be inlined, such as @media queries and
+// pseudo-classes, are left in place.
+func (p MailBuilder) InlineCSS(enabled bool) MailBuilder {
+	p.inlineCSS = enabled
+	return p
+}
+
+// EmbedImages returns a copy of MailBuilder that, when enabled, embeds local image files referenced
+// by the HTML body as inline parts.  Image sources and background attributes holding relative or
+// absolute paths, or file: URLs, are rewritten to cid: URLs referencing the embedded parts.
+// Relative paths are resolved against the working directory.  Build fails if an image cannot be
+// read, or a referenced file is not an image.
+//
+// Any image readable by the process may be embedded, so HTML which is untrusted, or includes
+// untrusted data such as template values, must use EmbedImagesFS to confine images to a
+// directory.
+func (p MailBuilder) EmbedImages(enabled bool) MailBuilder {
+	p.embedImages = enabled
+	p.embedFS = nil
+	return p
+}
+
+// EmbedImagesFS returns a copy of MailBuilder that embeds images referenced by the HTML body from
+// fsys, as EmbedImages does for local files.  Paths are resolved relative to the root of fsys.  A
+// nil fsys disables embedding.
+func (p MailBuilder) EmbedImagesFS(fsys fs.FS) MailBuilder {
+	p.embedImages = fsys != nil
+	p.embedFS = fsys
+	return p
+}
+
 // GetHTML returns a copy of the stored text/html part.
 func (p *MailBuilder) GetHTML() []byte {
 	html := make([]byte, 0, len(p.html))
@@ -339,6 +588,22 @@ func (p MailBuilder) Build() (*Part, error) {
 	if len(p.to)+len(p.cc)+len(p.bcc) == 0 {
 		return nil, errors.New(ErrorMissingRecipient)
 	}
+	joinAddress := stringutil.JoinAddressUTF8
+	from := []mail.Address{p.from}
+	var sender []mail.Address
+	if p.sender.Address != "" {
+		sender = []mail.Address{p.sender}
+	}
+	to, cc, replyTo, dnt := p.to, p.cc, p.replyTo, p.dispNotificationTo
+	if !p.smtpUTF8 {
+		joinAddress = stringutil.JoinAddress
+		var err error
+		for _, addrs := range []*[]mail.Address{&from, &sender, &to, &cc, &replyTo, &dnt} {
+			if *addrs, err = asciiAddrs(*addrs); err != nil {
+				return nil, err
+			}
+		}
+	}
 	// Fully loaded structure; the presence of text, html, inlines, and attachments will determine
 	// how much is necessary:
 	//
@@ -352,15 +617,47 @@ func (p MailBuilder) Build() (*Part, error) {
 	//  `- attachments..
 	//
 	// We build this tree starting at the leaves, re-rooting as needed.
+	htmlBody, textBody := p.html, p.text
+	if htmlBody != nil && p.inlineCSS {
+		var err error
+		if htmlBody, err = inlineCSS(htmlBody); err != nil {
+			return nil, err
+		}
+	}
+	if textBody == nil && htmlBody != nil && p.textFromHTML {
+		text, err := html2text.FromString(string(htmlBody))
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to convert HTML to text")
+		}
+		textBody = []byte(text)
+	}
+	if htmlBody != nil && p.embedImages {
+		var images []*Part
+		var err error
+		htmlBody, images, err = embedImages(htmlBody, p.embedFS, func() string {
+			return p.newID(from[0].Address)
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Clone to avoid appending to a slice shared with other builders.
+		p.inlines = slices.Clone(p.inlines)
+		for _, ip := range images {
+			p = p.AddInline(ip.Content, ip.ContentType, ip.FileName, ip.ContentID)
+		}
+	}
 	var root, part *Part
-	if p.text != nil || p.html == nil {
+	if textBody != nil || htmlBody == nil {
 		root = NewPart(ctTextPlain)
-		root.Content = p.text
+		root.Content = textBody
 		root.Charset = utf8
+		if p.flowed {
+			root.setupFlowedText()
+		}
 	}
-	if p.html != nil {
+	if htmlBody != nil {
 		part = NewPart(ctTextHTML)
-		part.Content = p.html
+		part.Content = htmlBody
 		part.Charset = utf8
 		if root == nil {
 			root = part
@@ -368,7 +665,7 @@ func (p MailBuilder) Build() (*Part, error) {
 			root.NextSibling = part
 		}
 	}
-	if p.text != nil && p.html != nil {
+	if textBody != nil && htmlBody != nil {
 		// Wrap Text & HTML bodies
 		part = root
 		root = NewPart(ctMultipartAltern)
@@ -401,16 +698,39 @@ func (p MailBuilder) Build() (*Part, error) {
 	// Headers
 	h := root.Header
 	h.Set(hnMIMEVersion, "1.0")
-	h.Set("From", p.from.String())
+	h.Set("From", joinAddress(from))
 	h.Set("Subject", p.subject)
-	if len(p.to) > 0 {
-		h.Set("To", stringutil.JoinAddress(p.to))
+	if len(to) > 0 {
+		h.Set("To", joinAddress(to))
+	}
+	if len(cc) > 0 {
+		h.Set("Cc", joinAddress(cc))
+	}
+	if len(replyTo) > 0 {
+		h.Set("Reply-To", joinAddress(replyTo))
+	}
+	if len(sender) > 0 {
+		h.Set("Sender", joinAddress(sender))
+	}
+	if len(dnt) > 0 {
+		h.Set("Disposition-Notification-To", joinAddress(dnt))
+	}
+	if p.messageID != "" {
+		h.Set("Message-Id", "<"+p.messageID+">")
+	} else if p.header.Get("Message-Id") == "" {
+		h.Set("Message-Id", "<"+p.newID(from[0].Address)+">")
+	}
+	if len(p.inReplyTo) > 0 {
+		h.Set("In-Reply-To", formatMessageIDs(p.inReplyTo))
+	}
+	if len(p.references) > 0 {
+		h.Set("References", formatMessageIDs(p.references))
 	}
-	if len(p.cc) > 0 {
-		h.Set("Cc", stringutil.JoinAddress(p.cc))
+	if p.importance != "" {
+		h.Set("Importance", p.importance)
 	}
-	if len(p.replyTo) > 0 {
-		h.Set("Reply-To", stringutil.JoinAddress(p.replyTo))
+	if p.priority != "" {
+		h.Set("Priority", p.priority)
 	}
 	date := p.date
 	if date.IsZero() {
@@ -418,10 +738,17 @@ func (p MailBuilder) Build() (*Part, error) {
 	}
 	h.Set("Date", date.Format(time.RFC1123Z))
 	for k, v := range p.header {
+		if k == "Message-Id" && p.messageID != "" {
+			// Replaced by MessageID.
+			continue
+		}
 		for _, s := range v {
 			h.Add(k, s)
 		}
 	}
+	if p.smtpUTF8 {
+		root.WithEncoder(NewEncoder(SMTPUTF8(true)))
+	}
 	if r := p.randSource; r != nil {
 		// Traverse all parts, discard match result.
 		_ = root.DepthMatchAll(func(part *Part) bool {
@@ -432,17 +759,95 @@ func (p MailBuilder) Build() (*Part, error) {
 	return root, nil
 }
 
+// newID generates a unique Message-ID or Content-ID, without angle brackets, in the configured
+// domain, that of the from address, or else the host name.
+func (p MailBuilder) newID(from string) string {
+	domain := p.messageIDDomain
+	if domain == "" {
+		if i := strings.LastIndexByte(from, '@'); i >= 0 && i+1 < len(from) {
+			domain = from[i+1:]
+		} else {
+			domain = hostDomain()
+		}
+	}
+	return stringutil.UUID(p.randSource) + "@" + domain
+}
+
+// hostDomain returns the host name for use as the right side of a message ID, or
+// localhost.localdomain if it is unavailable or unsuitable.
+func hostDomain() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" || strings.ContainsAny(host, "@<>()[]\\\" \t\r\n") {
+		return "localhost.localdomain"
+	}
+	return host
+}
+
+// parseMessageIDs splits whitespace separated message IDs, removing any angle brackets.  An error
+// is returned for IDs not of the form left@right.
+func parseMessageIDs(ids ...string) ([]string, error) {
+	var out []string
+	for _, s := range ids {
+		for _, id := range strings.Fields(s) {
+			id = strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+			left, right, ok := strings.Cut(id, "@")
+			if !ok || left == "" || right == "" || strings.ContainsAny(id, "<>") ||
+				needsEncodedWord(id) {
+				return nil, errors.Errorf("invalid message ID: %q", s)
+			}
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// formatMessageIDs formats message IDs as a header value.
+func formatMessageIDs(ids []string) string {
+	return "<" + strings.Join(ids, "> <") + ">"
+}
+
+// asciiAddrs returns a copy of addrs with IDN domains converted to punycode.  An error is returned
+// for addresses with non-ASCII local parts.
+func asciiAddrs(addrs []mail.Address) ([]mail.Address, error) {
+	var out []mail.Address
+	for i, a := range addrs {
+		ascii, err := stringutil.ToASCIIAddress(a.Address)
+		if err != nil {
+			return nil, err
+		}
+		if ascii != a.Address && out == nil {
+			out = make([]mail.Address, len(addrs))
+			copy(out, addrs)
+		}
+		if out != nil {
+			out[i].Address = ascii
+		}
+	}
+	if out == nil {
+		return addrs, nil
+	}
+	return out, nil
+}
+
 // SendWithReversePath encodes the message and sends it via the specified Sender.
 func (p MailBuilder) SendWithReversePath(sender Sender, from string) error {
-	buf := &bytes.Buffer{}
 	root, err := p.Build()
 	if err != nil {
 		return err
 	}
-	err = root.Encode(buf)
-	if err != nil {
+	recips := p.recipients()
+	if ps, ok := sender.(PartSender); ok {
+		return ps.SendPart(from, recips, root)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
 		return err
 	}
+	return sender.Send(from, recips, buf.Bytes())
+}
+
+// recipients returns the addresses of the To, Cc, and Bcc recipients.
+func (p MailBuilder) recipients() []string {
 	recips := make([]string, 0, len(p.to)+len(p.cc)+len(p.bcc))
 	for _, a := range p.to {
 		recips = append(recips, a.Address)
@@ -453,7 +858,7 @@ func (p MailBuilder) SendWithReversePath(sender Sender, from string) error {
 	for _, a := range p.bcc {
 		recips = append(recips, a.Address)
 	}
-	return sender.Send(from, recips, buf.Bytes())
+	return recips
 }
 
 // Send encodes the message and sends it via the specified Sender, using the address provided to
@@ -2,12 +2,16 @@ package enmime_test
 
 import (
 	"bytes"
+	htmltemplate "html/template"
 	"net/mail"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/jhillyerd/enmime/v2"
@@ -1066,7 +1070,8 @@ func TestBuilderQPHeaders(t *testing.T) {
 		To("Keld Jørn Simonsen", "keld@dkuug.dk").
 		From("Olle Järnefors", "ojarnef@admin.kth.se").
 		Subject("RFC 2047").
-		Date(time.Date(2017, 1, 1, 13, 14, 15, 16, time.UTC))
+		Date(time.Date(2017, 1, 1, 13, 14, 15, 16, time.UTC)).
+		MessageID("rfc2047@admin.kth.se")
 	p, err := msg.Build()
 	if err != nil {
 		t.Fatal(err)
@@ -1206,3 +1211,294 @@ func TestEmptyCC(t *testing.T) {
 		t.Fatalf("Unexpected error, wanted %q got %s", enmime.ErrorMissingRecipient, err)
 	}
 }
+
+func TestBuilderSMTPUTF8(t *testing.T) {
+	b := enmime.Builder().
+		From("Jösé", "用户@例子.广告").
+		To("Bob, Jr.", "bob@bücher.de").
+		Subject("Grüße").
+		Text([]byte("text"))
+
+	// Without SMTPUTF8, UTF-8 local parts cannot be represented.
+	_, err := b.Build()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SMTPUTF8")
+
+	root, err := b.SMTPUTF8(true).Build()
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, root.Encode(buf))
+	msg := buf.String()
+	assert.Contains(t, msg, "From: Jösé <用户@例子.广告>\r\n")
+	assert.Contains(t, msg, "To: \"Bob, Jr.\" <bob@bücher.de>\r\n")
+	assert.Contains(t, msg, "Subject: Grüße\r\n")
+
+	env, err := enmime.ReadEnvelope(buf)
+	require.NoError(t, err)
+	from, err := env.AddressList("From")
+	require.NoError(t, err)
+	assert.Equal(t, []*mail.Address{{Name: "Jösé", Address: "用户@例子.广告"}}, from)
+}
+
+func TestBuilderPunycode(t *testing.T) {
+	root, err := enmime.Builder().
+		From("Alice", "alice@bücher.de").
+		To("", "bob@例子.广告").
+		Text([]byte("text")).
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, `"Alice" <alice@xn--bcher-kva.de>`, root.Header.Get("From"))
+	assert.Equal(t, "<bob@xn--fsqu00a.xn--4rr70v>", root.Header.Get("To"))
+}
+
+func TestBuilderTemplates(t *testing.T) {
+	type order struct {
+		Name  string
+		Items []string
+	}
+	data := order{Name: "<Bob>", Items: []string{"Widget", "Gadget"}}
+	htmlTmpl := htmltemplate.Must(htmltemplate.New("html").Parse(
+		`<html><body><h1>Hello {{.Name}}</h1><ul>{{range .Items}}<li>{{.}}</li>{{end}}</ul>` +
+			`</body></html>`))
+	textTmpl := texttemplate.Must(texttemplate.New("text").Parse(
+		"Hello {{.Name}}\n{{range .Items}}* {{.}}\n{{end}}"))
+
+	b := enmime.Builder().
+		From("", "shop@example.com").
+		To("", "bob@example.com").
+		Subject("Order")
+
+	p, err := b.HTMLTemplate(htmlTmpl, data).TextTemplate(textTmpl, data).Build()
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", p.ContentType)
+	assert.Equal(t, "Hello <Bob>\n* Widget\n* Gadget\n", string(p.FirstChild.Content))
+	assert.Contains(t, string(p.FirstChild.NextSibling.Content), "<h1>Hello &lt;Bob&gt;</h1>")
+
+	// Text generated from HTML.
+	p, err = b.HTMLTemplate(htmlTmpl, data).TextFromHTML(true).Build()
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", p.ContentType)
+	assert.Contains(t, string(p.FirstChild.Content), "Hello <Bob>")
+	assert.Contains(t, string(p.FirstChild.Content), "Widget")
+
+	// Explicit text takes precedence.
+	p, err = b.HTMLTemplate(htmlTmpl, data).Text([]byte("plain")).TextFromHTML(true).Build()
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(p.FirstChild.Content))
+
+	// Template errors are reported by Build.
+	bad := texttemplate.Must(texttemplate.New("bad").Parse("{{.Missing}}"))
+	b = b.TextTemplate(bad, data)
+	require.Error(t, b.Error())
+	assert.Contains(t, b.Error().Error(), `"bad"`)
+	_, err = b.Build()
+	require.Error(t, err)
+}
+
+func TestBuilderInlineCSS(t *testing.T) {
+	html := `<html><head><style>
+/* comment */
+p { color: red; margin: 0 }
+.note { color: blue }
+div > p.note { font-weight: bold }
+#main p { font-size: 12px }
+a:hover { color: green }
+@media (max-width: 600px) { p { margin: 4px } }
+</style></head><body><div id="main"><p>One</p><p class="note" style="margin: 2px">Two</p>` +
+		`</div><a href="#">Link</a></body></html>`
+
+	p, err := enmime.Builder().
+		From("", "a@example.com").
+		To("", "b@example.com").
+		HTML([]byte(html)).
+		InlineCSS(true).
+		Build()
+	require.NoError(t, err)
+	got := string(p.Content)
+	assert.Contains(t, got, `<p style="color: red; margin: 0; font-size: 12px">One</p>`)
+	assert.Contains(t, got, `<p class="note" style="color: blue; margin: 2px; font-weight: bold; `+
+		`font-size: 12px">Two</p>`)
+	assert.Contains(t, got, `<a href="#">Link</a>`)
+	assert.Contains(t, got, "a:hover { color: green }")
+	assert.Contains(t, got, "@media (max-width: 600px) { p { margin: 4px } }")
+	assert.NotContains(t, got, "comment")
+	assert.NotContains(t, got, ".note {")
+
+	// Style sheets consisting only of inlined rules are removed.
+	p, err = enmime.Builder().
+		From("", "a@example.com").
+		To("", "b@example.com").
+		HTML([]byte(`<style>b { color: red }</style><b>x</b>`)).
+		InlineCSS(true).
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, `<html><head></head><body><b style="color: red">x</b></body></html>`,
+		string(p.Content))
+}
+
+func TestBuilderEmbedImages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"img/logo.png": {Data: []byte("\x89PNG\r\n\x1a\nlogo")},
+		"photo":        {Data: []byte("GIF89a photo")},
+	}
+	html := `<p><img src="img/logo.png"><img src="/img/logo.png"><img src="photo">` +
+		`<img src="https://example.com/remote.png"><img src="cid:other@example.com">` +
+		`<img src="data:image/png;base64,AAAA"></p><table background="./photo"></table>`
+
+	b := enmime.Builder().
+		From("", "a@example.com").
+		To("", "b@example.com").
+		HTML([]byte(html)).
+		AddInline([]byte("x"), "image/jpeg", "other.jpg", "other@example.com")
+	p, err := b.EmbedImagesFS(fsys).Build()
+	require.NoError(t, err)
+
+	require.Equal(t, "multipart/related", p.ContentType)
+	require.NotNil(t, p.FirstChild)
+	got := string(p.FirstChild.Content)
+	assert.Contains(t, got, `src="https://example.com/remote.png"`)
+	assert.Contains(t, got, `src="cid:other@example.com"`)
+	assert.Contains(t, got, `src="data:image/png;base64,AAAA"`)
+
+	// Existing inline, followed by one part per distinct image.
+	var inlines []*enmime.Part
+	for c := p.FirstChild.NextSibling; c != nil; c = c.NextSibling {
+		inlines = append(inlines, c)
+	}
+	require.Len(t, inlines, 3)
+	assert.Equal(t, "other@example.com", inlines[0].ContentID)
+	logo, photo := inlines[1], inlines[2]
+	assert.Equal(t, "image/png", logo.ContentType)
+	assert.Equal(t, "logo.png", logo.FileName)
+	assert.Equal(t, "inline", logo.Disposition)
+	assert.True(t, strings.HasSuffix(logo.ContentID, "@example.com"), logo.ContentID)
+	assert.Equal(t, "image/gif", photo.ContentType)
+	assert.Equal(t, "photo", photo.FileName)
+	assert.Equal(t, 2, strings.Count(got, `src="cid:`+logo.ContentID+`"`))
+	assert.Contains(t, got, `src="cid:`+photo.ContentID+`"`)
+	assert.Contains(t, got, `background="cid:`+photo.ContentID+`"`)
+
+	// The original builder is unaffected.
+	p, err = b.Build()
+	require.NoError(t, err)
+	assert.Equal(t, html, string(p.FirstChild.Content))
+	assert.Nil(t, p.FirstChild.NextSibling.NextSibling)
+
+	// Missing images fail the build.
+	_, err = b.HTML([]byte(`<img src="missing.png">`)).EmbedImagesFS(fsys).Build()
+	assert.ErrorContains(t, err, "missing.png")
+}
+
+func TestBuilderEmbedImagesNotImage(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "passwd")
+	require.NoError(t, os.WriteFile(name, []byte("root:x:0:0:root:/root:/bin/sh\n"), 0o600))
+	fsys := fstest.MapFS{"notes.txt": {Data: []byte("secret")}}
+	b := enmime.Builder().From("", "a@example.com").To("", "b@example.com")
+
+	// Files which are not images are not embedded, by content or extension.
+	_, err := b.HTML([]byte(`<img src="` + name + `">`)).EmbedImages(true).Build()
+	assert.ErrorContains(t, err, "not an image")
+	_, err = b.HTML([]byte(`<img src="notes.txt">`)).EmbedImagesFS(fsys).Build()
+	assert.ErrorContains(t, err, "not an image")
+}
+
+func TestBuilderEmbedLocalImages(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "logo.png")
+	require.NoError(t, os.WriteFile(name, []byte("\x89PNG\r\n\x1a\nlogo"), 0o600))
+	html := `<img src="` + name + `"><img src="file://` + filepath.ToSlash(name) + `">`
+
+	p, err := enmime.Builder().
+		From("", "a@example.com").
+		To("", "b@example.com").
+		HTML([]byte(html)).
+		EmbedImages(true).
+		RandSeed(1).
+		Build()
+	require.NoError(t, err)
+	require.Equal(t, "multipart/related", p.ContentType)
+	img := p.FirstChild.NextSibling
+	require.NotNil(t, img)
+	assert.Nil(t, img.NextSibling)
+	assert.Equal(t, "logo.png", img.FileName)
+	assert.Equal(t, 2, strings.Count(string(p.FirstChild.Content), `src="cid:`+img.ContentID+`"`))
+}
+
+func TestBuilderMessageID(t *testing.T) {
+	b := enmime.Builder().From("", "a@example.com").To("", "b@example.com")
+
+	p, err := b.MessageID("<id@example.com>").Build()
+	require.NoError(t, err)
+	assert.Equal(t, "<id@example.com>", p.Header.Get("Message-ID"))
+	withID := b.MessageID("id@example.com")
+	assert.Equal(t, "id@example.com", withID.GetMessageID())
+
+	// Generated IDs are unique, and stable with a random seed.
+	p, err = b.Build()
+	require.NoError(t, err)
+	first := p.Header.Get("Message-ID")
+	assert.Regexp(t, `^<[0-9a-f-]{36}@example\.com>$`, first)
+	p, err = b.Build()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, p.Header.Get("Message-ID"))
+	p, err = b.RandSeed(1).MessageIDDomain("mail.example.org").Build()
+	require.NoError(t, err)
+	assert.Equal(t, "<52fdfc07-2182-454f-963f-5f0f9a621d72@mail.example.org>",
+		p.Header.Get("Message-ID"))
+
+	// A Message-ID header is not replaced.
+	p, err = b.Header("Message-ID", "<custom@example.com>").Build()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"<custom@example.com>"}, p.Header.Values("Message-ID"))
+
+	// MessageID replaces a Message-ID header, rather than adding a second one.
+	p, err = b.Header("Message-ID", "<custom@example.com>").MessageID("id@example.com").Build()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"<id@example.com>"}, p.Header.Values("Message-ID"))
+
+	// The host name is used when the from address has no domain.
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	p, err = enmime.Builder().From("", "alice").To("", "b@example.com").Build()
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(p.Header.Get("Message-ID"), "@"+host+">"),
+		p.Header.Get("Message-ID"))
+
+	for _, id := range []string{"no-at", "@example.com", "a@", "a b@example.com", "<a>@example.com"} {
+		_, err = b.MessageID(id).Build()
+		assert.Error(t, err, id)
+	}
+}
+
+func TestBuilderIdentityHeaders(t *testing.T) {
+	b := enmime.Builder().
+		From("", "a@example.com").
+		To("", "b@example.com").
+		Sender("Mailer", "mailer@example.com").
+		InReplyTo("<2@example.com>").
+		References("<0@example.com> 1@example.com", "<2@example.com>").
+		DispositionNotificationTo("Alice", "a@example.com").
+		Importance("High").
+		Priority("non-urgent")
+	p, err := b.Build()
+	require.NoError(t, err)
+	assert.Equal(t, `"Mailer" <mailer@example.com>`, p.Header.Get("Sender"))
+	assert.Equal(t, "<2@example.com>", p.Header.Get("In-Reply-To"))
+	assert.Equal(t, "<0@example.com> <1@example.com> <2@example.com>", p.Header.Get("References"))
+	assert.Equal(t, `"Alice" <a@example.com>`, p.Header.Get("Disposition-Notification-To"))
+	assert.Equal(t, "high", p.Header.Get("Importance"))
+	assert.Equal(t, "non-urgent", p.Header.Get("Priority"))
+	assert.Equal(t, []string{"0@example.com", "1@example.com", "2@example.com"}, b.GetReferences())
+	assert.Equal(t, "mailer@example.com", b.GetSender().Address)
+
+	// Empty values remove the headers.
+	p, err = b.Importance("").Priority("").Build()
+	require.NoError(t, err)
+	assert.Empty(t, p.Header.Get("Importance"))
+	assert.Empty(t, p.Header.Get("Priority"))
+
+	assert.Error(t, b.Importance("urgent").Error())
+	assert.Error(t, b.Priority("high").Error())
+	assert.Error(t, b.InReplyTo("bad").Error())
+}
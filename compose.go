@@ -0,0 +1,431 @@
+package enmime
+
+import (
+	"bytes"
+	"maps"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ForwardMode selects how ForwardBuilder includes the original message.
+type ForwardMode int
+
+const (
+	// ForwardInline includes the original headers and bodies within the text and HTML bodies of
+	// the forward, followed by the original attachments and inlines.
+	ForwardInline ForwardMode = iota
+	// ForwardAttachment attaches the original message as a message/rfc822 part.
+	ForwardAttachment
+)
+
+var (
+	replySubjectRegexp   = regexp.MustCompile(`(?i)^\s*re\s*(\[\d+\])?\s*:`)
+	forwardSubjectRegexp = regexp.MustCompile(`(?i)^\s*(fwd?|fw)\s*(\[\d+\])?\s*:`)
+)
+
+// Inline style of quoted history within HTML replies.
+const htmlQuoteStyle = "margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex"
+
+// ReplyBuilder returns a MailBuilder pre-filled to reply to the author of e.  The reply is
+// addressed to the Reply-To addresses of e, or the From addresses without Reply-To.  When those
+// are all self addresses, as when replying to a sent message, the original To addresses are used
+// instead.  self lists the addresses of the user replying; they are compared case-insensitively.
+//
+// The subject is prefixed with "Re:", In-Reply-To and References are set from the Message-ID and
+// References of e, and the text and HTML bodies quote those of e beneath an attribution line.
+// Inlines of e are included so that quoted HTML may reference them.  The From address must still
+// be set, and new content prepended to the bodies.  Errors are returned by Error and Build.
+func ReplyBuilder(e *Envelope, self ...string) MailBuilder {
+	return replyBuilder(e, false, self)
+}
+
+// ReplyAllBuilder returns a MailBuilder pre-filled to reply to the author and recipients of e,
+// as ReplyBuilder does.  When e has a Mail-Followup-To header, the reply is addressed to those
+// addresses alone.  Otherwise, the author is placed in To and the original To and Cc recipients in
+// Cc.  self addresses and duplicates are removed from the recipients.
+func ReplyAllBuilder(e *Envelope, self ...string) MailBuilder {
+	return replyBuilder(e, true, self)
+}
+
+// ForwardBuilder returns a MailBuilder pre-filled to forward e, with the subject prefixed by
+// "Fwd:".  mode selects whether e is included inline or as a message/rfc822 attachment.  The From
+// and recipient addresses must still be set.  Errors are returned by Error and Build.
+func ForwardBuilder(e *Envelope, mode ForwardMode) MailBuilder {
+	p := Builder()
+	subject := e.GetHeader("Subject")
+	if !forwardSubjectRegexp.MatchString(subject) {
+		subject = "Fwd: " + subject
+	}
+	p = p.Subject(subject)
+
+	if mode == ForwardAttachment {
+		if e.Root == nil {
+			p.err = errors.New("envelope has no root part to forward")
+			return p
+		}
+		buf := &bytes.Buffer{}
+		if err := forwardCopy(e.Root, nil).Encode(buf); err != nil {
+			p.err = errors.WithMessage(err, "failed to encode forwarded message")
+			return p
+		}
+		name := strings.TrimSpace(e.GetHeader("Subject"))
+		if name == "" {
+			name = "message"
+		}
+		return p.Text([]byte{}).AddAttachment(buf.Bytes(), "message/rfc822", name+".eml")
+	}
+
+	fields := [][2]string{
+		{"From", e.GetHeader("From")},
+		{"Date", e.GetHeader("Date")},
+		{"Subject", e.GetHeader("Subject")},
+		{"To", e.GetHeader("To")},
+		{"Cc", e.GetHeader("Cc")},
+	}
+	text := &strings.Builder{}
+	text.WriteString("\n\n---------- Forwarded message ---------\n")
+	for _, f := range fields {
+		if f[1] != "" {
+			text.WriteString(f[0] + ": " + f[1] + "\n")
+		}
+	}
+	text.WriteString("\n" + strings.ReplaceAll(e.Text, "\r\n", "\n"))
+	p = p.Text([]byte(text.String()))
+
+	if e.HTML != "" {
+		body, err := htmlBodyContent(e.HTML)
+		if err != nil {
+			p.err = err
+			return p
+		}
+		h := &strings.Builder{}
+		h.WriteString(`<br><div class="enmime_forward">---------- Forwarded message ---------<br>`)
+		for _, f := range fields {
+			if f[1] != "" {
+				h.WriteString(f[0] + ": " + html.EscapeString(f[1]) + "<br>")
+			}
+		}
+		h.WriteString("<br>" + body + "</div>")
+		p = p.HTML([]byte(h.String()))
+	}
+	for _, a := range e.Attachments {
+		p = p.AddAttachment(a.Content, a.ContentType, a.FileName)
+	}
+	return addEnvelopeInlines(p, e)
+}
+
+// replyBuilder implements ReplyBuilder and ReplyAllBuilder.
+func replyBuilder(e *Envelope, all bool, self []string) MailBuilder {
+	p := Builder()
+	isSelf := func(a *mail.Address) bool {
+		for _, s := range self {
+			if strings.EqualFold(a.Address, s) {
+				return true
+			}
+		}
+		return false
+	}
+	seen := make(map[string]bool)
+	recipients := func(addrs []*mail.Address) []mail.Address {
+		var out []mail.Address
+		for _, a := range addrs {
+			key := strings.ToLower(a.Address)
+			if seen[key] || isSelf(a) {
+				continue
+			}
+			seen[key] = true
+			out = append(out, *a)
+		}
+		return out
+	}
+
+	var to, cc []mail.Address
+	followup := envelopeAddrs(e, "Mail-Followup-To")
+	if all && len(followup) > 0 {
+		to = recipients(followup)
+	} else {
+		author := envelopeAddrs(e, "Reply-To")
+		if len(author) == 0 {
+			author = envelopeAddrs(e, "From")
+		}
+		to = recipients(author)
+		if len(to) == 0 {
+			// Replying to a message sent by self.
+			to = recipients(envelopeAddrs(e, "To"))
+		}
+		if all {
+			cc = recipients(append(envelopeAddrs(e, "To"), envelopeAddrs(e, "Cc")...))
+		}
+	}
+	p = p.ToAddrs(to).CCAddrs(cc)
+
+	subject := e.GetHeader("Subject")
+	if !replySubjectRegexp.MatchString(subject) {
+		subject = "Re: " + subject
+	}
+	p = p.Subject(subject)
+
+	msgID := envelopeMessageIDs(e, "Message-ID")
+	refs := envelopeMessageIDs(e, "References")
+	if len(refs) == 0 {
+		// RFC 5322 section 3.6.4: use the In-Reply-To of the parent when it lacks References.
+		refs = envelopeMessageIDs(e, "In-Reply-To")
+	}
+	p.inReplyTo = msgID
+	p.references = append(refs, msgID...)
+
+	attribution := "wrote:"
+	if from := e.GetHeader("From"); from != "" {
+		attribution = from + " " + attribution
+	}
+	if date, err := e.Date(); err == nil {
+		attribution = "On " + date.Format("Mon, Jan 2, 2006 at 3:04 PM") + ", " + attribution
+	}
+	p = p.Text([]byte("\n\n" + attribution + "\n" + quoteText(e.Text)))
+
+	if e.HTML != "" {
+		body, err := htmlBodyContent(e.HTML)
+		if err != nil {
+			p.err = err
+			return p
+		}
+		p = p.HTML([]byte(`<br><div class="enmime_quote"><div>` + html.EscapeString(attribution) +
+			`</div><blockquote type="cite" style="` + htmlQuoteStyle + `">` + body +
+			`</blockquote></div>`))
+		p = addEnvelopeInlines(p, e)
+	}
+	return p
+}
+
+// envelopeAddrs returns the addresses of the named header of e, or nil if it is missing or cannot
+// be parsed.
+func envelopeAddrs(e *Envelope, name string) []*mail.Address {
+	if e.header == nil {
+		return nil
+	}
+	addrs, err := ParseAddressList(e.header.Get(name))
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// envelopeMessageIDs returns the valid message IDs of the named header of e, without angle
+// brackets.  Comments and malformed IDs are skipped.
+func envelopeMessageIDs(e *Envelope, name string) []string {
+	var ids []string
+	for _, f := range strings.Fields(e.GetHeader(name)) {
+		if id, err := parseMessageIDs(f); err == nil {
+			ids = append(ids, id...)
+		}
+	}
+	return ids
+}
+
+// addEnvelopeInlines returns a copy of p including the inlines of e.
+func addEnvelopeInlines(p MailBuilder, e *Envelope) MailBuilder {
+	for _, ip := range e.Inlines {
+		p = p.AddInline(ip.Content, ip.ContentType, ip.FileName, ip.ContentID)
+	}
+	return p
+}
+
+// quoteText prefixes each line of text with "> ", or ">" for blank and already quoted lines.
+func quoteText(text string) string {
+	if text == "" {
+		return ""
+	}
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// htmlBodyContent returns the rendered content of the body element of an HTML document.
+func htmlBodyContent(src string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse HTML")
+	}
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return src, nil
+	}
+	buf := &bytes.Buffer{}
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(buf, c); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	return buf.String(), nil
+}
+
+// ToBuilder returns a MailBuilder holding the content of e, so that it may be modified and
+// rebuilt.  Addresses, subject, date, message IDs, text and HTML bodies, inlines, attachments
+// and other parts are copied into the builder, along with the remaining headers of e.  Content-*
+// and MIME-Version headers, which Build generates, are excluded, as are trace and signature
+// headers such as Received and DKIM-Signature, which would not be valid for the new message.
+// The Message-ID of e is retained; clear it with MessageID("") to generate a new one.  The text
+// body is omitted when e.Text was converted from the HTML body.  Address headers that cannot be
+// parsed, and invalid Importance or Priority headers, are returned as errors by Error and Build.
+func (e *Envelope) ToBuilder() MailBuilder {
+	p := Builder()
+	addrs := func(name string) []mail.Address {
+		if e.header == nil || e.header.Get(name) == "" {
+			return nil
+		}
+		list, err := ParseAddressList(e.header.Get(name))
+		if err != nil {
+			if p.err == nil && err != mail.ErrHeaderNotPresent {
+				p.err = errors.WithMessagef(err, "failed to parse %s header", name)
+			}
+			return nil
+		}
+		out := make([]mail.Address, len(list))
+		for i, a := range list {
+			out[i] = *a
+		}
+		return out
+	}
+	if from := addrs("From"); len(from) > 0 {
+		p.from = from[0]
+	}
+	p.to, p.cc, p.bcc, p.replyTo = addrs("To"), addrs("Cc"), addrs("Bcc"), addrs("Reply-To")
+	if sender := addrs("Sender"); len(sender) > 0 {
+		p.sender = sender[0]
+	}
+	p.dispNotificationTo = addrs("Disposition-Notification-To")
+	p.subject = e.GetHeader("Subject")
+	if date, err := e.Date(); err == nil {
+		p.date = date
+	}
+	if ids := envelopeMessageIDs(e, "Message-ID"); len(ids) > 0 {
+		p.messageID = ids[0]
+	}
+	p.inReplyTo = envelopeMessageIDs(e, "In-Reply-To")
+	p.references = envelopeMessageIDs(e, "References")
+	p = p.Importance(strings.TrimSpace(e.GetHeader("Importance"))).
+		Priority(strings.TrimSpace(e.GetHeader("Priority")))
+	if e.header != nil {
+		for k, v := range *e.header {
+			lk := strings.ToLower(k)
+			if builderHeaders[lk] || traceHeaders[lk] || strings.HasPrefix(lk, "content-") ||
+				strings.HasPrefix(lk, "arc-") {
+				continue
+			}
+			for _, s := range v {
+				p = p.Header(k, s)
+			}
+		}
+	}
+
+	if e.HTML != "" {
+		p.html = []byte(e.HTML)
+	}
+	if e.HTML == "" || (e.Root != nil && e.Root.BreadthMatchFirst(func(p *Part) bool {
+		return p.ContentType == ctTextPlain && p.Disposition != cdAttachment
+	}) != nil) {
+		p.text = []byte(e.Text)
+	}
+	for _, ip := range e.Inlines {
+		p.inlines = append(p.inlines, builderPart(ip))
+	}
+	for _, op := range e.OtherParts {
+		p.inlines = append(p.inlines, builderPart(op))
+	}
+	for _, ap := range e.Attachments {
+		p.attachments = append(p.attachments, builderPart(ap))
+	}
+	return p
+}
+
+// forwardCopy returns a deep copy of p, its descendants and following siblings, which may be
+// encoded without modifying p.  Headers retain their original order.  Text decoded to UTF-8 when
+// parsed is labeled as UTF-8; content read with the RawContent option is copied as is.
+func forwardCopy(p, parent *Part) *Part {
+	if p == nil {
+		return nil
+	}
+	cp := &Part{}
+	*cp = *p
+	cp.Parent = parent
+	cp.Header = make(textproto.MIMEHeader, len(p.Header))
+	for k, v := range p.Header {
+		cp.Header[k] = slices.Clone(v)
+	}
+	if p.OrderedHeader != nil {
+		cp.OrderedHeader = NewOrderedHeader(p.OrderedHeader.Fields())
+	}
+	cp.ContentTypeParams = maps.Clone(p.ContentTypeParams)
+	// Clip, so that errors added while encoding are not appended to the array of p.
+	cp.Errors = slices.Clip(p.Errors)
+	if p.parser != nil && !p.parser.rawContent && p.TextContent() && p.Charset != "" {
+		cp.Charset = utf8
+	}
+	cp.FirstChild = forwardCopy(p.FirstChild, cp)
+	cp.NextSibling = forwardCopy(p.NextSibling, parent)
+	return cp
+}
+
+// builderHeaders are the lowercase names of headers set by MailBuilder.Build from its fields.
+var builderHeaders = map[string]bool{
+	"from":                        true,
+	"sender":                      true,
+	"to":                          true,
+	"cc":                          true,
+	"bcc":                         true,
+	"reply-to":                    true,
+	"disposition-notification-to": true,
+	"subject":                     true,
+	"date":                        true,
+	"message-id":                  true,
+	"in-reply-to":                 true,
+	"references":                  true,
+	"importance":                  true,
+	"priority":                    true,
+	"mime-version":                true,
+}
+
+// traceHeaders are the lowercase names of trace and signature headers added in transit, which
+// ToBuilder does not copy.  ARC-* headers are also excluded.
+var traceHeaders = map[string]bool{
+	"received":                true,
+	"return-path":             true,
+	"delivered-to":            true,
+	"x-original-to":           true,
+	"received-spf":            true,
+	"authentication-results":  true,
+	"dkim-signature":          true,
+	"domainkey-signature":     true,
+	"x-google-dkim-signature": true,
+	"x-received":              true,
+}
+
+// builderPart returns a new Part holding the decoded content and attributes of src, suitable for
+// adding to a MailBuilder.
+func builderPart(src *Part) *Part {
+	p := NewPart(src.ContentType)
+	p.Content = src.Content
+	p.Charset = src.Charset
+	if src.TextContent() && src.Charset != "" {
+		// Text content was converted to UTF-8 when parsed.
+		p.Charset = utf8
+	}
+	p.FileName = src.FileName
+	p.ContentID = src.ContentID
+	p.Disposition = src.Disposition
+	return p
+}
@@ -0,0 +1,337 @@
+package enmime_test
+
+import (
+	"bytes"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const composeMessage = "From: Bob <bob@example.com>\r\n" +
+	"To: Alice <alice@example.com>, carol@example.com\r\n" +
+	"Cc: Dave <dave@example.com>, ALICE@example.com\r\n" +
+	"Subject: Lunch\r\n" +
+	"Date: Mon, 01 Jan 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <2@example.com>\r\n" +
+	"References: <0@example.com> <1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Shall we meet?\r\n" +
+	"\r\n" +
+	"> Earlier\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<html><body><p>Shall we meet?</p></body></html>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; name=menu.txt\r\n" +
+	"Content-Disposition: attachment; filename=menu.txt\r\n" +
+	"\r\n" +
+	"Soup\r\n" +
+	"--outer--\r\n"
+
+func readComposeMessage(t *testing.T, headers string) *enmime.Envelope {
+	t.Helper()
+	e, err := enmime.ReadEnvelope(strings.NewReader(headers + composeMessage))
+	require.NoError(t, err)
+	return e
+}
+
+func TestReplyBuilder(t *testing.T) {
+	e := readComposeMessage(t, "")
+	p, err := enmime.ReplyBuilder(e, "alice@example.com").
+		From("Alice", "alice@example.com").
+		Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, "Re: Lunch", p.Header.Get("Subject"))
+	assert.Equal(t, `"Bob" <bob@example.com>`, p.Header.Get("To"))
+	assert.Empty(t, p.Header.Get("Cc"))
+	assert.Equal(t, "<2@example.com>", p.Header.Get("In-Reply-To"))
+	assert.Equal(t, "<0@example.com> <1@example.com> <2@example.com>", p.Header.Get("References"))
+
+	require.Equal(t, "multipart/alternative", p.ContentType)
+	text := string(p.FirstChild.Content)
+	assert.Equal(t, "\n\nOn Mon, Jan 1, 2024 at 10:00 AM, Bob <bob@example.com> wrote:\n"+
+		"> Shall we meet?\n>\n>> Earlier\n", text)
+	htm := string(p.FirstChild.NextSibling.Content)
+	assert.Contains(t, htm, `<blockquote type="cite"`)
+	assert.Contains(t, htm, "<p>Shall we meet?</p></blockquote>")
+
+	// The reply is recognized as quoting history.
+	reply := &enmime.Envelope{Text: "Sure.\n" + text, HTML: "<p>Sure.</p>" + htm}
+	sections, err := reply.Reply()
+	require.NoError(t, err)
+	assert.Equal(t, "Sure.", sections.Text)
+	assert.Contains(t, sections.HTML, "<p>Sure.</p>")
+	assert.NotContains(t, sections.HTML, "Shall we meet?")
+}
+
+func TestReplyBuilderRecipients(t *testing.T) {
+	tcases := []struct {
+		name, headers string
+		self          []string
+		all           bool
+		to, cc        string
+	}{
+		{
+			name:    "reply-to",
+			headers: "Reply-To: list@example.com\r\n",
+			self:    []string{"alice@example.com"},
+			to:      "<list@example.com>",
+		},
+		{
+			name: "own message",
+			self: []string{"BOB@example.com"},
+			to:   `"Alice" <alice@example.com>, <carol@example.com>`,
+		},
+		{
+			name: "all",
+			self: []string{"alice@example.com"},
+			all:  true,
+			to:   `"Bob" <bob@example.com>`,
+			cc:   `<carol@example.com>, "Dave" <dave@example.com>`,
+		},
+		{
+			name:    "all followup",
+			headers: "Mail-Followup-To: list@example.com, alice@example.com\r\n",
+			self:    []string{"alice@example.com"},
+			all:     true,
+			to:      "<list@example.com>",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := readComposeMessage(t, tc.headers)
+			b := enmime.ReplyBuilder(e, tc.self...)
+			if tc.all {
+				b = enmime.ReplyAllBuilder(e, tc.self...)
+			}
+			p, err := b.From("", "alice@example.com").Build()
+			require.NoError(t, err)
+			assert.Equal(t, tc.to, p.Header.Get("To"))
+			assert.Equal(t, tc.cc, p.Header.Get("Cc"))
+		})
+	}
+}
+
+func TestForwardBuilderInline(t *testing.T) {
+	e := readComposeMessage(t, "")
+	p, err := enmime.ForwardBuilder(e, enmime.ForwardInline).
+		From("", "alice@example.com").
+		To("", "erin@example.com").
+		Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, "Fwd: Lunch", p.Header.Get("Subject"))
+	assert.Empty(t, p.Header.Get("In-Reply-To"))
+	require.Equal(t, "multipart/mixed", p.ContentType)
+	alt := p.FirstChild
+	require.Equal(t, "multipart/alternative", alt.ContentType)
+	text := string(alt.FirstChild.Content)
+	assert.Contains(t, text, "---------- Forwarded message ---------\nFrom: Bob <bob@example.com>\n")
+	assert.Contains(t, text, "Subject: Lunch\n")
+	assert.Contains(t, text, "Cc: Dave <dave@example.com>, ALICE@example.com\n\nShall we meet?\n\n> Earlier")
+	assert.Contains(t, string(alt.FirstChild.NextSibling.Content),
+		"From: Bob &lt;bob@example.com&gt;<br>")
+	att := alt.NextSibling
+	require.NotNil(t, att)
+	assert.Equal(t, "menu.txt", att.FileName)
+	assert.Equal(t, "Soup", string(att.Content))
+}
+
+func TestComposeSubjectPrefix(t *testing.T) {
+	e, err := enmime.ReadEnvelope(strings.NewReader("Subject: RE: FW: Lunch\r\n\r\nHi\r\n"))
+	require.NoError(t, err)
+	b := enmime.ReplyBuilder(e)
+	assert.Equal(t, "RE: FW: Lunch", b.GetSubject())
+	b = enmime.ForwardBuilder(e, enmime.ForwardInline)
+	assert.Equal(t, "Fwd: RE: FW: Lunch", b.GetSubject())
+
+	e, err = enmime.ReadEnvelope(strings.NewReader("Subject: Fwd[2]: Lunch\r\n\r\nHi\r\n"))
+	require.NoError(t, err)
+	b = enmime.ForwardBuilder(e, enmime.ForwardInline)
+	assert.Equal(t, "Fwd[2]: Lunch", b.GetSubject())
+}
+
+func TestForwardBuilderAttachment(t *testing.T) {
+	e := readComposeMessage(t, "")
+	p, err := enmime.ForwardBuilder(e, enmime.ForwardAttachment).
+		From("", "alice@example.com").
+		To("", "erin@example.com").
+		Build()
+	require.NoError(t, err)
+
+	require.Equal(t, "multipart/mixed", p.ContentType)
+	att := p.FirstChild.NextSibling
+	require.NotNil(t, att)
+	assert.Equal(t, "message/rfc822", att.ContentType)
+	assert.Equal(t, "Lunch.eml", att.FileName)
+
+	// The attached message parses back to the original.
+	buf := &bytes.Buffer{}
+	require.NoError(t, p.Encode(buf))
+	fwd, err := enmime.ReadEnvelope(buf)
+	require.NoError(t, err)
+	require.Len(t, fwd.Attachments, 1)
+	orig, err := enmime.ReadEnvelope(bytes.NewReader(fwd.Attachments[0].Content))
+	require.NoError(t, err)
+	assert.Equal(t, "Lunch", orig.GetHeader("Subject"))
+	assert.Equal(t, e.Text, orig.Text)
+	require.Len(t, orig.Attachments, 1)
+
+	// The original envelope is not modified.
+	assert.Empty(t, e.Root.Header.Get("Content-Transfer-Encoding"))
+
+	_, err = enmime.ForwardBuilder(&enmime.Envelope{}, enmime.ForwardAttachment).
+		From("", "alice@example.com").
+		To("", "erin@example.com").
+		Build()
+	assert.Error(t, err)
+}
+
+func TestForwardBuilderAttachmentCharset(t *testing.T) {
+	raw := "From: bob@example.com\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: Greeting\r\n" +
+		"X-First: 1\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"h=E9llo\r\n"
+	e, err := enmime.ReadEnvelope(strings.NewReader(raw))
+	require.NoError(t, err)
+	wantHeader := make(textproto.MIMEHeader)
+	for k, v := range e.Root.Header {
+		wantHeader[k] = slices.Clone(v)
+	}
+	wantFields := e.OrderedHeader().Fields()
+
+	p, err := enmime.ForwardBuilder(e, enmime.ForwardAttachment).
+		From("", "alice@example.com").
+		To("", "erin@example.com").
+		Build()
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, p.Encode(buf))
+	fwd, err := enmime.ReadEnvelope(buf)
+	require.NoError(t, err)
+	require.Len(t, fwd.Attachments, 1)
+	orig, err := enmime.ReadEnvelope(bytes.NewReader(fwd.Attachments[0].Content))
+	require.NoError(t, err)
+	assert.Equal(t, "héllo", strings.TrimSpace(orig.Text))
+	names := make([]string, 0, 4)
+	for _, f := range orig.OrderedHeader().Fields()[:4] {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"From", "To", "Subject", "X-First"}, names)
+
+	// The source envelope is unchanged.
+	assert.Equal(t, wantHeader, e.Root.Header)
+	assert.Equal(t, wantFields, e.OrderedHeader().Fields())
+	assert.Equal(t, "iso-8859-1", e.Root.Charset)
+	assert.Equal(t, "héllo", strings.TrimSpace(e.Text))
+	assert.Empty(t, e.Root.Errors)
+}
+
+func TestEnvelopeToBuilder(t *testing.T) {
+	e := readComposeMessage(t, "Return-Path: <bob@example.com>\r\n"+
+		"Received: from mx.example.com by mail.example.com; Tue, 2 Jan 2024 03:04:05 +0000\r\n"+
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; b=abc\r\n"+
+		"ARC-Seal: i=1; a=rsa-sha256; d=example.com; s=s1; cv=none; b=abc\r\n"+
+		"Authentication-Results: mail.example.com; dkim=pass\r\n"+
+		"Importance: HIGH\r\n"+
+		"Bcc: erin@example.com\r\nX-Custom: kept\r\n")
+	b := e.ToBuilder()
+	require.NoError(t, b.Error())
+	assert.Equal(t, "bob@example.com", b.GetFrom().Address)
+	assert.Len(t, b.GetTo(), 2)
+	assert.Len(t, b.GetCC(), 2)
+	assert.Len(t, b.GetBCC(), 1)
+	assert.Equal(t, "Lunch", b.GetSubject())
+	assert.Equal(t, "kept", b.GetHeader("X-Custom"))
+	assert.Empty(t, b.GetHeader("Content-Type"))
+	assert.Equal(t, 2024, b.GetDate().Year())
+
+	// Modify and rebuild.
+	p, err := b.Subject("Dinner").To("", "frank@example.com").Build()
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, p.Encode(buf))
+	got, err := enmime.ReadEnvelope(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "Dinner", got.GetHeader("Subject"))
+	assert.Equal(t, "<2@example.com>", got.GetHeader("Message-ID"))
+	assert.Equal(t, "kept", got.GetHeader("X-Custom"))
+	assert.Equal(t, "high", got.GetHeader("Importance"))
+	assert.Empty(t, got.GetHeader("Bcc"))
+	for _, h := range []string{"Return-Path", "Received", "DKIM-Signature", "ARC-Seal",
+		"Authentication-Results"} {
+		assert.Empty(t, got.GetHeader(h), h)
+	}
+	to, err := got.AddressList("To")
+	require.NoError(t, err)
+	assert.Len(t, to, 3)
+	assert.Equal(t, strings.ReplaceAll(e.Text, "\r\n", "\n"), strings.ReplaceAll(got.Text, "\r\n", "\n"))
+	assert.Equal(t, strings.TrimSpace(e.HTML), strings.TrimSpace(got.HTML))
+	require.Len(t, got.Attachments, 1)
+	assert.Equal(t, "menu.txt", got.Attachments[0].FileName)
+	assert.Equal(t, "Soup", string(got.Attachments[0].Content))
+}
+
+func TestEnvelopeToBuilderInvalidImportance(t *testing.T) {
+	e := readComposeMessage(t, "Importance: whenever\r\n")
+	assert.Error(t, e.ToBuilder().Error())
+	e = readComposeMessage(t, "Priority: whenever\r\n")
+	_, err := e.ToBuilder().Build()
+	assert.Error(t, err)
+}
+
+func TestEnvelopeToBuilderInlines(t *testing.T) {
+	raw := "From: bob@example.com\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: Logo\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=rel\r\n" +
+		"\r\n" +
+		"--rel\r\n" +
+		"Content-Type: text/html; charset=iso-8859-1\r\n" +
+		"\r\n" +
+		"<p>Caf\xe9 <img src=\"cid:logo@example.com\"></p>\r\n" +
+		"--rel\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename=logo.png\r\n" +
+		"Content-ID: <logo@example.com>\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"iVBORw0KGgo=\r\n" +
+		"--rel--\r\n"
+	e, err := enmime.ReadEnvelope(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	p, err := e.ToBuilder().Build()
+	require.NoError(t, err)
+	// The text body was converted from HTML, so is not included.
+	require.Equal(t, "multipart/related", p.ContentType)
+	assert.Equal(t, "text/html", p.FirstChild.ContentType)
+	assert.Contains(t, string(p.FirstChild.Content), "Café")
+	img := p.FirstChild.NextSibling
+	require.NotNil(t, img)
+	assert.Equal(t, "image/png", img.ContentType)
+	assert.Equal(t, "logo@example.com", img.ContentID)
+	assert.Equal(t, "logo.png", img.FileName)
+	assert.Equal(t, "\x89PNG\r\n\x1a\n", string(img.Content))
+}
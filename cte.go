@@ -0,0 +1,195 @@
+package enmime
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxLineLen is the maximum length of a line in octets, excluding the CRLF, permitted by RFC 5322
+// and the SMTP 8BITMIME extension.
+const maxLineLen = 998
+
+// TransferEncodingPolicy chooses the Content-Transfer-Encoding of each part with content, given
+// a summary of that content.  It returns one of "7bit", "8bit", "binary", "quoted-printable" or
+// "base64"; any other value selects the encoding enmime would otherwise have chosen.  The policy
+// is responsible for only choosing 8bit or binary encodings the mail server accepts.
+//
+// A 7bit or 8bit choice the content does not satisfy is replaced by the default encoding, or by
+// binary for BinaryPolicy.  Multipart parts declare the widest encoding of their children.
+// Parts with a ContentReader are always base64 encoded, and message/* parts always 8bit.
+type TransferEncodingPolicy interface {
+	TransferEncoding(info TransferEncodingInfo) string
+}
+
+// TransferEncodingFunc adapts a function to a TransferEncodingPolicy.
+type TransferEncodingFunc func(info TransferEncodingInfo) string
+
+// TransferEncoding calls f(info).
+func (f TransferEncodingFunc) TransferEncoding(info TransferEncodingInfo) string {
+	return f(info)
+}
+
+// TransferEncodingInfo summarizes the content of a part for a TransferEncodingPolicy.
+type TransferEncodingInfo struct {
+	ContentType string // Media type, without parameters.
+	Size        int    // Length of the content in octets, after any charset conversion.
+	MaxLineLen  int    // Length of the longest line in octets, excluding line endings.
+	NonASCII    int    // Number of octets outside of the US-ASCII range.
+	Binary      bool   // Content contains NUL or bare CR octets, which 8bit text may not.
+	Default     string // Encoding enmime chooses without a policy.
+}
+
+// EightBitPolicy is a TransferEncodingPolicy for mail servers supporting the 8BITMIME extension
+// (RFC 6152).  Text content containing non-ASCII characters is sent as 8bit, rather than
+// quoted-printable or base64, when its lines are within the 998 octet limit.
+var EightBitPolicy TransferEncodingPolicy = TransferEncodingFunc(eightBitPolicy)
+
+// BinaryPolicy is a TransferEncodingPolicy for mail servers supporting the BINARYMIME and
+// CHUNKING extensions (RFC 3030).  Text content is sent as by EightBitPolicy, all other content
+// is sent unencoded as binary.
+var BinaryPolicy TransferEncodingPolicy = binaryMIMEPolicy{}
+
+// binaryMIMEPolicy is the type of BinaryPolicy, identifying encoders permitted to fall back to
+// binary.
+type binaryMIMEPolicy struct{}
+
+// TransferEncoding returns the encoding chosen by binaryPolicy.
+func (binaryMIMEPolicy) TransferEncoding(info TransferEncodingInfo) string {
+	return binaryPolicy(info)
+}
+
+func eightBitPolicy(info TransferEncodingInfo) string {
+	if info.NonASCII > 0 && !info.Binary && info.MaxLineLen <= maxLineLen &&
+		(info.ContentType == "" || strings.HasPrefix(info.ContentType, "text/")) {
+		return cte8Bit
+	}
+	return info.Default
+}
+
+func binaryPolicy(info TransferEncodingInfo) string {
+	if info.Default == cte7Bit {
+		return cte7Bit
+	}
+	if cte := eightBitPolicy(info); cte == cte8Bit {
+		return cte
+	}
+	return cteBinary
+}
+
+// checkPolicyEncoding returns te if the content summarized by info may be sent with it, otherwise
+// binary when binaryMIME is permitted, or def.  RFC 2045 2.7-2.8: 7bit and 8bit data are short
+// lines without NUL or bare CR octets, and 7bit data is also US-ASCII.
+func checkPolicyEncoding(te transferEncoding, info TransferEncodingInfo, binaryMIME bool,
+	def transferEncoding) transferEncoding {
+	if te != te7Bit && te != te8Bit {
+		return te
+	}
+	if !info.Binary && info.MaxLineLen <= maxLineLen && (te == te8Bit || info.NonASCII == 0) {
+		return te
+	}
+	if binaryMIME {
+		return teBinary
+	}
+	return def
+}
+
+// transferEncodingInfo summarizes content for a TransferEncodingPolicy.
+func transferEncodingInfo(contentType string, content []byte, def transferEncoding) TransferEncodingInfo {
+	mtype := strings.ToLower(contentType)
+	if i := strings.IndexByte(mtype, ';'); i >= 0 {
+		mtype = strings.TrimSpace(mtype[:i])
+	}
+	info := TransferEncodingInfo{
+		ContentType: mtype,
+		Size:        len(content),
+		Default:     def.String(),
+	}
+	info.MaxLineLen = longestLine(content)
+	for i, c := range content {
+		switch {
+		case c >= 0x80:
+			info.NonASCII++
+		case c == 0 || c == '\r' && (i+1 == len(content) || content[i+1] != '\n'):
+			info.Binary = true
+		}
+	}
+	return info
+}
+
+// longestLine returns the length of the longest line of content in octets, excluding line
+// endings.
+func longestLine(content []byte) int {
+	n := 0
+	for len(content) > 0 {
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+		n = max(n, len(bytes.TrimSuffix(line, []byte{'\r'})))
+	}
+	return n
+}
+
+// canonicalLineEndings returns content with bare CR and LF line endings converted to CRLF, and
+// whether any were converted.
+func canonicalLineEndings(content []byte) ([]byte, bool) {
+	bare := false
+	for i, c := range content {
+		if c == '\n' && (i == 0 || content[i-1] != '\r') ||
+			c == '\r' && (i+1 == len(content) || content[i+1] != '\n') {
+			bare = true
+			break
+		}
+	}
+	if !bare {
+		return content, false
+	}
+	buf := make([]byte, 0, len(content)+len(content)/32)
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\r' && i+1 < len(content) && content[i+1] == '\n':
+			buf = append(buf, c, '\n')
+			i++
+		case c == '\r' || c == '\n':
+			buf = append(buf, '\r', '\n')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf, true
+}
+
+// String returns the Content-Transfer-Encoding header value of te.
+func (te transferEncoding) String() string {
+	switch te {
+	case te8Bit:
+		return cte8Bit
+	case teQuoted:
+		return cteQuotedPrintable
+	case teBase64:
+		return cteBase64
+	case teBinary:
+		return cteBinary
+	}
+	return cte7Bit
+}
+
+// parseTransferEncoding returns the transferEncoding named by a Content-Transfer-Encoding header
+// value.
+func parseTransferEncoding(cte string) (transferEncoding, bool) {
+	switch strings.ToLower(cte) {
+	case cte7Bit:
+		return te7Bit, true
+	case cte8Bit:
+		return te8Bit, true
+	case cteBinary:
+		return teBinary, true
+	case cteQuotedPrintable:
+		return teQuoted, true
+	case cteBase64:
+		return teBase64, true
+	}
+	return te7Bit, false
+}
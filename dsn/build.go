@@ -0,0 +1,105 @@
+package dsn
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/internal/textproto"
+)
+
+// fieldOrder lists the per-message and per-recipient fields in the order of
+// https://datatracker.ietf.org/doc/html/rfc3464#section-2.2, which Part writes them in, spelled
+// as in the RFC.  Other fields are written after these, sorted by name.
+var fieldOrder = []string{
+	"Original-Envelope-ID",
+	"Reporting-MTA",
+	"DSN-Gateway",
+	"Received-From-MTA",
+	"Arrival-Date",
+	"Original-Recipient",
+	"Final-Recipient",
+	"Action",
+	"Status",
+	"Remote-MTA",
+	"Diagnostic-Code",
+	"Last-Attempt-Date",
+	"Final-Log-ID",
+	"Will-Retry-Until",
+}
+
+// Part builds a multipart/report Part holding the report, per rfc6522, for use as the root of a
+// delivery status notification.  Message header fields such as From, To, Subject and MIME-Version
+// should be added to the Header of the returned Part before encoding it.  The original message is
+// omitted when OriginalMessage is empty.
+func (r *Report) Part() *enmime.Part {
+	root := enmime.NewPart("multipart/report")
+	root.ContentTypeParams["report-type"] = "delivery-status"
+
+	text := enmime.NewPart("text/plain")
+	text.Content = []byte(r.Explanation.Text)
+	if r.Explanation.HTML == "" {
+		root.AddChild(text)
+	} else {
+		alt := enmime.NewPart("multipart/alternative")
+		alt.AddChild(text)
+		html := enmime.NewPart("text/html")
+		html.Content = []byte(r.Explanation.HTML)
+		alt.AddChild(html)
+		root.AddChild(alt)
+	}
+
+	status := enmime.NewPart("message/delivery-status")
+	buf := &bytes.Buffer{}
+	groups := append(slices.Clone(r.DeliveryStatus.MessageDSNs), r.DeliveryStatus.RecipientDSNs...)
+	for i, h := range groups {
+		if i > 0 {
+			// Groups of fields are separated by a blank line.
+			buf.WriteString("\r\n")
+		}
+		writeFields(buf, h)
+	}
+	status.Content = buf.Bytes()
+	root.AddChild(status)
+
+	if len(r.OriginalMessage) > 0 {
+		orig := enmime.NewPart("message/rfc822")
+		orig.Content = r.OriginalMessage
+		root.AddChild(orig)
+	}
+	return root
+}
+
+// writeFields writes a group of delivery status fields.
+func writeFields(buf *bytes.Buffer, h textproto.MIMEHeader) {
+	rank := func(k string) int {
+		k = textproto.CanonicalEmailMIMEHeaderKey(k)
+		for i, f := range fieldOrder {
+			if textproto.CanonicalEmailMIMEHeaderKey(f) == k {
+				return i
+			}
+		}
+		return len(fieldOrder)
+	}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		name := k
+		if r := rank(k); r < len(fieldOrder) {
+			name = fieldOrder[r]
+		}
+		for _, v := range h[k] {
+			buf.WriteString(name + ": " + v + "\r\n")
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package dsn_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/dsn"
+	"github.com/jhillyerd/enmime/v2/internal/textproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportPart(t *testing.T) {
+	orig := "Subject: Hello\r\n\r\nHi\r\n"
+	want := &dsn.Report{
+		Explanation: dsn.Explanation{Text: "Delivery failed.\r\n", HTML: "<p>Delivery failed.</p>\r\n"},
+		DeliveryStatus: dsn.DeliveryStatus{
+			MessageDSNs: []textproto.MIMEHeader{
+				{"Reporting-Mta": []string{"dns; mx.example.com"}},
+			},
+			RecipientDSNs: []textproto.MIMEHeader{
+				{
+					"Final-Recipient": []string{"rfc822; bob@example.com"},
+					"Action":          []string{"failed"},
+					"Status":          []string{"5.1.1"},
+					"X-Custom":        []string{"kept"},
+				},
+				{
+					"Final-Recipient": []string{"rfc822; carol@example.com"},
+					"Action":          []string{"delayed"},
+					"Status":          []string{"4.2.2"},
+				},
+			},
+		},
+		OriginalMessage: []byte(orig),
+	}
+
+	p := want.Part()
+	p.Header.Set("Subject", "Delivery Status Notification")
+	buf := &bytes.Buffer{}
+	require.NoError(t, p.Encode(buf))
+	raw := buf.String()
+	assert.Contains(t, raw, "report-type=delivery-status")
+	assert.Contains(t, raw, "Reporting-MTA: dns; mx.example.com\r\n\r\n"+
+		"Final-Recipient: rfc822; bob@example.com\r\nAction: failed\r\nStatus: 5.1.1\r\n"+
+		"X-Custom: kept\r\n\r\nFinal-Recipient")
+
+	e, err := enmime.ReadEnvelope(strings.NewReader(raw))
+	require.NoError(t, err)
+	got, err := dsn.ParseReport(e.Root)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "Delivery failed.", strings.TrimSpace(got.Explanation.Text))
+	assert.Equal(t, "<p>Delivery failed.</p>", strings.TrimSpace(got.Explanation.HTML))
+	assert.Equal(t, want.DeliveryStatus, got.DeliveryStatus)
+	assert.Equal(t, orig, string(got.OriginalMessage))
+}
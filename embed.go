@@ -0,0 +1,132 @@
+package enmime
+
+import (
+	"bytes"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// embedImages rewrites references to local images within htmlBody to cid: URLs, returning the
+// rewritten HTML along with an inline Part for each image.  Images are read from fsys, or the local
+// file system when fsys is nil.  newCID generates the Content-ID of each image.  htmlBody is
+// returned unchanged if it references no local images.
+func embedImages(htmlBody []byte, fsys fs.FS, newCID func() string) ([]byte, []*Part, error) {
+	doc, err := html.Parse(bytes.NewReader(htmlBody))
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to parse HTML")
+	}
+
+	var parts []*Part
+	cids := make(map[string]string) // Content-ID by image path.
+	var walk func(n *html.Node) error
+	walk = func(n *html.Node) error {
+		if n.Type == html.ElementNode {
+			for i, a := range n.Attr {
+				if a.Namespace != "" || !isImageAttr(n.Data, a.Key) {
+					continue
+				}
+				name, ok := localImagePath(a.Val, fsys == nil)
+				if !ok {
+					continue
+				}
+				cid, ok := cids[name]
+				if !ok {
+					p, err := readImage(fsys, name)
+					if err != nil {
+						return err
+					}
+					cid = newCID()
+					p.ContentID = cid
+					cids[name] = cid
+					parts = append(parts, p)
+				}
+				n.Attr[i].Val = "cid:" + cid
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc); err != nil {
+		return nil, nil, err
+	}
+	if len(parts) == 0 {
+		return htmlBody, nil, nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := html.Render(buf, doc); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), parts, nil
+}
+
+// isImageAttr returns true if the attribute key of element tag references an image.
+func isImageAttr(tag, key string) bool {
+	key = strings.ToLower(key)
+	return key == "src" && (tag == "img" || tag == "input") || key == "background"
+}
+
+// localImagePath returns the path of the local file referenced by the URL ref, or false if it
+// refers to something else.  file: URLs and absolute paths are accepted only when reading from
+// the local file system.
+func localImagePath(ref string, local bool) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "//") {
+		return "", false
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host != "" {
+		return "", false
+	}
+	switch {
+	case u.Scheme == "file" && local:
+		return filepath.FromSlash(u.Path), true
+	case u.Scheme != "":
+		return "", false
+	case local:
+		return filepath.FromSlash(u.Path), true
+	}
+	name := path.Clean(strings.TrimPrefix(u.Path, "/"))
+	return name, fs.ValidPath(name)
+}
+
+// readImage reads the named image into an inline Part, detecting its content type from the file
+// extension or content.  Files which are not images are rejected.
+func readImage(fsys fs.FS, name string) (*Part, error) {
+	var b []byte
+	var err error
+	if fsys == nil {
+		b, err = os.ReadFile(name)
+	} else {
+		b, err = fs.ReadFile(fsys, name)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to embed image %q", name)
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = http.DetectContentType(b)
+	}
+	if mtype, _, err := mime.ParseMediaType(ctype); err != nil || !strings.HasPrefix(mtype, "image/") {
+		return nil, errors.Errorf("failed to embed image %q: content type %q is not an image", name,
+			ctype)
+	}
+	p := NewPart(ctype)
+	p.Content = b
+	p.FileName = path.Base(filepath.ToSlash(name))
+	p.Disposition = cdInline
+	return p, nil
+}
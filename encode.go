@@ -2,6 +2,7 @@ package enmime
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/base64"
 	"io"
 	"maps"
@@ -11,9 +12,11 @@ import (
 	"sort"
 	"strings"
 	"time"
+	_utf8 "unicode/utf8"
 
 	"github.com/jhillyerd/enmime/v2/internal/coding"
 	"github.com/jhillyerd/enmime/v2/internal/stringutil"
+	"github.com/pkg/errors"
 )
 
 // b64Percent determines the percent of non-ASCII characters enmime will tolerate before switching
@@ -27,9 +30,13 @@ const (
 	te8Bit
 	teQuoted
 	teBase64
+	teBinary
 	teRaw
 )
 
+// maxEncodedWordLen is the maximum length of an RFC 2047 encoded-word.
+const maxEncodedWordLen = 75
+
 const (
 	base64EncodedLineLen = 76
 	base64DecodedLineLen = base64EncodedLineLen * 3 / 4 // this is ok since lineLen is divisible by 4
@@ -41,6 +48,27 @@ var crnl = []byte{'\r', '\n'}
 
 // Encode writes this Part and all its children to the specified writer in MIME format.
 func (p *Part) Encode(writer io.Writer) error {
+	parts := make(map[*Part]*partEncoding)
+	if _, err := p.setupEncoding(parts); err != nil {
+		return err
+	}
+	b := bufio.NewWriter(writer)
+	if err := p.encode(b, parts); err != nil {
+		return err
+	}
+	return b.Flush()
+}
+
+// partEncoding holds the content of a Part prepared for encoding, and its transfer encoding.
+type partEncoding struct {
+	content []byte
+	cte     transferEncoding
+}
+
+// setupEncoding prepares the content and MIME headers of p and its descendants for encoding,
+// recording them in parts.  It returns the widest transfer encoding of p and its descendants:
+// 7bit, 8bit or binary.
+func (p *Part) setupEncoding(parts map[*Part]*partEncoding) (transferEncoding, error) {
 	if p.Header == nil {
 		p.Header = make(textproto.MIMEHeader)
 	}
@@ -49,66 +77,122 @@ func (p *Part) Encode(writer io.Writer) error {
 		p.Content = make([]byte, readChunkSize)
 		n, err := p.ContentReader.Read(p.Content)
 		if err != nil && err != io.EOF {
-			return err
+			return te7Bit, err
 		}
 		p.Content = p.Content[:n]
 	}
 	cte := teRaw
-	if p.parser == nil || !p.parser.rawContent {
-		cte = p.setupMIMEHeaders()
+	content := p.Content
+	raw := p.parser != nil && p.parser.rawContent
+	if !raw {
+		flowed := false
+		if enc := p.activeEncoder(); enc != nil && enc.encodeFlowedTextOption {
+			// Reflow a copy, so that p.Content is unchanged and encoding is repeatable.
+			if fc, ok := p.flowedContent(); ok {
+				content, flowed = fc, true
+			}
+		}
+		var charset string
+		var err error
+		if content, charset, err = p.convertCharset(content); err != nil {
+			return te7Bit, err
+		}
+		content = p.canonicalizeText(content, charset)
+		cte = p.setupMIMEHeaders(content, charset, flowed)
 	}
-	// Encode this part.
-	b := bufio.NewWriter(writer)
+	parts[p] = &partEncoding{content, cte}
+
+	widest := te7Bit
+	if raw {
+		widest, _ = parseTransferEncoding(p.Header.Get(hnContentEncoding))
+	} else if len(content) > 0 {
+		widest = cte
+	}
+	widest = domainOf(widest)
+	children := te7Bit
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
+		te, err := c.setupEncoding(parts)
+		if err != nil {
+			return te7Bit, err
+		}
+		children = max(children, te)
+	}
+	if p.FirstChild != nil && !raw && children > domainOf(cte) {
+		// RFC 2045 6.4: a multipart entity must declare the widest encoding of its parts.
+		p.Header.Set(hnContentEncoding, children.String())
+	}
+	return max(widest, children), nil
+}
+
+// domainOf returns the encoding domain of data encoded with te: 7bit, 8bit or binary.
+func domainOf(te transferEncoding) transferEncoding {
+	switch te {
+	case te8Bit, teBinary:
+		return te
+	}
+	return te7Bit
+}
+
+// encode writes p and its children, as prepared by setupEncoding, to b.
+func (p *Part) encode(b *bufio.Writer, parts map[*Part]*partEncoding) error {
+	pe := parts[p]
 	if err := p.encodeHeader(b); err != nil {
 		return err
 	}
-	if len(p.Content) > 0 {
+	if len(pe.content) > 0 {
 		if _, err := b.Write(crnl); err != nil {
 			return err
 		}
-		if err := p.encodeContent(b, cte); err != nil {
+		if err := p.encodeContent(b, pe.cte, pe.content); err != nil {
 			return err
 		}
 	}
 	if p.FirstChild == nil {
-		return b.Flush()
+		return nil
 	}
 	// Encode children.
 	endMarker := []byte("\r\n--" + p.Boundary + "--")
 	marker := endMarker[:len(endMarker)-2]
-	c := p.FirstChild
-	for c != nil {
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
 		if _, err := b.Write(marker); err != nil {
 			return err
 		}
 		if _, err := b.Write(crnl); err != nil {
 			return err
 		}
-		if err := c.Encode(b); err != nil {
+		if err := c.encode(b, parts); err != nil {
 			return err
 		}
-		c = c.NextSibling
 	}
 	if _, err := b.Write(endMarker); err != nil {
 		return err
 	}
-	if _, err := b.Write(crnl); err != nil {
-		return err
-	}
-	return b.Flush()
+	_, err := b.Write(crnl)
+	return err
 }
 
 // setupMIMEHeaders determines content transfer encoding, generates a boundary string if required,
 // then sets the Content-Type (type, charset, filename, boundary) and Content-Disposition headers.
-func (p *Part) setupMIMEHeaders() transferEncoding {
+// content is the content to be written, labeled with charset when it has been converted, and
+// with format=flowed when flowed is true.
+func (p *Part) setupMIMEHeaders(content []byte, charset string, flowed bool) transferEncoding {
 	// Determine content transfer encoding.
 
 	// If we are encoding a part that previously had content-transfer-encoding set, unset it so
 	// the correct encoding detection can be done below.
 	p.Header.Del(hnContentEncoding)
 
+	smtpUTF8 := false
+	if enc := p.activeEncoder(); enc != nil {
+		smtpUTF8 = enc.smtpUTF8
+	}
+	if smtpUTF8 && strings.EqualFold(p.ContentType, ctMessageRFC822) && hasUTF8Header(content) {
+		// RFC 6532: messages with UTF-8 headers are labeled message/global.
+		p.ContentType = ctMessageGlobal
+	}
+
 	cte := te7Bit
-	if len(p.Content) > 0 {
+	if len(content) > 0 {
 		if strings.Index(strings.ToLower(p.ContentType), "message/") == 0 {
 			// RFC 1341: `message` types must have no encoding other than "7bit", "8bit", or
 			// "binary". The message header fields are always US-ASCII in any case, and data within
@@ -118,21 +202,39 @@ func (p *Part) setupMIMEHeaders() transferEncoding {
 		} else {
 			cte = teBase64
 			if p.TextContent() && p.ContentReader == nil {
-				cte = p.selectTransferEncoding(p.Content, false)
+				cte = p.selectTransferEncoding(content, false)
+				if cte != te7Bit && is7BitCharset(charset) && !has8BitBytes(content) &&
+					!p.activeEncoder().forceQuotedPrintableCteOption {
+					// Escape sequences of ISO-2022 charsets are 7bit safe.
+					cte = te7Bit
+				}
 				if p.Charset == "" {
 					p.Charset = utf8
 				}
 			}
 		}
 
+		if enc := p.activeEncoder(); enc != nil && enc.policy != nil && cte != te8Bit &&
+			p.ContentReader == nil && !enc.forceQuotedPrintableCteOption {
+			info := transferEncodingInfo(p.ContentType, content, cte)
+			if te, ok := parseTransferEncoding(enc.policy.TransferEncoding(info)); ok {
+				_, binaryMIME := enc.policy.(binaryMIMEPolicy)
+				cte = checkPolicyEncoding(te, info, binaryMIME, cte)
+			}
+		}
+
+		if (cte == te7Bit || cte == te8Bit) && p.isTextLeaf() {
+			// RFC 5322: lines must not exceed 998 octets; quoted-printable adds soft line breaks.
+			if n := longestLine(content); n > maxLineLen {
+				p.addEncodeWarningf(ErrorLineLength, "line of %d octets exceeds limit of %d, encoded as %s",
+					n, maxLineLen, cteQuotedPrintable)
+				cte = teQuoted
+			}
+		}
+
 		// RFC 2045: 7bit is assumed if CTE header not present.
-		switch cte {
-		case te8Bit:
-			p.Header.Set(hnContentEncoding, cte8Bit)
-		case teBase64:
-			p.Header.Set(hnContentEncoding, cteBase64)
-		case teQuoted:
-			p.Header.Set(hnContentEncoding, cteQuotedPrintable)
+		if cte != te7Bit {
+			p.Header.Set(hnContentEncoding, cte.String())
 		}
 	}
 
@@ -145,24 +247,35 @@ func (p *Part) setupMIMEHeaders() transferEncoding {
 		p.Header.Set(hnContentID, coding.ToIDHeader(p.ContentID))
 	}
 	fileName := p.FileName
-	switch p.selectTransferEncoding([]byte(p.FileName), true) {
-	case teBase64:
-		fileName = mime.BEncoding.Encode(utf8, p.FileName)
-	case teQuoted:
-		fileName = mime.QEncoding.Encode(utf8, p.FileName)
+	if !smtpUTF8 {
+		// With SMTPUTF8, non-ASCII file names are left to RFC 2231 parameter encoding.
+		switch p.selectTransferEncoding([]byte(p.FileName), true) {
+		case teBase64:
+			fileName = mime.BEncoding.Encode(utf8, p.FileName)
+		case teQuoted:
+			fileName = mime.QEncoding.Encode(utf8, p.FileName)
+		}
 	}
 
 	if p.ContentType != "" {
 		// Build content type header.
 		param := make(map[string]string)
 		maps.Copy(param, p.ContentTypeParams)
-		setParamValue(param, hpCharset, p.Charset)
+		if charset != "" {
+			setParamValue(param, hpCharset, charset)
+		} else {
+			setParamValue(param, hpCharset, p.Charset)
+		}
 		setParamValue(param, hpName, fileName)
 		setParamValue(param, hpBoundary, p.Boundary)
 		if mt := mime.FormatMediaType(p.ContentType, param); mt != "" {
 			p.ContentType = mt
 		}
-		p.Header.Set(hnContentType, p.ContentType)
+		ctype := p.ContentType
+		if flowed {
+			ctype = flowedContentType(ctype)
+		}
+		p.Header.Set(hnContentType, ctype)
 	}
 
 	if p.Disposition != "" {
@@ -181,30 +294,25 @@ func (p *Part) setupMIMEHeaders() transferEncoding {
 	return cte
 }
 
-// encodeHeader writes out a sorted list of headers.
+// encodeHeader writes out a sorted list of headers, or the headers in their original order when
+// the Part was parsed.
 func (p *Part) encodeHeader(b *bufio.Writer) error {
+	if p.OrderedHeader != nil {
+		return p.encodeOrderedHeader(b)
+	}
 	keys := make([]string, 0, len(p.Header))
 	for k := range p.Header {
 		keys = append(keys, k)
 	}
-	rawContent := p.parser != nil && p.parser.rawContent
 
 	sort.Strings(keys)
 	for _, k := range keys {
 		for _, v := range p.Header[k] {
-			encv := v
-			if !rawContent {
-				switch p.selectTransferEncoding([]byte(v), true) {
-				case teBase64:
-					encv = mime.BEncoding.Encode(utf8, v)
-				case teQuoted:
-					encv = mime.QEncoding.Encode(utf8, v)
-				}
+			f, err := p.encodeHeaderField(k, v)
+			if err != nil {
+				return err
 			}
-			// _ used to prevent early wrapping
-			wb := stringutil.Wrap(76, k, ":_", encv, "\r\n")
-			wb[len(k)+1] = ' '
-			if _, err := b.Write(wb); err != nil {
+			if _, err := b.WriteString(f); err != nil {
 				return err
 			}
 		}
@@ -213,7 +321,7 @@ func (p *Part) encodeHeader(b *bufio.Writer) error {
 }
 
 // encodeContent writes out the content in the selected encoding.
-func (p *Part) encodeContent(b *bufio.Writer, cte transferEncoding) (err error) {
+func (p *Part) encodeContent(b *bufio.Writer, cte transferEncoding, content []byte) (err error) {
 	if p.ContentReader != nil {
 		return p.encodeContentFromReader(b)
 	}
@@ -225,8 +333,8 @@ func (p *Part) encodeContent(b *bufio.Writer, cte transferEncoding) (err error)
 	switch cte {
 	case teBase64:
 		enc := base64.StdEncoding
-		text := make([]byte, enc.EncodedLen(len(p.Content)))
-		enc.Encode(text, p.Content)
+		text := make([]byte, enc.EncodedLen(len(content)))
+		enc.Encode(text, content)
 		// Wrap lines.
 		lineLen := 76
 		for len(text) > 0 {
@@ -243,12 +351,12 @@ func (p *Part) encodeContent(b *bufio.Writer, cte transferEncoding) (err error)
 		}
 	case teQuoted:
 		qp := quotedprintable.NewWriter(b)
-		if _, err = qp.Write(p.Content); err != nil {
+		if _, err = qp.Write(content); err != nil {
 			return err
 		}
 		err = qp.Close()
 	default:
-		_, err = b.Write(p.Content)
+		_, err = b.Write(content)
 	}
 	return err
 }
@@ -304,7 +412,7 @@ func (p *Part) selectTransferEncoding(content []byte, quoteLineBreaks bool) tran
 		return te7Bit
 	}
 
-	if p.encoder != nil && p.encoder.forceQuotedPrintableCteOption {
+	if enc := p.activeEncoder(); enc != nil && enc.forceQuotedPrintableCteOption {
 		return teQuoted
 	}
 
@@ -328,9 +436,122 @@ func (p *Part) selectTransferEncoding(content []byte, quoteLineBreaks bool) tran
 	return teQuoted
 }
 
+// canonicalizeText returns the content of a text part with bare CR and LF line endings converted
+// to the CRLF required by RFC 2045, adding a warning if any were converted.  Content in UTF-16 and
+// UTF-32 charsets is returned unchanged.
+func (p *Part) canonicalizeText(content []byte, charset string) []byte {
+	if charset == "" {
+		charset = p.Charset
+	}
+	if !p.isTextLeaf() || strings.HasPrefix(strings.ToLower(charset), "utf-16") ||
+		strings.HasPrefix(strings.ToLower(charset), "utf-32") {
+		return content
+	}
+	content, converted := canonicalLineEndings(content)
+	if converted {
+		p.addEncodeWarningf(ErrorLineEnding, "bare CR or LF line endings converted to CRLF")
+	}
+	return content
+}
+
+// isTextLeaf returns true if this is a text part with content held in memory.
+func (p *Part) isTextLeaf() bool {
+	return p.TextContent() && p.ContentReader == nil &&
+		!strings.HasPrefix(strings.ToLower(p.ContentType), ctMultipartPrefix)
+}
+
 // setParamValue will ignore empty values
 func setParamValue(p map[string]string, k, v string) {
 	if v != "" {
 		p[k] = v
 	}
 }
+
+// activeEncoder returns the Encoder of this Part, or else that of its nearest ancestor.
+func (p *Part) activeEncoder() *Encoder {
+	for a := p; a != nil; a = a.Parent {
+		if a.encoder != nil {
+			return a.encoder
+		}
+	}
+	return nil
+}
+
+// convertCharset returns content, the content of a text part, converted from UTF-8 into the
+// TargetCharset of the active Encoder, along with the name of the charset.  The content is
+// returned unchanged, with an empty charset name, when no conversion is configured.
+func (p *Part) convertCharset(content []byte) ([]byte, string, error) {
+	enc := p.activeEncoder()
+	if enc == nil || enc.charset == "" || len(content) == 0 || p.ContentReader != nil ||
+		!p.TextContent() || strings.HasPrefix(p.ContentType, ctMultipartPrefix) {
+		return content, "", nil
+	}
+	b, err := coding.ConvertFromUTF8(enc.charset, content)
+	if err != nil {
+		if !enc.charsetFallback {
+			return nil, "", errors.WithMessagef(err, "failed to encode %s part content", p.ContentType)
+		}
+		p.addEncodeWarningf(ErrorCharsetConversion, "content encoded as %s: %v", utf8, err)
+		return content, utf8, nil
+	}
+	return b, strings.ToLower(enc.charset), nil
+}
+
+// needsEncodedWord returns true if the header value contains characters that must be encoded.
+func needsEncodedWord(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if b := v[i]; (b < ' ' || b > '~') && b != '\t' {
+			return true
+		}
+	}
+	return false
+}
+
+// isRawUTF8Header returns true if the header value may be written as raw UTF-8 under RFC 6532:
+// it must be valid UTF-8, and free of control characters.
+func isRawUTF8Header(v string) bool {
+	if !_utf8.ValidString(v) {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if b := v[i]; (b < ' ' && b != '\t') || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// hasUTF8Header returns true if the header section of the message contains 8bit bytes.
+func hasUTF8Header(msg []byte) bool {
+	for len(msg) > 0 {
+		line := msg
+		if i := bytes.IndexByte(msg, '\n'); i >= 0 {
+			line, msg = msg[:i], msg[i+1:]
+		} else {
+			msg = nil
+		}
+		if len(bytes.TrimRight(line, "\r")) == 0 {
+			break
+		}
+		if has8BitBytes(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// is7BitCharset returns true for ISO-2022 charsets, which use escape sequences to represent
+// characters with 7bit bytes.
+func is7BitCharset(charset string) bool {
+	return strings.HasPrefix(strings.ToLower(charset), "iso-2022-")
+}
+
+// has8BitBytes returns true if b contains bytes outside of the 7bit range.
+func has8BitBytes(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"crypto/rand"
 	"io"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jhillyerd/enmime/v2"
 	"github.com/jhillyerd/enmime/v2/internal/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEncodePartEmpty(t *testing.T) {
@@ -477,3 +480,414 @@ func TestRawContentUTF8Headers(t *testing.T) {
 
 	test.DiffGolden(t, b.Bytes(), "testdata", "encode", "utf8-to.raw.golden")
 }
+
+func TestEncodeTargetCharset(t *testing.T) {
+	tcases := []struct {
+		charset string
+		cte     string
+	}{
+		{"iso-2022-jp", ""},
+		{"shift_jis", "base64"},
+		{"gb2312", "base64"},
+		{"koi8-r", "base64"},
+	}
+	texts := map[string]string{
+		"iso-2022-jp": "こんにちは、世界。日本語のメールです。",
+		"shift_jis":   "こんにちは、世界。日本語のメールです。",
+		"gb2312":      "你好，世界。这是一封中文邮件。",
+		"koi8-r":      "Привет, мир. Это письмо на русском.",
+	}
+	for _, tc := range tcases {
+		t.Run(tc.charset, func(t *testing.T) {
+			text := texts[tc.charset]
+			root := enmime.NewPart("multipart/mixed")
+			child := enmime.NewPart("text/plain")
+			child.Content = []byte(text)
+			root.AddChild(child)
+			root.Header.Set("Subject", text+text)
+			root.WithEncoder(enmime.NewEncoder(enmime.TargetCharset(tc.charset)))
+
+			b := &bytes.Buffer{}
+			require.NoError(t, root.Encode(b))
+			assert.Equal(t, tc.cte, child.Header.Get("Content-Transfer-Encoding"))
+			assert.Contains(t, child.Header.Get("Content-Type"), "charset="+tc.charset)
+			assert.Equal(t, text, string(child.Content), "Part.Content must not be modified")
+			assert.Contains(t, b.String(), "=?"+tc.charset+"?b?")
+			for _, line := range strings.Split(b.String(), "\r\n") {
+				assert.LessOrEqual(t, len(line), 78)
+			}
+
+			env, err := enmime.ReadEnvelope(bytes.NewReader(b.Bytes()))
+			require.NoError(t, err)
+			assert.Equal(t, text, env.Text)
+			assert.Equal(t, text+text, env.GetHeader("Subject"))
+		})
+	}
+}
+
+func TestEncodeTargetCharsetOverride(t *testing.T) {
+	root := enmime.NewPart("multipart/mixed")
+	jp := enmime.NewPart("text/plain")
+	jp.Content = []byte("日本語")
+	utf := enmime.NewPart("text/plain").WithEncoder(enmime.NewEncoder())
+	utf.Content = []byte("emoji 🙂")
+	root.AddChild(jp)
+	root.AddChild(utf)
+	root.WithEncoder(enmime.NewEncoder(enmime.TargetCharset("iso-2022-jp")))
+
+	require.NoError(t, root.Encode(&bytes.Buffer{}))
+	assert.Contains(t, jp.Header.Get("Content-Type"), "charset=iso-2022-jp")
+	assert.Contains(t, utf.Header.Get("Content-Type"), "charset=utf-8")
+}
+
+func TestEncodeTargetCharsetUnrepresentable(t *testing.T) {
+	p := enmime.NewPart("text/plain")
+	p.Content = []byte("日本語 🙂")
+	p.WithEncoder(enmime.NewEncoder(enmime.TargetCharset("iso-2022-jp")))
+	err := p.Encode(&bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `'🙂' at offset 10`)
+
+	p = enmime.NewPart("text/plain")
+	p.Content = []byte("日本語")
+	p.Header.Set("Subject", "🙂")
+	p.WithEncoder(enmime.NewEncoder(enmime.TargetCharset("iso-2022-jp")))
+	err = p.Encode(&bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Subject")
+
+	// Fallback to UTF-8 with warnings.
+	p = enmime.NewPart("text/plain")
+	p.Content = []byte("日本語 🙂")
+	p.Header.Set("Subject", "🙂")
+	p.WithEncoder(enmime.NewEncoder(enmime.TargetCharset("iso-2022-jp"), enmime.CharsetFallback(true)))
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Encode(b))
+	assert.Contains(t, p.Header.Get("Content-Type"), "charset=utf-8")
+	assert.Contains(t, b.String(), "=?utf-8?b?")
+	require.Len(t, p.Errors, 2)
+	assert.Equal(t, enmime.ErrorCharsetConversion, p.Errors[0].Name)
+}
+
+func TestEncodeSMTPUTF8(t *testing.T) {
+	inner := "From: 用户@例子.广告\r\nSubject: Grüße\r\n\r\nbody\r\n"
+	root := enmime.NewPart("multipart/mixed")
+	root.Header.Set("Subject", "Grüße")
+	nested := enmime.NewPart("message/rfc822")
+	nested.Content = []byte(inner)
+	nested.FileName = "Grüße.eml"
+	ascii := enmime.NewPart("message/rfc822")
+	ascii.Content = []byte("Subject: hi\r\n\r\nGrüße\r\n")
+	root.AddChild(nested)
+	root.AddChild(ascii)
+	root.WithEncoder(enmime.NewEncoder(enmime.SMTPUTF8(true)))
+
+	b := &bytes.Buffer{}
+	require.NoError(t, root.Encode(b))
+	assert.Contains(t, b.String(), "Subject: Grüße\r\n")
+	assert.Contains(t, b.String(), inner)
+	assert.True(t, strings.HasPrefix(nested.Header.Get("Content-Type"), "message/global;"))
+	assert.Equal(t, "message/rfc822", ascii.Header.Get("Content-Type"))
+	assert.Contains(t, nested.Header.Get("Content-Type"), "name*=utf-8''Gr%C3%BC%C3%9Fe.eml")
+}
+
+func TestEncodeHeaderStructure(t *testing.T) {
+	tcases := []struct {
+		name, header, value string
+		want                []string // substrings of the encoded header
+		wantNot             []string
+	}{
+		{
+			name:    "unstructured encodes only non-ASCII words",
+			header:  "Subject",
+			value:   "Invoice for Jürgen, due today",
+			want:    []string{"Subject: Invoice for =?utf-8?", "?= due today"},
+			wantNot: []string{"Invoice?", "today?="},
+		},
+		{
+			name:    "address display names only",
+			header:  "To",
+			value:   "Jürgen Müller <juergen@example.com>, \"Doe, Jane\" <jane@example.com>",
+			want:    []string{"?= <juergen@example.com>,", `"Doe, Jane"`},
+			wantNot: []string{"juergen@example.com?="},
+		},
+		{
+			name:    "IDN address domain",
+			header:  "From",
+			value:   "Jürgen <juergen@bücher.de>",
+			want:    []string{"<juergen@xn--bcher-kva.de>"},
+			wantNot: []string{"bücher"},
+		},
+		{
+			name:   "long unstructured text",
+			header: "Subject",
+			value:  strings.Repeat("Größenwahn ", 20) + "end",
+			want:   []string{"?= end"},
+		},
+		{
+			name:   "parameters folded outside of quotes",
+			header: "Content-Disposition",
+			value: `attachment; first="one two three four five"; ` +
+				`second="six seven eight nine ten"; third="eleven twelve"`,
+			want: []string{`first="one two three four five";`, `second="six seven eight nine ten";`},
+		},
+		{
+			name:   "structured identifiers",
+			header: "References",
+			value:  strings.Repeat("<0123456789abcdef@example.com> ", 4) + "<last@example.com>",
+			want:   []string{"<0123456789abcdef@example.com>\r\n <0123456789abcdef@example.com>"},
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := enmime.NewPart("text/plain")
+			p.Content = []byte("body")
+			p.Header.Set(tc.header, tc.value)
+			b := &bytes.Buffer{}
+			require.NoError(t, p.Encode(b))
+
+			head, _, _ := strings.Cut(b.String(), "\r\n\r\n")
+			for _, line := range strings.Split(head, "\r\n") {
+				assert.LessOrEqual(t, len(line), 78, "line too long: %q", line)
+				for _, w := range strings.Fields(line) {
+					if strings.HasPrefix(w, "=?") {
+						assert.LessOrEqual(t, len(strings.TrimRight(w, ",")), 75, "encoded-word: %q", w)
+					}
+				}
+			}
+			for _, s := range tc.want {
+				assert.Contains(t, head, s)
+			}
+			for _, s := range tc.wantNot {
+				assert.NotContains(t, head, s)
+			}
+
+			env, err := enmime.ReadEnvelope(bytes.NewReader(b.Bytes()))
+			require.NoError(t, err)
+			if enmime.AddressHeaders[strings.ToLower(tc.header)] {
+				want, err := enmime.ParseAddressList(tc.value)
+				require.NoError(t, err)
+				got, err := env.AddressList(tc.header)
+				require.NoError(t, err)
+				require.Len(t, got, len(want))
+				for i := range want {
+					assert.Equal(t, want[i].Name, got[i].Name)
+				}
+			} else {
+				assert.Equal(t, tc.value, env.GetHeader(tc.header))
+			}
+		})
+	}
+}
+
+func TestEncodeHeaderUTF8LocalPart(t *testing.T) {
+	// UTF-8 local parts cannot be represented without SMTPUTF8.
+	p := enmime.NewPart("text/plain")
+	p.Content = []byte("body")
+	p.Header.Set("To", "Jürgen <用户@example.com>")
+	err := p.Encode(&bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SMTPUTF8")
+
+	b := &bytes.Buffer{}
+	require.NoError(t, p.WithEncoder(enmime.NewEncoder(enmime.SMTPUTF8(true))).Encode(b))
+	assert.Contains(t, b.String(), "To: Jürgen <用户@example.com>\r\n")
+}
+
+func TestEncodeHeaderSplitsCharacters(t *testing.T) {
+	// Each encoded-word must hold whole characters, so that it can be decoded on its own.
+	p := enmime.NewPart("text/plain")
+	p.Content = []byte("body")
+	p.Header.Set("Subject", strings.Repeat("日本語", 30))
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Encode(b))
+	head, _, _ := strings.Cut(b.String(), "\r\n\r\n")
+	words := 0
+	for _, w := range strings.Fields(head) {
+		if strings.HasPrefix(w, "=?") {
+			words++
+			dec := enmime.DecodeRFC2047(w)
+			assert.NotEqual(t, w, dec)
+			assert.True(t, utf8.ValidString(dec), "word split inside a character: %q", w)
+		}
+	}
+	assert.Greater(t, words, 1)
+}
+
+func TestEncodeTransferEncodingPolicy(t *testing.T) {
+	text := []byte("Greetings from Köln, the city on the Rhine.\r\n")
+	bin := []byte{0x00, 0x01, 0xff, 'P', 'K', 0x03, 0x04, '\r'}
+	tcases := []struct {
+		name        string
+		policy      enmime.TransferEncodingPolicy
+		contentType string
+		content     []byte
+		want        string
+	}{
+		{"default text", nil, "text/plain", text, "quoted-printable"},
+		{"8bit text", enmime.EightBitPolicy, "text/plain", text, "8bit"},
+		{"8bit ascii", enmime.EightBitPolicy, "text/plain", []byte("hello\r\n"), ""},
+		{"8bit long line", enmime.EightBitPolicy, "text/plain",
+			[]byte(strings.Repeat("ü", 500)), "base64"},
+		{"8bit attachment", enmime.EightBitPolicy, "application/zip", bin, "base64"},
+		{"binary text", enmime.BinaryPolicy, "text/html", text, "8bit"},
+		{"binary attachment", enmime.BinaryPolicy, "application/zip", bin, "binary"},
+		{"callback", enmime.TransferEncodingFunc(func(info enmime.TransferEncodingInfo) string {
+			if info.ContentType == "application/zip" && info.Size < 100 {
+				return "quoted-printable"
+			}
+			return info.Default
+		}), "application/zip", bin, "quoted-printable"},
+		{"unknown", enmime.TransferEncodingFunc(func(enmime.TransferEncodingInfo) string {
+			return "x-unknown"
+		}), "text/plain", text, "quoted-printable"},
+		{"invalid 8bit binary", always("8bit"), "application/zip", bin, "base64"},
+		{"invalid 8bit long line", always("8bit"), "text/plain",
+			[]byte(strings.Repeat("ü", 500)), "base64"},
+		{"invalid 7bit", always("7bit"), "text/plain", text, "quoted-printable"},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := enmime.NewPart(tc.contentType)
+			p.Content = tc.content
+			if tc.policy != nil {
+				p.WithEncoder(enmime.NewEncoder(enmime.TransferEncoding(tc.policy)))
+			}
+			b := &bytes.Buffer{}
+			require.NoError(t, p.Encode(b))
+			assert.Equal(t, tc.want, p.Header.Get("Content-Transfer-Encoding"))
+			if tc.want == "8bit" || tc.want == "binary" {
+				assert.True(t, bytes.HasSuffix(b.Bytes(), tc.content), "content must not be encoded")
+			}
+
+			env, err := enmime.ReadEnvelope(bytes.NewReader(b.Bytes()))
+			require.NoError(t, err)
+			switch tc.contentType {
+			case "text/plain":
+				assert.Equal(t, string(tc.content), env.Text)
+			case "text/html":
+				assert.Equal(t, string(tc.content), env.HTML)
+			}
+		})
+	}
+}
+
+// always returns a TransferEncodingPolicy choosing cte for every part.
+func always(cte string) enmime.TransferEncodingPolicy {
+	return enmime.TransferEncodingFunc(func(enmime.TransferEncodingInfo) string { return cte })
+}
+
+func TestEncodeTransferEncodingPolicyMultipart(t *testing.T) {
+	text := "Greetings from Köln.\r\n"
+	bin := "\x00\x01\xffPK\x03\x04"
+	tcases := []struct {
+		name     string
+		policy   enmime.TransferEncodingPolicy
+		content  string
+		want     string
+		wantLeaf string
+	}{
+		{"default", nil, bin, "", "base64"},
+		{"8bit text", enmime.EightBitPolicy, text, "8bit", "8bit"},
+		{"binary", enmime.BinaryPolicy, bin, "binary", "binary"},
+		{"invalid 8bit leaf", enmime.TransferEncodingFunc(func(info enmime.TransferEncodingInfo) string {
+			if info.ContentType == "application/octet-stream" {
+				return "8bit"
+			}
+			return info.Default
+		}), bin, "", "base64"},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := enmime.NewPart("multipart/mixed")
+			alt := enmime.NewPart("multipart/alternative")
+			ascii := enmime.NewPart("text/plain")
+			ascii.Content = []byte("hello\r\n")
+			leaf := enmime.NewPart("application/octet-stream")
+			if strings.HasPrefix(tc.content, "G") {
+				leaf = enmime.NewPart("text/plain")
+			}
+			leaf.Content = []byte(tc.content)
+			root.AddChild(alt)
+			alt.AddChild(ascii)
+			alt.AddChild(leaf)
+			if tc.policy != nil {
+				root.WithEncoder(enmime.NewEncoder(enmime.TransferEncoding(tc.policy)))
+			}
+			require.NoError(t, root.Encode(&bytes.Buffer{}))
+
+			// RFC 2045 6.4: multipart entities declare the widest encoding of their parts.
+			assert.Equal(t, tc.want, root.Header.Get("Content-Transfer-Encoding"))
+			assert.Equal(t, tc.want, alt.Header.Get("Content-Transfer-Encoding"))
+			assert.Equal(t, "", ascii.Header.Get("Content-Transfer-Encoding"))
+			assert.Equal(t, tc.wantLeaf, leaf.Header.Get("Content-Transfer-Encoding"))
+		})
+	}
+}
+
+func TestEncodeTransferEncodingPolicyInfo(t *testing.T) {
+	var got enmime.TransferEncodingInfo
+	p := enmime.NewPart("text/plain")
+	p.Content = []byte("ab\r\nこんにちは\nc\x00\r\n")
+	p.WithEncoder(enmime.NewEncoder(enmime.TransferEncoding(enmime.TransferEncodingFunc(
+		func(info enmime.TransferEncodingInfo) string {
+			got = info
+			return info.Default
+		}))))
+	require.NoError(t, p.Encode(&bytes.Buffer{}))
+	assert.Equal(t, enmime.TransferEncodingInfo{
+		ContentType: "text/plain",
+		Size:        len(p.Content) + 1, // Bare LF converted to CRLF.
+		MaxLineLen:  15,
+		NonASCII:    15,
+		Binary:      true,
+		Default:     "base64",
+	}, got)
+}
+
+func TestEncodeLineLimits(t *testing.T) {
+	long := strings.Repeat("a", 2000)
+	tcases := []struct {
+		name     string
+		content  string
+		cte      string
+		warnings []string
+	}{
+		{"canonical", "one\r\ntwo\r\n", "", nil},
+		{"bare line endings", "one\ntwo\rthree\r\n", "", []string{enmime.ErrorLineEnding}},
+		{"long line", long + "\r\n", "quoted-printable", []string{enmime.ErrorLineLength}},
+		{"long line and bare LF", "short\n" + long, "quoted-printable",
+			[]string{enmime.ErrorLineEnding, enmime.ErrorLineLength}},
+		{"long line within limit", strings.Repeat("a", 998), "", nil},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := enmime.NewPart("text/plain")
+			p.Content = []byte(tc.content)
+			b := &bytes.Buffer{}
+			require.NoError(t, p.Encode(b))
+			// Encoding again does not repeat the warnings.
+			require.NoError(t, p.Encode(&bytes.Buffer{}))
+			assert.Equal(t, tc.content, string(p.Content), "Part.Content must not be modified")
+			assert.Equal(t, tc.cte, p.Header.Get("Content-Transfer-Encoding"))
+			var names []string
+			for _, e := range p.Errors {
+				assert.False(t, e.Severe)
+				names = append(names, e.Name)
+			}
+			assert.Equal(t, tc.warnings, names)
+
+			for _, line := range strings.SplitAfter(b.String(), "\n") {
+				assert.LessOrEqual(t, len(line), 1000)
+				if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+					assert.Equal(t, "\r\n", line[i:], "bare line ending in %q", line)
+				}
+			}
+
+			env, err := enmime.ReadEnvelope(bytes.NewReader(b.Bytes()))
+			require.NoError(t, err)
+			want := strings.NewReplacer("\r\n", "\r\n", "\n", "\r\n", "\r", "\r\n").Replace(tc.content)
+			assert.Equal(t, want, env.Text)
+		})
+	}
+}
@@ -7,6 +7,11 @@ type EncoderOption interface {
 // Encoder implements MIME part encoding options
 type Encoder struct {
 	forceQuotedPrintableCteOption bool
+	encodeFlowedTextOption        bool
+	charset                       string
+	charsetFallback               bool
+	smtpUTF8                      bool
+	policy                        TransferEncodingPolicy
 }
 
 // ForceQuotedPrintableCte forces "quoted-printable" transfer encoding when selecting Content Transfer Encoding, preventing the use of base64.
@@ -20,6 +25,79 @@ func (o forceQuotedPrintableCteOption) apply(p *Encoder) {
 	p.forceQuotedPrintableCteOption = bool(o)
 }
 
+// EncodeFlowedText converts text/plain parts to RFC 3676 format=flowed, wrapping long lines with
+// soft line breaks at 78 columns, so that clients may reflow paragraphs to fit their display.
+// Parts that already specify a format parameter are left unchanged.
+func EncodeFlowedText(b bool) EncoderOption {
+	return encodeFlowedTextOption(b)
+}
+
+type encodeFlowedTextOption bool
+
+func (o encodeFlowedTextOption) apply(p *Encoder) {
+	p.encodeFlowedTextOption = bool(o)
+}
+
+// TargetCharset converts the content of text parts, and header encoded-words, from UTF-8 to the
+// named charset, for example "iso-2022-jp", "shift_jis", "gb2312" or "koi8-r".  Any charset known
+// to the parser may be used; the name is used as given in the charset parameter and
+// encoded-words.  Encoding fails if a character cannot be represented in the charset, unless
+// CharsetFallback is enabled.
+//
+// The Encoder of a Part applies to its descendants unless they have an Encoder of their own,
+// allowing the charset to be overridden per part.
+func TargetCharset(charset string) EncoderOption {
+	return targetCharsetOption(charset)
+}
+
+type targetCharsetOption string
+
+func (o targetCharsetOption) apply(p *Encoder) {
+	p.charset = string(o)
+}
+
+// CharsetFallback sets the charsetFallback option.  When true, content or header values that
+// cannot be represented in the TargetCharset are encoded as UTF-8 instead, and a
+// ErrorCharsetConversion warning is added to the Errors of the Part.
+func CharsetFallback(fallback bool) EncoderOption {
+	return charsetFallbackOption(fallback)
+}
+
+type charsetFallbackOption bool
+
+func (o charsetFallbackOption) apply(p *Encoder) {
+	p.charsetFallback = bool(o)
+}
+
+// SMTPUTF8 enables RFC 6532 internationalized headers, for delivery to mail servers supporting
+// the SMTPUTF8 extension (RFC 6531).  Header values are written as raw UTF-8 rather than RFC 2047
+// encoded-words, keeping UTF-8 addresses intact, and message/rfc822 parts containing UTF-8
+// headers are labeled message/global.
+func SMTPUTF8(b bool) EncoderOption {
+	return smtpUTF8Option(b)
+}
+
+type smtpUTF8Option bool
+
+func (o smtpUTF8Option) apply(p *Encoder) {
+	p.smtpUTF8 = bool(o)
+}
+
+// TransferEncoding sets the TransferEncodingPolicy used to choose the Content-Transfer-Encoding
+// of each part, for example EightBitPolicy when the mail server supports 8BITMIME.  The policy is
+// not consulted when ForceQuotedPrintableCte is enabled.
+func TransferEncoding(policy TransferEncodingPolicy) EncoderOption {
+	return transferEncodingOption{policy}
+}
+
+type transferEncodingOption struct {
+	policy TransferEncodingPolicy
+}
+
+func (o transferEncodingOption) apply(p *Encoder) {
+	p.policy = o.policy
+}
+
 func NewEncoder(ops ...EncoderOption) *Encoder {
 	e := Encoder{
 		forceQuotedPrintableCteOption: false,
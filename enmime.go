@@ -39,6 +39,12 @@
 // AddressList(key) will convert the specified address header into a slice of net/mail.Address
 // values.
 //
+// # JSON
+//
+// Envelope and Part implement json.Marshaler and json.Unmarshaler, producing an acyclic document
+// in which child parts are nested rather than linked to their parents.  See Envelope.MarshalJSON
+// for a description of the fields.
+//
 // # Errors
 //
 // enmime attempts to be tolerant of poorly encoded MIME messages. In situations where parsing is
@@ -28,6 +28,15 @@ type Envelope struct {
 	header     *textproto.MIMEHeader // Header from original message
 }
 
+// OrderedHeader returns the header fields of the message in their original order, including
+// duplicates, original name case and raw bytes.  It returns nil if the message was not parsed.
+func (e *Envelope) OrderedHeader() *OrderedHeader {
+	if e.Root == nil {
+		return nil
+	}
+	return e.Root.OrderedHeader
+}
+
 // GetHeaderKeys returns a list of header keys seen in this message. Get
 // individual headers with `GetHeader(name)`
 func (e *Envelope) GetHeaderKeys() (headers []string) {
@@ -188,7 +197,7 @@ func (p Parser) EnvelopeFromPart(root *Part) (*Envelope, error) {
 
 	if detectMultipartMessage(root, p.multipartWOBoundaryAsSinglePart) {
 		// Multi-part message (message with attachments, etc)
-		if err := parseMultiPartBody(root, e); err != nil {
+		if err := parseMultiPartBody(root, e, p.decodeFlowedText); err != nil {
 			return nil, err
 		}
 	} else {
@@ -201,7 +210,7 @@ func (p Parser) EnvelopeFromPart(root *Part) (*Envelope, error) {
 			}
 		} else {
 			// Only text, no attachments
-			parseTextOnlyBody(root, e)
+			parseTextOnlyBody(root, e, p.decodeFlowedText)
 		}
 	}
 
@@ -238,8 +247,9 @@ func (e *Envelope) GatherNestedErrors() error {
 }
 
 // parseTextOnlyBody parses a plain text message in root that has MIME-like headers, but
-// only contains a single part - no boundaries, etc.  The result is placed in e.
-func parseTextOnlyBody(root *Part, e *Envelope) {
+// only contains a single part - no boundaries, etc.  The result is placed in e.  Flowed text is
+// reflowed when decodeFlowed is true.
+func parseTextOnlyBody(root *Part, e *Envelope, decodeFlowed bool) {
 	// Determine character set
 	var charset string
 	var isHTML bool
@@ -271,12 +281,13 @@ func parseTextOnlyBody(root *Part, e *Envelope) {
 			}
 		}
 	} else {
-		e.Text = string(root.Content)
+		e.Text = textBody(root, decodeFlowed)
 	}
 }
 
-// parseMultiPartBody parses a multipart message in root.  The result is placed in e.
-func parseMultiPartBody(root *Part, e *Envelope) error {
+// parseMultiPartBody parses a multipart message in root.  The result is placed in e.  Flowed text
+// is reflowed when decodeFlowed is true.
+func parseMultiPartBody(root *Part, e *Envelope, decodeFlowed bool) error {
 	// Parse top-level multipart
 	ctype := root.Header.Get(hnContentType)
 	mediatype, params, _, err := root.parseMediaType(ctype)
@@ -297,7 +308,7 @@ func parseMultiPartBody(root *Part, e *Envelope) error {
 			return p.ContentType == ctTextPlain && p.Disposition != cdAttachment
 		})
 		if p != nil {
-			e.Text = string(p.Content)
+			e.Text = textBody(p, decodeFlowed)
 		}
 	} else {
 		// multipart is of a mixed type
@@ -308,7 +319,7 @@ func parseMultiPartBody(root *Part, e *Envelope) error {
 			if i > 0 {
 				e.Text += "\n--\n"
 			}
-			e.Text += string(p.Content)
+			e.Text += textBody(p, decodeFlowed)
 		}
 	}
 
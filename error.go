@@ -27,13 +27,17 @@ const (
 	ErrorMalformedChildPart = "Malformed child part"
 	// ErrorDataHasBoundary name.
 	ErrorDataHasBoundary = "Data contains boundary marker"
+	// ErrorLineEnding name.
+	ErrorLineEnding = "Line Ending"
+	// ErrorLineLength name.
+	ErrorLineLength = "Line Length"
 )
 
 // Error describes an error encountered while parsing.
 type Error struct {
-	Name   string // The name or type of error encountered, from Error consts.
-	Detail string // Additional detail about the cause of the error, if available.
-	Severe bool   // Indicates that a portion of the message was lost during parsing.
+	Name   string `json:"name"`   // The name or type of error encountered, from Error consts.
+	Detail string `json:"detail"` // Additional detail about the cause of the error, if available.
+	Severe bool   `json:"severe"` // Indicates that a portion of the message was lost during parsing.
 }
 
 // Error formats the enmime.Error as a string.
@@ -73,6 +77,18 @@ func (p *Part) addWarningf(name string, detailFmt string, args ...any) {
 	})
 }
 
+// addEncodeWarningf builds a non-severe Error and appends it to the Part error slice, unless an
+// identical warning was already recorded, such as by a previous Encode of the Part.
+func (p *Part) addEncodeWarningf(name string, detailFmt string, args ...any) {
+	detail := fmt.Sprintf(detailFmt, args...)
+	for _, e := range p.Errors {
+		if e.Name == name && e.Detail == detail && !e.Severe {
+			return
+		}
+	}
+	p.addProblem(&Error{name, detail, false})
+}
+
 // addProblem adds general *Error to the Part error slice.
 func (p *Part) addProblem(err *Error) {
 	maxErrors := 0
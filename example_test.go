@@ -52,43 +52,45 @@ func ExampleBuilder() {
 	// RCPT TO:<user1@inbucket.org>
 	// DATA
 	// Content-Type: multipart/alternative;
-	//  boundary=enmime-52fdfc07-2182-454f-963f-5f0f9a621d72
+	//  boundary=enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868
 	// Date: Mon, 01 Jan 2024 13:14:15 +0000
 	// From: "Do Not Reply" <noreply@inbucket.org>
+	// Message-Id: <52fdfc07-2182-454f-963f-5f0f9a621d72@inbucket.org>
 	// Mime-Version: 1.0
 	// Subject: Inbucket Newsletter
 	// To: "Esteemed Customer" <user1@inbucket.org>
 	//
-	// --enmime-52fdfc07-2182-454f-963f-5f0f9a621d72
+	// --enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868
 	// Content-Type: text/plain; charset=utf-8
 	//
 	// Text body
-	// --enmime-52fdfc07-2182-454f-963f-5f0f9a621d72
+	// --enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868
 	// Content-Type: text/html; charset=utf-8
 	//
 	// <p>HTML body</p>
-	// --enmime-52fdfc07-2182-454f-963f-5f0f9a621d72--
+	// --enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868--
 	//
 	// MAIL FROM:<noreply@inbucket.org>
 	// RCPT TO:<user2@inbucket.org>
 	// DATA
 	// Content-Type: multipart/alternative;
-	//  boundary=enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868
+	//  boundary=enmime-99eb9d18-a447-4404-9d87-f3c67cf22746
 	// Date: Mon, 01 Jan 2024 13:14:15 +0000
 	// From: "Do Not Reply" <noreply@inbucket.org>
+	// Message-Id: <1e001679-39cb-4694-92c4-22acd208a007@inbucket.org>
 	// Mime-Version: 1.0
 	// Subject: Inbucket Newsletter
 	// To: "Another Customer" <user2@inbucket.org>
 	//
-	// --enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868
+	// --enmime-99eb9d18-a447-4404-9d87-f3c67cf22746
 	// Content-Type: text/plain; charset=utf-8
 	//
 	// Text body
-	// --enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868
+	// --enmime-99eb9d18-a447-4404-9d87-f3c67cf22746
 	// Content-Type: text/html; charset=utf-8
 	//
 	// <p>HTML body</p>
-	// --enmime-037c4d7b-bb04-47d1-a2c6-4981855ad868--
+	// --enmime-99eb9d18-a447-4404-9d87-f3c67cf22746--
 }
 
 func ExampleReadEnvelope() {
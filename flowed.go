@@ -0,0 +1,213 @@
+package enmime
+
+import (
+	"mime"
+	"strings"
+	_utf8 "unicode/utf8"
+)
+
+// RFC 3676 format=flowed parameters.
+const (
+	hpFormat      = "format"
+	hpDelSp       = "delsp"
+	formatFlowed  = "flowed"
+	flowedLineLen = 78
+)
+
+// isFlowed returns true if the part has a text/plain; format=flowed content type, and whether
+// delsp=yes was specified.
+func (p *Part) isFlowed() (flowed, delsp bool) {
+	if p.ContentType != ctTextPlain {
+		return false, false
+	}
+	_, params, _, err := p.parseMediaType(p.Header.Get(hnContentType))
+	if err != nil || !strings.EqualFold(params[hpFormat], formatFlowed) {
+		return false, false
+	}
+	return true, strings.EqualFold(params[hpDelSp], "yes")
+}
+
+// setupFlowedText converts the content of a text/plain part to format=flowed, unless a format
+// has already been specified.
+func (p *Part) setupFlowedText() {
+	content, ok := p.flowedContent()
+	if !ok {
+		return
+	}
+	if p.ContentTypeParams == nil {
+		p.ContentTypeParams = make(map[string]string)
+	}
+	p.Content = content
+	p.ContentTypeParams[hpFormat] = formatFlowed
+}
+
+// flowedContent returns the content of a text/plain part converted to format=flowed, or false if
+// the part is not eligible, or a format has already been specified.  The part is not modified.
+func (p *Part) flowedContent() ([]byte, bool) {
+	mtype := strings.ToLower(p.ContentType)
+	if i := strings.IndexByte(mtype, ';'); i >= 0 {
+		// ContentType includes parameters after a previous Encode.
+		mtype = strings.TrimSpace(mtype[:i])
+	}
+	if mtype != ctTextPlain || p.ContentReader != nil || p.Disposition == cdAttachment ||
+		p.ContentTypeParams[hpFormat] != "" {
+		return nil, false
+	}
+	return []byte(flow(string(p.Content), flowedLineLen)), true
+}
+
+// flowedContentType returns contentType with the format=flowed parameter added.
+func flowedContentType(contentType string) string {
+	mtype, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	params[hpFormat] = formatFlowed
+	if mt := mime.FormatMediaType(mtype, params); mt != "" {
+		return mt
+	}
+	return contentType
+}
+
+// textBody returns the content of a text/plain part, reflowing it when decodeFlowed is true and
+// the part is format=flowed.
+func textBody(p *Part, decodeFlowed bool) string {
+	if decodeFlowed {
+		if flowed, delsp := p.isFlowed(); flowed {
+			return unflow(string(p.Content), delsp)
+		}
+	}
+	return string(p.Content)
+}
+
+// unflow joins the soft line breaks of RFC 3676 format=flowed text, removing space-stuffing.
+// Quoted lines are joined only with lines of the same quote depth.
+func unflow(text string, delsp bool) string {
+	eol := "\n"
+	if strings.Contains(text, "\r\n") {
+		eol = "\r\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	sb := &strings.Builder{}
+	para := &strings.Builder{}
+	depth := -1
+	flush := func(hard bool) {
+		if depth < 0 {
+			return
+		}
+		if depth > 0 {
+			sb.WriteString(strings.Repeat(">", depth))
+			if para.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(para.String())
+		if hard {
+			sb.WriteString(eol)
+		}
+		para.Reset()
+		depth = -1
+	}
+	for i, line := range lines {
+		last := i == len(lines)-1
+		d := 0
+		for d < len(line) && line[d] == '>' {
+			d++
+		}
+		line = line[d:]
+		// Remove space-stuffing.
+		line = strings.TrimPrefix(line, " ")
+		if depth >= 0 && d != depth {
+			// Quote depth changed after a soft break; treat it as a hard break.
+			flush(true)
+		}
+		depth = d
+		soft := strings.HasSuffix(line, " ") && line != "-- "
+		if soft && delsp {
+			line = line[:len(line)-1]
+		}
+		para.WriteString(line)
+		if !soft {
+			flush(!last)
+		}
+	}
+	flush(false)
+	return sb.String()
+}
+
+// flow wraps text into RFC 3676 format=flowed lines of at most width characters, where
+// possible, using delsp=no soft line breaks.  Lines are space-stuffed as required, and trailing
+// spaces are removed from hard line breaks.  The "-- " signature delimiter is preserved.
+func flow(text string, width int) string {
+	eol := "\n"
+	if strings.Contains(text, "\r\n") {
+		eol = "\r\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	sb := &strings.Builder{}
+	for i, line := range lines {
+		if i > 0 {
+			sb.WriteString(eol)
+		}
+		if line == "-- " {
+			sb.WriteString(line)
+			continue
+		}
+		// Preserve the quote prefix on every wrapped line.
+		d := 0
+		for d < len(line) && line[d] == '>' {
+			d++
+		}
+		prefix := line[:d]
+		line = strings.TrimRight(line[d:], " ")
+		if d > 0 {
+			line = strings.TrimPrefix(line, " ")
+			prefix += " "
+		}
+		avail := width - _utf8.RuneCountInString(prefix)
+		for first := true; first || line != ""; first = false {
+			chunk := line
+			if _utf8.RuneCountInString(line) > avail {
+				chunk = line[:breakPoint(line, avail)]
+			}
+			line = line[len(chunk):]
+			if !first {
+				sb.WriteString(eol)
+			}
+			if d == 0 && (strings.HasPrefix(chunk, " ") || strings.HasPrefix(chunk, ">") ||
+				strings.HasPrefix(chunk, "From ")) {
+				// Space-stuff lines that would otherwise be misinterpreted.
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(prefix)
+			sb.WriteString(chunk)
+		}
+	}
+	return sb.String()
+}
+
+// breakPoint returns the byte offset following the last space within the first width runes of
+// line, so that the space ends the line as a soft break.  Words longer than width are not broken;
+// the offset following the next space is returned instead, or len(line) if there is none.
+func breakPoint(line string, width int) int {
+	last := -1
+	n := 0
+	for i, r := range line {
+		if n >= width {
+			break
+		}
+		if r == ' ' && strings.TrimLeft(line[:i], " ") != "" {
+			last = i
+		}
+		n++
+	}
+	if last >= 0 {
+		return last + 1
+	}
+	if i := strings.IndexByte(line[1:], ' '); i >= 0 {
+		return i + 2
+	}
+	return len(line)
+}
@@ -0,0 +1,161 @@
+package enmime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnflow(t *testing.T) {
+	tcases := []struct {
+		name  string
+		input string
+		delsp bool
+		want  string
+	}{
+		{
+			name:  "soft breaks",
+			input: "This is a \r\nflowed paragraph.\r\nHard line.\r\n",
+			want:  "This is a flowed paragraph.\r\nHard line.\r\n",
+		},
+		{
+			name:  "delsp",
+			input: "Longé \nword \nsplit\n",
+			delsp: true,
+			want:  "Longéwordsplit\n",
+		},
+		{
+			name:  "space stuffed",
+			input: " From here \n >quoted? no\n  indented\n",
+			want:  "From here >quoted? no\n indented\n",
+		},
+		{
+			name:  "quoted",
+			input: "> Quoted \n> text\n>> Deeper \n>> quote\n> \nNew \nreply\n",
+			want:  "> Quoted text\n>> Deeper quote\n>\nNew reply\n",
+		},
+		{
+			name:  "quote depth change",
+			input: "> Soft \nnot quoted\n",
+			want:  "> Soft \nnot quoted\n",
+		},
+		{
+			name:  "signature",
+			input: "Body\n-- \nSig\n",
+			want:  "Body\n-- \nSig\n",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, unflow(tc.input, tc.delsp))
+		})
+	}
+}
+
+func TestFlow(t *testing.T) {
+	long := strings.Repeat("word ", 30) + "end"
+	got := flow(long+"\n", flowedLineLen)
+	lines := strings.Split(got, "\n")
+	require.Len(t, lines, 3)
+	assert.LessOrEqual(t, len(lines[0]), flowedLineLen)
+	assert.True(t, strings.HasSuffix(lines[0], " "), "soft break expected: %q", lines[0])
+	assert.Equal(t, "", lines[2])
+	assert.Equal(t, long+"\n", unflow(got, false))
+
+	tcases := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "trailing spaces",
+			input: "hard   \r\nline",
+			want:  "hard\r\nline",
+		},
+		{
+			name:  "stuffing",
+			input: "From me\n indented",
+			want:  " From me\n  indented",
+		},
+		{
+			name:  "signature",
+			input: "Body\n-- \nSig",
+			want:  "Body\n-- \nSig",
+		},
+		{
+			name:  "long word",
+			input: strings.Repeat("x", 100) + " y",
+			want:  strings.Repeat("x", 100) + " \ny",
+		},
+		{
+			name:  "quoted",
+			input: "> " + strings.Repeat("quote ", 20),
+			want: "> " + strings.Repeat("quote ", 12) + "\n> " +
+				strings.TrimSpace(strings.Repeat("quote ", 8)),
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := flow(tc.input, flowedLineLen)
+			assert.Equal(t, tc.want, got)
+			for _, l := range strings.Split(strings.ReplaceAll(got, "\r\n", "\n"), "\n") {
+				if !strings.HasPrefix(l, "xxx") {
+					assert.LessOrEqual(t, len(l), flowedLineLen)
+				}
+			}
+		})
+	}
+}
+
+func TestFlowedRoundTrip(t *testing.T) {
+	text := "Dear customer,\r\n\r\n" + strings.Repeat("This paragraph is long enough to wrap. ", 8) +
+		"\r\n\r\n> " + strings.Repeat("Quoted text that also wraps. ", 5) + "\r\n-- \r\nSupport\r\n"
+	b := MailBuilder{}.
+		From("a", "a@example.com").
+		To("b", "b@example.com").
+		Date(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)).
+		Text([]byte(text)).
+		FlowedText(true)
+	root, err := b.Build()
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, root.Encode(buf))
+	assert.Contains(t, buf.String(), "format=flowed")
+
+	env, err := ReadEnvelope(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.NotEqual(t, strings.TrimRight(text, " "), env.Text)
+
+	p := NewParser(DecodeFlowedText(true))
+	env, err = p.ReadEnvelope(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	want := strings.ReplaceAll(text, "wrap. \r\n", "wrap.\r\n")
+	want = strings.ReplaceAll(want, "wraps. \r\n", "wraps.\r\n")
+	assert.Equal(t, want, env.Text)
+}
+
+func TestEncodeFlowedTextOption(t *testing.T) {
+	p := NewPart(ctTextPlain)
+	p.Content = []byte(strings.Repeat("abc ", 40))
+	p.WithEncoder(NewEncoder(EncodeFlowedText(true)))
+
+	for range 2 {
+		// Encoding neither modifies the part, nor reflows the content again.
+		buf := &bytes.Buffer{}
+		require.NoError(t, p.Encode(buf))
+		assert.Equal(t, "Content-Type: text/plain; charset=utf-8; format=flowed\r\n"+
+			"\r\n"+
+			strings.Repeat("abc ", 19)+"\r\n"+strings.Repeat("abc ", 19)+"\r\nabc abc",
+			buf.String())
+		assert.Equal(t, strings.Repeat("abc ", 40), string(p.Content))
+		assert.Empty(t, p.ContentTypeParams[hpFormat])
+	}
+
+	a := NewPart("application/octet-stream")
+	a.Content = []byte(strings.Repeat("abc ", 40))
+	a.WithEncoder(NewEncoder(EncodeFlowedText(true)))
+	require.NoError(t, a.Encode(&bytes.Buffer{}))
+	assert.NotContains(t, a.Header.Get(hnContentType), "flowed")
+}
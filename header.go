@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"net/mail"
 	"net/textproto"
+	"strings"
 
 	"github.com/jhillyerd/enmime/v2/internal/coding"
 	"github.com/jhillyerd/enmime/v2/internal/stringutil"
@@ -20,6 +21,8 @@ const (
 
 	// Standard MIME content types
 	ctAppOctetStream   = "application/octet-stream"
+	ctMessageGlobal    = "message/global"
+	ctMessageRFC822    = "message/rfc822"
 	ctMultipartAltern  = "multipart/alternative"
 	ctMultipartMixed   = "multipart/mixed"
 	ctMultipartPrefix  = "multipart/"
@@ -101,18 +104,35 @@ func ParseAddressList(list string) ([]*mail.Address, error) {
 // textproto.MIMEHeader. Header parse warnings & errors will be added to
 // ErrorCollector, io errors will be returned directly.
 func ReadHeader(r *bufio.Reader, p ErrorCollector) (textproto.MIMEHeader, error) {
+	header, _, err := readHeaderFields(r, p)
+	return header, err
+}
+
+// readHeaderFields reads a block of SMTP or MIME headers like ReadHeader, additionally returning
+// the header fields in their original order.
+func readHeaderFields(r *bufio.Reader, p ErrorCollector) (textproto.MIMEHeader, []HeaderField, error) {
 	// buf holds the massaged output for textproto.Reader.ReadMIMEHeader()
 	buf := &bytes.Buffer{}
-	tp := inttp.NewReader(r)
+	// fields holds the original fields, and values their massaged lines.
+	var fields []HeaderField
+	var values []*bytes.Buffer
+	appendRaw := func(raw, s []byte) {
+		if len(fields) > 0 {
+			f := &fields[len(fields)-1]
+			f.Raw = append(f.Raw, raw...)
+			values[len(values)-1].Write(s)
+		}
+	}
 	firstHeader := true
 line:
 	for {
-		// Pull out each line of the headers as a temporary slice s
-		s, err := tp.ReadLineBytes()
-		if err != nil {
+		// Pull out each line of the headers as a temporary slice s, retaining the raw line.
+		raw, err := r.ReadBytes('\n')
+		if len(raw) == 0 && err != nil {
 			buf.Write([]byte{'\r', '\n'})
 			break
 		}
+		s := bytes.TrimSuffix(bytes.TrimSuffix(raw, []byte{'\n'}), []byte{'\r'})
 
 		firstColon := bytes.IndexByte(s, ':')
 		firstSpace := bytes.IndexAny(s, " \t\n\r")
@@ -120,6 +140,7 @@ line:
 			// Starts with space: continuation
 			buf.WriteByte(' ')
 			buf.Write(inttp.TrimBytes(s))
+			appendRaw(raw, append([]byte{' '}, inttp.TrimBytes(s)...))
 			continue
 		}
 		if firstColon == 0 {
@@ -156,12 +177,15 @@ line:
 			s = inttp.TrimBytes(s)
 			buf.Write(s)
 			firstHeader = false
+			fields = append(fields, HeaderField{Raw: raw})
+			values = append(values, bytes.NewBuffer(append([]byte(nil), s...)))
 		} else {
 			// No colon: potential non-indented continuation
 			if len(s) > 0 {
 				// Attempt to detect and repair a non-indented continuation of previous line
 				buf.WriteByte(' ')
 				buf.Write(s)
+				appendRaw(raw, append([]byte{' '}, s...))
 				p.AddWarning(ErrorMalformedHeader, "Continued line %q was not indented", s)
 			} else {
 				// Empty line, finish header parsing
@@ -174,11 +198,23 @@ line:
 	buf.Write([]byte{'\r', '\n'})
 	tr := inttp.NewReader(bufio.NewReader(buf))
 	header, err := tr.ReadEmailMIMEHeader()
-	return textproto.MIMEHeader(header), errors.WithStack(err)
+	if err != nil {
+		return textproto.MIMEHeader(header), nil, errors.WithStack(err)
+	}
+	for i := range fields {
+		name, value, _ := strings.Cut(values[i].String(), ":")
+		fields[i].Name = strings.TrimSpace(name)
+		fields[i].Value = strings.Trim(value, " \t")
+	}
+	return textproto.MIMEHeader(header), fields, nil
 }
 
 // readHeader reads a block of SMTP or MIME headers and returns a textproto.MIMEHeader.
 // Header parse warnings & errors will be added to p.Errors, io errors will be returned directly.
 func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
-	return ReadHeader(r, &partErrorCollector{p})
+	header, fields, err := readHeaderFields(r, &partErrorCollector{p})
+	if err == nil {
+		p.OrderedHeader = &OrderedHeader{fields: fields}
+	}
+	return header, err
 }
@@ -0,0 +1,423 @@
+package enmime
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"strings"
+	_utf8 "unicode/utf8"
+
+	"github.com/jhillyerd/enmime/v2/internal/coding"
+	"github.com/jhillyerd/enmime/v2/internal/stringutil"
+	"github.com/pkg/errors"
+)
+
+// maxHeaderLineLen is the length header fields are folded to where possible, leaving headroom
+// under the 78 characters recommended by RFC 5322.
+const maxHeaderLineLen = 76
+
+// minEncodedWordSpace is the space remaining on a line below which an encoded-word is started on
+// a new line, rather than split into tiny pieces.
+const minEncodedWordSpace = 24
+
+// extraAddressHeaders lists address list header fields not included in AddressHeaders, which are
+// also formatted as address lists when encoding.
+var extraAddressHeaders = map[string]bool{
+	"disposition-notification-to": true,
+	"mail-followup-to":            true,
+	"mail-reply-to":               true,
+	"resent-bcc":                  true,
+	"resent-cc":                   true,
+	"resent-from":                 true,
+	"resent-sender":               true,
+	"resent-to":                   true,
+	"return-receipt-to":           true,
+}
+
+// headerToken is a token of a header value, along with the whitespace preceding it.
+type headerToken struct {
+	ws, text string
+}
+
+// headerEncoder formats a single header field, encoding only the words that require it and
+// folding lines at whitespace permitted by RFC 5322.
+type headerEncoder struct {
+	p       *Part
+	charset string // charset label of encoded-words
+	convert bool   // convert encoded text from UTF-8 into charset
+	sb      strings.Builder
+	line    int  // length of the current line
+	fold    bool // the next token may be folded onto a new line
+}
+
+// encodeHeaderField returns the header field "name: value" encoded and folded, terminated by
+// CRLF.  Address lists have only their display names encoded, while unstructured text has only
+// the words containing non-ASCII characters encoded.  Encoded-words are split on character
+// boundaries to keep them within the 75 characters permitted by RFC 2047.
+func (p *Part) encodeHeaderField(name, value string) (string, error) {
+	enc := p.activeEncoder()
+	raw := p.parser != nil && p.parser.rawContent
+	if enc != nil && enc.smtpUTF8 && isRawUTF8Header(value) {
+		// RFC 6532: UTF-8 is permitted in header values as is.
+		raw = true
+	}
+
+	h := &headerEncoder{p: p, charset: utf8}
+	if enc != nil && enc.charset != "" {
+		h.charset, h.convert = strings.ToLower(enc.charset), true
+	}
+	s, err := h.field(name, value, raw)
+	if err != nil && h.convert {
+		if !enc.charsetFallback {
+			return "", errors.WithMessagef(err, "failed to encode %s header", name)
+		}
+		p.addWarningf(ErrorCharsetConversion, "%s header encoded as %s: %v", name, utf8, err)
+		h = &headerEncoder{p: p, charset: utf8}
+		s, err = h.field(name, value, raw)
+	}
+	return s, err
+}
+
+// field formats the header field according to the structure of its value.  Values are folded
+// but never encoded when raw is true.
+func (h *headerEncoder) field(name, value string, raw bool) (string, error) {
+	h.sb.WriteString(name)
+	h.sb.WriteByte(':')
+	h.line = len(name) + 1
+
+	lname := strings.ToLower(name)
+	addrList := AddressHeaders[lname] || extraAddressHeaders[lname]
+	var err error
+	switch {
+	case raw:
+		h.tokens(value)
+	case addrList && (needsEncodedWord(value) || strings.Contains(value, "=?")):
+		// Display names are re-encoded, so that only the words requiring it are encoded.
+		if addrs, perr := ParseAddressList(value); perr == nil {
+			err = h.addresses(addrs)
+		} else if needsEncodedWord(value) {
+			err = h.unstructured(value)
+		} else {
+			h.tokens(value)
+		}
+	case needsEncodedWord(value):
+		// Structured fields are not permitted to contain non-ASCII text; encoding it as
+		// unstructured text is the best that can be done.
+		err = h.unstructured(value)
+	default:
+		h.tokens(value)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !h.fold {
+		// Empty value.
+		h.sb.WriteByte(' ')
+	}
+	h.sb.WriteString("\r\n")
+	return h.sb.String(), nil
+}
+
+// write appends text preceded by the whitespace ws, folding before ws when text would not
+// otherwise fit on the current line.
+func (h *headerEncoder) write(ws, text string) {
+	if ws == "" {
+		ws = " "
+	}
+	if h.fold && h.line+len(ws)+len(text) > maxHeaderLineLen {
+		h.sb.WriteString("\r\n")
+		h.line = 0
+	}
+	h.sb.WriteString(ws)
+	h.sb.WriteString(text)
+	h.line += len(ws) + len(text)
+	h.fold = true
+}
+
+// tokens formats a value without encoding it, folding only at whitespace outside of
+// quoted-strings, comments and angle brackets.
+func (h *headerEncoder) tokens(value string) {
+	for _, t := range splitTokens(value) {
+		h.write(t.ws, t.text)
+	}
+}
+
+// unstructured formats RFC 5322 unstructured text, encoding runs of words which contain
+// non-ASCII or control characters.  Whitespace between the words of a run is encoded along with
+// them, as whitespace between adjacent encoded-words is ignored when decoding.
+func (h *headerEncoder) unstructured(value string) error {
+	words := splitWords(value)
+	for i := 0; i < len(words); i++ {
+		if !needsEncodedWord(words[i].text) {
+			h.write(words[i].ws, words[i].text)
+			continue
+		}
+		ws, run := words[i].ws, words[i].text
+		for i+1 < len(words) && needsEncodedWord(words[i+1].text) {
+			i++
+			run += words[i].ws + words[i].text
+		}
+		if err := h.encoded(ws, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addresses formats an address list, encoding only display names.  Addresses are never encoded,
+// but IDN domains are converted to punycode.  An error is returned for addresses which cannot be
+// represented in ASCII, such as those with UTF-8 local parts.
+func (h *headerEncoder) addresses(addrs []*mail.Address) error {
+	for i, a := range addrs {
+		ws := " "
+		if i == 0 {
+			ws = ""
+		}
+		if a.Name != "" {
+			if err := h.phrase(ws, a.Name); err != nil {
+				return err
+			}
+			ws = " "
+		}
+		addr, err := stringutil.ToASCIIAddress(a.Address)
+		if err != nil {
+			return err
+		}
+		// Format the address alone, which quotes the local part where required.
+		addr = (&mail.Address{Address: addr}).String()
+		if i < len(addrs)-1 {
+			addr += ","
+		}
+		h.write(ws, addr)
+	}
+	return nil
+}
+
+// phrase formats the display name of an address.  ASCII names are written as a quoted-string,
+// matching net/mail; otherwise words which are not atoms are encoded.
+func (h *headerEncoder) phrase(ws, name string) error {
+	if !needsEncodedWord(name) {
+		h.write(ws, quoteString(name))
+		return nil
+	}
+	words := splitWords(name)
+	for i := 0; i < len(words); i++ {
+		if i > 0 {
+			ws = words[i].ws
+		}
+		if isAtom(words[i].text) {
+			h.write(ws, words[i].text)
+			continue
+		}
+		run := words[i].text
+		for i+1 < len(words) && !isAtom(words[i+1].text) {
+			i++
+			run += words[i].ws + words[i].text
+		}
+		if err := h.encoded(ws, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encoded writes text preceded by the whitespace ws as one or more encoded-words.  The first
+// encoded-word fills the remainder of the current line when there is space for it.
+func (h *headerEncoder) encoded(ws, text string) error {
+	if ws == "" {
+		ws = " "
+	}
+	b64 := h.p.selectTransferEncoding([]byte(text), true) == teBase64
+	for text != "" {
+		space := min(maxEncodedWordLen, maxHeaderLineLen-h.line-len(ws))
+		if h.fold && space < minEncodedWordSpace {
+			h.sb.WriteString("\r\n")
+			h.line = 0
+			space = maxEncodedWordLen
+		}
+		word, rest, err := h.encodeWord(text, b64, space)
+		if err != nil {
+			return err
+		}
+		if word == "" {
+			// Nothing fits on the first line of the header; exceed the line length.
+			if word, rest, err = h.encodeWord(text, b64, maxEncodedWordLen); err != nil {
+				return err
+			}
+			if word == "" {
+				return errors.Errorf("charset %q too long for an encoded-word", h.charset)
+			}
+		}
+		h.sb.WriteString(ws)
+		h.sb.WriteString(word)
+		h.line += len(ws) + len(word)
+		h.fold = true
+		text, ws = rest, " "
+	}
+	return nil
+}
+
+// encodeWord encodes the longest prefix of text, ending on a character boundary, that fits into
+// an encoded-word of at most maxLen characters.  It returns the encoded-word and the remainder
+// of text; the encoded-word is empty if not even one character fits.
+func (h *headerEncoder) encodeWord(text string, b64 bool, maxLen int) (word, rest string, err error) {
+	prefix := "=?" + h.charset + "?q?"
+	if b64 {
+		prefix = "=?" + h.charset + "?b?"
+	}
+	maxLen -= len(prefix) + len("?=")
+
+	var best []byte
+	end := 0
+	for end < len(text) {
+		_, size := _utf8.DecodeRuneInString(text[end:])
+		chunk := []byte(text[:end+size])
+		if h.convert {
+			if chunk, err = coding.ConvertFromUTF8(h.charset, chunk); err != nil {
+				return "", "", err
+			}
+		}
+		if encodedWordLen(chunk, b64) > maxLen {
+			break
+		}
+		best, end = chunk, end+size
+	}
+	if end == 0 {
+		return "", text, nil
+	}
+	if b64 {
+		return prefix + base64.StdEncoding.EncodeToString(best) + "?=", text[end:], nil
+	}
+	return prefix + qEncode(best) + "?=", text[end:], nil
+}
+
+// encodedWordLen returns the length of the encoded text of an encoded-word holding b.
+func encodedWordLen(b []byte, b64 bool) int {
+	if b64 {
+		return base64.StdEncoding.EncodedLen(len(b))
+	}
+	n := 0
+	for _, c := range b {
+		if isQSafe(c) {
+			n++
+		} else {
+			n += 3
+		}
+	}
+	return n
+}
+
+// qEncode applies the RFC 2047 "Q" encoding to b, restricted to the characters permitted in a
+// phrase so that the result is valid in any header.
+func qEncode(b []byte) string {
+	sb := &strings.Builder{}
+	for _, c := range b {
+		switch {
+		case c == ' ':
+			sb.WriteByte('_')
+		case isQSafe(c):
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(sb, "=%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// isQSafe returns true if c may appear unencoded in a "Q" encoded-word within a phrase, where
+// space is represented by an underscore.
+func isQSafe(c byte) bool {
+	return c == ' ' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!*+-/", c) >= 0
+}
+
+// isAtom returns true if word is an RFC 5322 atom which does not resemble an encoded-word.
+func isAtom(word string) bool {
+	if word == "" || strings.Contains(word, "=?") {
+		return false
+	}
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if c <= ' ' || c > '~' || strings.IndexByte(`()<>[]:;@\,."`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteString returns s as an RFC 5322 quoted-string.
+func quoteString(s string) string {
+	sb := &strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// splitWords splits a header value into words separated by spaces and tabs.
+func splitWords(value string) []headerToken {
+	var words []headerToken
+	for value != "" {
+		n := len(value) - len(strings.TrimLeft(value, " \t"))
+		ws := value[:n]
+		value = value[n:]
+		n = strings.IndexAny(value, " \t")
+		if n < 0 {
+			n = len(value)
+		}
+		if n > 0 {
+			words = append(words, headerToken{ws, value[:n]})
+		}
+		value = value[n:]
+	}
+	return words
+}
+
+// splitTokens splits a structured header value at whitespace outside of quoted-strings,
+// comments and angle brackets, where folding is permitted.
+func splitTokens(value string) []headerToken {
+	var tokens []headerToken
+	ws, start := "", 0
+	quoted, escaped := false, false
+	depth, angle := 0, 0
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case escaped:
+			escaped = false
+			continue
+		case c == '\\' && (quoted || depth > 0):
+			escaped = true
+			continue
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth > 0:
+		case c == '<':
+			angle++
+		case c == '>' && angle > 0:
+			angle--
+		}
+		if (c == ' ' || c == '\t') && !quoted && depth == 0 && angle == 0 {
+			if i > start {
+				tokens = append(tokens, headerToken{ws, value[start:i]})
+				ws = ""
+			}
+			ws += value[i : i+1]
+			start = i + 1
+		}
+	}
+	if start < len(value) {
+		tokens = append(tokens, headerToken{ws, value[start:]})
+	}
+	return tokens
+}
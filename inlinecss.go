@@ -0,0 +1,336 @@
+package enmime
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// cssCompound is a compound CSS selector, such as p.note#first.
+type cssCompound struct {
+	tag     string // Element name, or empty to match any element.
+	id      string
+	classes []string
+}
+
+// cssSelector is a sequence of compound selectors joined by descendant (' ') or child ('>')
+// combinators.
+type cssSelector struct {
+	compounds   []cssCompound
+	combinators []byte // combinators[i] joins compounds[i] and compounds[i+1].
+	specificity int
+}
+
+// cssRule is a style rule with a selector supported for inlining.
+type cssRule struct {
+	selector cssSelector
+	decls    []string
+}
+
+// inlineCSS applies the rules of the <style> elements in an HTML document to the style attributes
+// of the elements they match, as many mail clients ignore style sheets.  Rules are applied in
+// order of specificity; declarations already present in style attributes take precedence.  At-rules,
+// such as @media queries, and rules with selectors that cannot be inlined, such as those with
+// pseudo-classes, are left in the style sheet.
+func inlineCSS(src []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse HTML")
+	}
+
+	var styles []*html.Node
+	var elems []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.DataAtom == atom.Style {
+				styles = append(styles, n)
+			} else {
+				elems = append(elems, n)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var rules []cssRule
+	for _, s := range styles {
+		css := ""
+		for c := s.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				css += c.Data
+			}
+		}
+		var kept string
+		rules, kept = parseStyleSheet(css, rules)
+		for s.FirstChild != nil {
+			s.RemoveChild(s.FirstChild)
+		}
+		if strings.TrimSpace(kept) == "" {
+			s.Parent.RemoveChild(s)
+			continue
+		}
+		s.AppendChild(&html.Node{Type: html.TextNode, Data: kept})
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].selector.specificity < rules[j].selector.specificity
+	})
+
+	for _, n := range elems {
+		var decls []string
+		for _, r := range rules {
+			if r.selector.matches(n) {
+				decls = append(decls, r.decls...)
+			}
+		}
+		if len(decls) == 0 {
+			continue
+		}
+		decls = append(decls, splitCSSDeclarations(getAttr(n, "style"))...)
+		setAttr(n, "style", mergeDeclarations(decls))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := html.Render(buf, doc); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseStyleSheet appends the inlinable rules of css to rules, and returns the remainder of the
+// style sheet to be kept.
+func parseStyleSheet(css string, rules []cssRule) ([]cssRule, string) {
+	// Remove comments.
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			css = css[:start]
+			break
+		}
+		css = css[:start] + css[start+2+end+2:]
+	}
+
+	kept := &strings.Builder{}
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+		open := strings.IndexByte(css, '{')
+		if strings.HasPrefix(css, "@") {
+			// At-rules are kept as is: either a statement ending in a semicolon, or a block.
+			if semi := strings.IndexByte(css, ';'); semi >= 0 && (open < 0 || semi < open) {
+				kept.WriteString(css[:semi+1] + "\n")
+				css = css[semi+1:]
+				continue
+			}
+		}
+		if open < 0 {
+			break
+		}
+		end := matchingBrace(css, open)
+		prelude, block := strings.TrimSpace(css[:open]), css[open+1:end]
+		css = css[min(end+1, len(css)):]
+		if strings.HasPrefix(prelude, "@") {
+			kept.WriteString(prelude + " {" + block + "}\n")
+			continue
+		}
+
+		decls := splitCSSDeclarations(block)
+		var unsupported []string
+		for _, sel := range strings.Split(prelude, ",") {
+			sel = strings.TrimSpace(sel)
+			if s, ok := parseSelector(sel); ok {
+				rules = append(rules, cssRule{selector: s, decls: decls})
+			} else if sel != "" {
+				unsupported = append(unsupported, sel)
+			}
+		}
+		if len(unsupported) > 0 {
+			kept.WriteString(strings.Join(unsupported, ", ") + " {" + block + "}\n")
+		}
+	}
+	return rules, kept.String()
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or len(css) if there is
+// none.
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// parseSelector parses a selector consisting of type, class, ID and universal selectors joined
+// by descendant or child combinators.  ok is false for any other selector.
+func parseSelector(sel string) (s cssSelector, ok bool) {
+	fields := strings.Fields(strings.ReplaceAll(sel, ">", " > "))
+	if len(fields) == 0 {
+		return s, false
+	}
+	comb := byte(0)
+	for _, f := range fields {
+		if f == ">" {
+			if comb != 0 || len(s.compounds) == 0 {
+				return s, false
+			}
+			comb = '>'
+			continue
+		}
+		c, ok := parseCompound(f)
+		if !ok {
+			return s, false
+		}
+		if len(s.compounds) > 0 {
+			if comb == 0 {
+				comb = ' '
+			}
+			s.combinators = append(s.combinators, comb)
+		}
+		comb = 0
+		s.compounds = append(s.compounds, c)
+		if c.id != "" {
+			s.specificity += 10000
+		}
+		s.specificity += 100 * len(c.classes)
+		if c.tag != "" {
+			s.specificity++
+		}
+	}
+	return s, comb == 0
+}
+
+// parseCompound parses a compound selector such as p.note#first or *.
+func parseCompound(f string) (c cssCompound, ok bool) {
+	if strings.HasPrefix(f, "*") {
+		f = f[1:]
+	} else {
+		n := identLen(f)
+		c.tag = strings.ToLower(f[:n])
+		f = f[n:]
+	}
+	for f != "" {
+		kind := f[0]
+		n := identLen(f[1:])
+		if n == 0 {
+			return c, false
+		}
+		name := f[1 : n+1]
+		f = f[n+1:]
+		switch kind {
+		case '.':
+			c.classes = append(c.classes, name)
+		case '#':
+			if c.id != "" {
+				return c, false
+			}
+			c.id = name
+		default:
+			return c, false
+		}
+	}
+	return c, true
+}
+
+// identLen returns the length of the CSS identifier at the start of s.
+func identLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '-' || c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' ||
+			'A' <= c && c <= 'Z' || c >= 0x80) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// matches reports whether the element n matches the selector.
+func (s cssSelector) matches(n *html.Node) bool {
+	return s.matchFrom(n, len(s.compounds)-1)
+}
+
+// matchFrom reports whether n matches compounds[i], and its ancestors the preceding compounds.
+func (s cssSelector) matchFrom(n *html.Node, i int) bool {
+	if !s.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for a := n.Parent; a != nil && a.Type == html.ElementNode; a = a.Parent {
+		if s.matchFrom(a, i-1) {
+			return true
+		}
+		if s.combinators[i-1] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+// matches reports whether the element n matches the compound selector.
+func (c cssCompound) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (c.tag != "" && n.Data != c.tag) {
+		return false
+	}
+	if c.id != "" && getAttr(n, "id") != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !hasClass(n, []string{class}) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDeclarations joins CSS declarations into a style attribute value, where later declarations
+// of a property replace earlier ones, unless the earlier declaration is !important.
+func mergeDeclarations(decls []string) string {
+	var names []string
+	values := make(map[string]string)
+	important := make(map[string]bool)
+	for _, d := range decls {
+		name, value, ok := strings.Cut(d, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if name == "" || value == "" {
+			continue
+		}
+		imp := strings.HasSuffix(strings.ToLower(value), "!important")
+		if _, seen := values[name]; !seen {
+			names = append(names, name)
+		} else if important[name] && !imp {
+			continue
+		}
+		values[name], important[name] = value, imp
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + values[name]
+	}
+	return strings.Join(parts, "; ")
+}
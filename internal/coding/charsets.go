@@ -313,6 +313,29 @@ func ConvertToUTF8String(charset string, textBytes []byte) (string, error) {
 	return string(output), nil
 }
 
+// ConvertFromUTF8 encodes UTF-8 text into the provided charset.  If text contains a character
+// that cannot be represented in the charset, the returned error identifies the character and
+// its byte offset.
+func ConvertFromUTF8(charset string, text []byte) ([]byte, error) {
+	csentry, ok := encodings[strings.ToLower(charset)]
+	if !ok {
+		return nil, errors.Errorf("unsupported charset %q", charset)
+	}
+	output, err := csentry.e.NewEncoder().Bytes(text)
+	if err != nil {
+		// Locate the first character the encoder rejected.
+		enc := csentry.e.NewEncoder()
+		for i, r := range string(text) {
+			if _, rerr := enc.String(string(r)); rerr != nil {
+				return nil, errors.Errorf(
+					"character %q at offset %d cannot be represented in charset %q", r, i, charset)
+			}
+		}
+		return nil, errors.WithStack(err)
+	}
+	return output, nil
+}
+
 // NewCharsetReader generates charset-conversion readers, converting from the provided charset into
 // UTF-8.  CharsetReader is a factory signature defined by Go's mime.WordDecoder.
 //
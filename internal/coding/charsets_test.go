@@ -98,3 +98,35 @@ func TestConvertToUTF8String(t *testing.T) {
 		t.Error("Charset 123 should not exist")
 	}
 }
+
+func TestConvertFromUTF8(t *testing.T) {
+	var testTable = []struct {
+		charset string
+		input   string
+		want    []byte
+	}{
+		{"utf-8", "abcABC—", []byte("abcABC—")},
+		{"windows-1250", "aZ–", []byte{'a', 'Z', 0x96}},
+		{"ISO-2022-JP", "日", []byte("\x1b$BF|\x1b(B")},
+		{"koi8-r", "м", []byte{0xcd}},
+	}
+	for _, v := range testTable {
+		b, err := coding.ConvertFromUTF8(v.charset, []byte(v.input))
+		if err != nil {
+			t.Errorf("Conversion to %s failed: %v", v.charset, err)
+		}
+		if !bytes.Equal(b, v.want) {
+			t.Errorf("Got %q, but wanted %q", b, v.want)
+		}
+	}
+	// Fail for unrepresentable character
+	_, err := coding.ConvertFromUTF8("koi8-r", []byte("ab日"))
+	if err == nil || !strings.Contains(err.Error(), "offset 2") {
+		t.Errorf("Got error %v, wanted error identifying offset 2", err)
+	}
+	// Fail for unsupported charset
+	_, err = coding.ConvertFromUTF8("123", []byte("there is no 123 charset"))
+	if err == nil {
+		t.Error("Charset 123 should not exist")
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"net/mail"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/idna"
 )
 
 // JoinAddress formats a slice of Address structs such that they can be used in a To or Cc header.
@@ -21,6 +25,94 @@ func JoinAddress(addrs []mail.Address) string {
 	return buf.String()
 }
 
+// JoinAddressUTF8 formats a slice of Address structs like JoinAddress, but leaves display names
+// as raw UTF-8 rather than RFC 2047 encoded-words, as permitted by RFC 6532.
+func JoinAddressUTF8(addrs []mail.Address) string {
+	buf := &bytes.Buffer{}
+	for i, a := range addrs {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString(FormatAddressUTF8(a))
+	}
+	return buf.String()
+}
+
+// FormatAddressUTF8 formats an Address for use in a header, leaving the display name as raw
+// UTF-8, quoted when it contains special characters.
+func FormatAddressUTF8(a mail.Address) string {
+	// Format the address alone, which quotes the local part where required.
+	addr := (&mail.Address{Address: a.Address}).String()
+	if a.Name == "" {
+		return addr
+	}
+	return quotePhrase(a.Name) + " " + addr
+}
+
+// quotePhrase returns name unchanged if it is a sequence of atoms, which may contain UTF-8 per
+// RFC 6532, otherwise as a quoted-string.
+func quotePhrase(name string) string {
+	atoms := true
+	for _, r := range name {
+		if r >= utf8.RuneSelf || r == ' ' {
+			continue
+		}
+		if r < ' ' || r == 0x7f || strings.ContainsRune(`()<>[]:;@\,."`, r) {
+			atoms = false
+			break
+		}
+	}
+	if atoms && strings.TrimSpace(name) == name {
+		return name
+	}
+	sb := &strings.Builder{}
+	sb.WriteByte('"')
+	for _, r := range name {
+		if r == '"' || r == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// ToASCIIAddress converts the domain of an email address to its ASCII (punycode) form, for use
+// with mail servers that do not support SMTPUTF8.  An error is returned if the local part
+// contains non-ASCII characters, as it cannot be represented without SMTPUTF8.
+func ToASCIIAddress(addr string) (string, error) {
+	at := strings.LastIndexByte(addr, '@')
+	if at < 0 {
+		if !IsASCII(addr) {
+			return "", errors.Errorf("address %q contains non-ASCII characters", addr)
+		}
+		return addr, nil
+	}
+	local, domain := addr[:at], addr[at+1:]
+	if !IsASCII(local) {
+		return "", errors.Errorf("address %q has a non-ASCII local part, which requires SMTPUTF8",
+			addr)
+	}
+	if IsASCII(domain) {
+		return addr, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid domain in address %q", addr)
+	}
+	return local + "@" + ascii, nil
+}
+
+// IsASCII returns true if s contains only 7bit characters.
+func IsASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
 // EnsureCommaDelimitedAddresses is used by AddressList to ensure that address lists are properly
 // delimited.
 func EnsureCommaDelimitedAddresses(s string) string {
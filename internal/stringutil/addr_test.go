@@ -134,3 +134,39 @@ func TestCommaDelimitedAddressLists(t *testing.T) {
 		})
 	}
 }
+
+func TestJoinAddressUTF8(t *testing.T) {
+	input := []mail.Address{
+		{Name: "Jösé", Address: "用户@例子.广告"},
+		{Name: "Bob, Jr.", Address: "bob@example.com"},
+		{Name: `Say "hi"`, Address: "x y@example.com"},
+		{Name: "", Address: "plain@example.com"},
+	}
+	want := `Jösé <用户@例子.广告>, "Bob, Jr." <bob@example.com>, "Say \"hi\"" <"x y"@example.com>, ` +
+		`<plain@example.com>`
+	if got := stringutil.JoinAddressUTF8(input); got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestToASCIIAddress(t *testing.T) {
+	testCases := []struct {
+		input, want string
+		err         bool
+	}{
+		{"user@example.com", "user@example.com", false},
+		{"user@bücher.de", "user@xn--bcher-kva.de", false},
+		{"user@例子.广告", "user@xn--fsqu00a.xn--4rr70v", false},
+		{"用户@example.com", "", true},
+		{"", "", false},
+	}
+	for _, tc := range testCases {
+		got, err := stringutil.ToASCIIAddress(tc.input)
+		if (err != nil) != tc.err {
+			t.Errorf("ToASCIIAddress(%q) error: %v, wanted error: %v", tc.input, err, tc.err)
+		}
+		if got != tc.want {
+			t.Errorf("ToASCIIAddress(%q) got: %q, want: %q", tc.input, got, tc.want)
+		}
+	}
+}
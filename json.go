@@ -0,0 +1,235 @@
+package enmime
+
+import (
+	"encoding/json"
+	"net/textproto"
+	"time"
+)
+
+// partJSON is the JSON form of a Part.  Children are nested, rather than linked through
+// Parent/NextSibling pointers, which keeps the document acyclic.
+type partJSON struct {
+	PartID            string               `json:"partId,omitempty"`
+	Header            textproto.MIMEHeader `json:"header,omitempty"`
+	OrderedHeader     []HeaderField        `json:"orderedHeader,omitempty"`
+	ContentType       string               `json:"contentType,omitempty"`
+	ContentTypeParams map[string]string    `json:"contentTypeParams,omitempty"`
+	Boundary          string               `json:"boundary,omitempty"`
+	ContentID         string               `json:"contentId,omitempty"`
+	Disposition       string               `json:"disposition,omitempty"`
+	FileName          string               `json:"fileName,omitempty"`
+	FileModDate       *time.Time           `json:"fileModDate,omitempty"`
+	Charset           string               `json:"charset,omitempty"`
+	OrigCharset       string               `json:"origCharset,omitempty"`
+	Size              int                  `json:"size"`
+	Content           []byte               `json:"content,omitempty"`
+	Epilogue          []byte               `json:"epilogue,omitempty"`
+	Errors            []*Error             `json:"errors,omitempty"`
+	Children          []*partJSON          `json:"children,omitempty"`
+}
+
+// envelopeJSON is the JSON form of an Envelope.  Attachments, Inlines and OtherParts carry the
+// content of those parts; the same parts within Root are identified by their partId and omit
+// their content to avoid duplication.
+type envelopeJSON struct {
+	Header      textproto.MIMEHeader `json:"header,omitempty"`
+	Text        string               `json:"text"`
+	HTML        string               `json:"html"`
+	Root        *partJSON            `json:"root,omitempty"`
+	Attachments []*partJSON          `json:"attachments,omitempty"`
+	Inlines     []*partJSON          `json:"inlines,omitempty"`
+	OtherParts  []*partJSON          `json:"otherParts,omitempty"`
+	Errors      []*Error             `json:"errors,omitempty"`
+}
+
+// MarshalJSON encodes the Part and its descendants.  The object has the fields partId, header,
+// orderedHeader, contentType, contentTypeParams, boundary, contentId, disposition, fileName,
+// fileModDate, charset, origCharset, size, content, epilogue, errors and children.
+// orderedHeader is an array of objects with the fields name, value and raw.  content, epilogue
+// and raw are base64 encoded, children is an array of nested Part objects.  ContentReader is not
+// encoded.
+func (p *Part) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.toJSON(true, true, nil))
+}
+
+// UnmarshalJSON decodes a Part and its descendants produced by MarshalJSON, restoring the Parent,
+// FirstChild and NextSibling links.
+func (p *Part) UnmarshalJSON(b []byte) error {
+	pj := &partJSON{}
+	if err := json.Unmarshal(b, pj); err != nil {
+		return err
+	}
+	pj.toPart(p)
+	return nil
+}
+
+// MarshalJSON encodes the Envelope.  The object has the fields header, text, html, root,
+// attachments, inlines, otherParts and errors.  root holds the Part tree as described by
+// Part.MarshalJSON.  attachments, inlines and otherParts hold the same Part objects without
+// children; within root the content of those parts is omitted, and they are matched by partId.
+func (e *Envelope) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.toJSON(true))
+}
+
+// MarshalJSONMetadata encodes the Envelope like MarshalJSON, but omits the content of all parts,
+// leaving the decoded text and HTML bodies plus part metadata.  The result is suited to listing
+// attachments, but cannot be unmarshaled back into an equivalent Envelope.
+func (e *Envelope) MarshalJSONMetadata() ([]byte, error) {
+	return json.Marshal(e.toJSON(false))
+}
+
+// UnmarshalJSON decodes an Envelope produced by MarshalJSON.  Attachments, Inlines and
+// OtherParts are linked to the matching Parts within Root when their PartIDs are unique.
+func (e *Envelope) UnmarshalJSON(b []byte) error {
+	ej := &envelopeJSON{}
+	if err := json.Unmarshal(b, ej); err != nil {
+		return err
+	}
+	*e = Envelope{
+		Text:   ej.Text,
+		HTML:   ej.HTML,
+		Errors: ej.Errors,
+	}
+
+	// Index tree parts by PartID, discarding ambiguous IDs.
+	byID := make(map[string]*Part)
+	dupes := make(map[string]bool)
+	if ej.Root != nil {
+		e.Root = &Part{}
+		ej.Root.toPart(e.Root)
+		_ = e.Root.DepthMatchAll(func(p *Part) bool {
+			if _, ok := byID[p.PartID]; ok {
+				dupes[p.PartID] = true
+			}
+			byID[p.PartID] = p
+			return false
+		})
+		e.header = &e.Root.Header
+	} else if ej.Header != nil {
+		e.header = &ej.Header
+	}
+	link := func(list []*partJSON) []*Part {
+		if list == nil {
+			return nil
+		}
+		parts := make([]*Part, 0, len(list))
+		for _, pj := range list {
+			if p, ok := byID[pj.PartID]; ok && !dupes[pj.PartID] {
+				if p.Content == nil {
+					p.Content = pj.Content
+				}
+				parts = append(parts, p)
+				continue
+			}
+			p := &Part{}
+			pj.toPart(p)
+			parts = append(parts, p)
+		}
+		return parts
+	}
+	e.Attachments = link(ej.Attachments)
+	e.Inlines = link(ej.Inlines)
+	e.OtherParts = link(ej.OtherParts)
+	return nil
+}
+
+// toJSON converts the Envelope into its JSON form.
+func (e *Envelope) toJSON(withContent bool) *envelopeJSON {
+	ej := &envelopeJSON{
+		Text:   e.Text,
+		HTML:   e.HTML,
+		Errors: e.Errors,
+	}
+	if e.header != nil {
+		ej.Header = *e.header
+	}
+	listed := make(map[*Part]bool)
+	list := func(parts []*Part) []*partJSON {
+		if parts == nil {
+			return nil
+		}
+		out := make([]*partJSON, 0, len(parts))
+		for _, p := range parts {
+			listed[p] = true
+			out = append(out, p.toJSON(withContent, false, nil))
+		}
+		return out
+	}
+	ej.Attachments = list(e.Attachments)
+	ej.Inlines = list(e.Inlines)
+	ej.OtherParts = list(e.OtherParts)
+	if e.Root != nil {
+		ej.Root = e.Root.toJSON(withContent, true, listed)
+	}
+	return ej
+}
+
+// toJSON converts the Part into its JSON form.  Content is omitted when withContent is false, or
+// when the part is present in omitContent.
+func (p *Part) toJSON(withContent, withChildren bool, omitContent map[*Part]bool) *partJSON {
+	pj := &partJSON{
+		PartID:            p.PartID,
+		Header:            p.Header,
+		OrderedHeader:     p.OrderedHeader.Fields(),
+		ContentType:       p.ContentType,
+		ContentTypeParams: p.ContentTypeParams,
+		Boundary:          p.Boundary,
+		ContentID:         p.ContentID,
+		Disposition:       p.Disposition,
+		FileName:          p.FileName,
+		Charset:           p.Charset,
+		OrigCharset:       p.OrigCharset,
+		Size:              len(p.Content),
+		Errors:            p.Errors,
+	}
+	if !p.FileModDate.IsZero() {
+		t := p.FileModDate
+		pj.FileModDate = &t
+	}
+	if withContent {
+		if !omitContent[p] {
+			pj.Content = p.Content
+		}
+		pj.Epilogue = p.Epilogue
+	}
+	if withChildren {
+		for c := p.FirstChild; c != nil; c = c.NextSibling {
+			pj.Children = append(pj.Children, c.toJSON(withContent, true, omitContent))
+		}
+	}
+	return pj
+}
+
+// toPart populates p and its descendants from the JSON form.
+func (pj *partJSON) toPart(p *Part) {
+	*p = Part{
+		PartID:            pj.PartID,
+		Header:            pj.Header,
+		ContentType:       pj.ContentType,
+		ContentTypeParams: pj.ContentTypeParams,
+		Boundary:          pj.Boundary,
+		ContentID:         pj.ContentID,
+		Disposition:       pj.Disposition,
+		FileName:          pj.FileName,
+		Charset:           pj.Charset,
+		OrigCharset:       pj.OrigCharset,
+		Content:           pj.Content,
+		Epilogue:          pj.Epilogue,
+		Errors:            pj.Errors,
+		parser:            &defaultParser,
+	}
+	if p.Header == nil {
+		p.Header = make(textproto.MIMEHeader)
+	}
+	if pj.OrderedHeader != nil {
+		p.OrderedHeader = NewOrderedHeader(pj.OrderedHeader)
+	}
+	if pj.FileModDate != nil {
+		p.FileModDate = *pj.FileModDate
+	}
+	for _, cj := range pj.Children {
+		c := &Part{}
+		cj.toPart(c)
+		p.AddChild(c)
+	}
+}
@@ -0,0 +1,117 @@
+package enmime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/internal/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeJSONRoundTrip(t *testing.T) {
+	files := []string{
+		"attachment.raw",
+		"html-mime-inline.raw",
+		"mime-mixed-related.raw",
+		"other-parts.raw",
+		"non-mime.raw",
+	}
+	for _, name := range files {
+		t.Run(name, func(t *testing.T) {
+			want, err := enmime.ReadEnvelope(test.OpenTestData("mail", name))
+			require.NoError(t, err)
+
+			b, err := json.Marshal(want)
+			require.NoError(t, err)
+			got := &enmime.Envelope{}
+			require.NoError(t, json.Unmarshal(b, got))
+
+			test.CompareEnvelope(t, got, want)
+			assert.Equal(t, want.GetHeader("Subject"), got.GetHeader("Subject"))
+			assert.Equal(t, want.Errors, got.Errors)
+			assert.NotZero(t, got.Root.OrderedHeader.Len())
+			assert.Equal(t, want.Root.OrderedHeader.Fields(), got.Root.OrderedHeader.Fields())
+
+			for i, p := range want.Attachments {
+				assert.Equal(t, p.Content, got.Attachments[i].Content)
+			}
+			for i, p := range want.Inlines {
+				assert.Equal(t, p.Content, got.Inlines[i].Content)
+			}
+
+			// Lists must point into the reconstructed tree.
+			for _, p := range got.Attachments {
+				assert.Same(t, p, got.Root.BreadthMatchFirst(func(c *enmime.Part) bool {
+					return c.PartID == p.PartID
+				}))
+			}
+		})
+	}
+}
+
+func TestEnvelopeJSONForm(t *testing.T) {
+	env, err := enmime.ReadEnvelope(test.OpenTestData("mail", "attachment.raw"))
+	require.NoError(t, err)
+
+	b, err := json.Marshal(env)
+	require.NoError(t, err)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(b, &doc))
+
+	assert.Contains(t, doc, "header")
+	assert.Contains(t, doc, "text")
+	assert.Contains(t, doc, "root")
+	attachments := doc["attachments"].([]any)
+	require.Len(t, attachments, 1)
+	att := attachments[0].(map[string]any)
+	assert.Equal(t, env.Attachments[0].PartID, att["partId"])
+	assert.Equal(t, env.Attachments[0].FileName, att["fileName"])
+	assert.EqualValues(t, len(env.Attachments[0].Content), att["size"])
+	assert.NotEmpty(t, att["content"])
+	assert.NotContains(t, att, "children")
+
+	// Content is not duplicated inside the tree.
+	root := doc["root"].(map[string]any)
+	for _, c := range root["children"].([]any) {
+		child := c.(map[string]any)
+		if child["partId"] == att["partId"] {
+			assert.NotContains(t, child, "content")
+		}
+	}
+}
+
+func TestEnvelopeJSONMetadata(t *testing.T) {
+	env, err := enmime.ReadEnvelope(test.OpenTestData("mail", "attachment.raw"))
+	require.NoError(t, err)
+
+	b, err := env.MarshalJSONMetadata()
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), `"content"`)
+	assert.Contains(t, string(b), `"size"`)
+}
+
+func TestPartJSONRoundTrip(t *testing.T) {
+	want, err := enmime.ReadParts(test.OpenTestData("mail", "mime-mixed-related.raw"))
+	require.NoError(t, err)
+
+	b, err := json.Marshal(want)
+	require.NoError(t, err)
+	got := &enmime.Part{}
+	require.NoError(t, json.Unmarshal(b, got))
+
+	test.ComparePart(t, got, want)
+	assert.Equal(t, want.OrderedHeader.Fields(), got.OrderedHeader.Fields())
+	for c, wc := got.FirstChild, want.FirstChild; c != nil; c, wc = c.NextSibling, wc.NextSibling {
+		assert.Same(t, got, c.Parent)
+		assert.Equal(t, wc.OrderedHeader.Fields(), c.OrderedHeader.Fields())
+	}
+
+	// The raw header is retained when the part is encoded.
+	buf, wantBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	require.NoError(t, got.Encode(buf))
+	require.NoError(t, want.Encode(wantBuf))
+	assert.Equal(t, wantBuf.String(), buf.String())
+}
@@ -0,0 +1,278 @@
+package enmime
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LMTPSender is a Sender delivering messages with LMTP (RFC 2033) to a local delivery agent, such
+// as Dovecot, over TCP or a Unix socket.  Unlike an SMTP server, an LMTP server replies to the
+// message content once for each recipient, so delivery may fail for some recipients after they
+// were accepted.  Each message is sent over a new connection.
+type LMTPSender struct {
+	network   string
+	addr      string
+	localName string
+	timeout   time.Duration
+}
+
+var (
+	_ ContextSender = &LMTPSender{}
+	_ PartSender    = &LMTPSender{}
+)
+
+// NewLMTP creates a new LMTPSender for the server at addr on the named network, which is "tcp"
+// with a host:port address, or "unix" with the path of a socket.
+func NewLMTP(network, addr string, opts ...LMTPOption) *LMTPSender {
+	s := &LMTPSender{
+		network:   network,
+		addr:      addr,
+		localName: "localhost",
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(s)
+		}
+	}
+	return s
+}
+
+// Send sends msg as SendContext does, without a context.  A *RecipientError is returned if
+// delivery to any recipient failed.
+func (s *LMTPSender) Send(reversePath string, recipients []string, msg []byte) error {
+	res, err := s.SendContext(context.Background(), reversePath, recipients, msg)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// SendContext sends msg to the specified recipients, aborting when ctx is done.  Addresses are
+// handled as by SMTPSender.Send.  An error is returned if the message could not be delivered to
+// any recipient; otherwise recipients rejected either by RCPT or after the message content are
+// listed in the result.  The RecipientResult of each delivered recipient holds the reply to the
+// message content, and the Code and Message of the result hold the last of these replies.
+func (s *LMTPSender) SendContext(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*SendResult, error) {
+	return s.send(ctx, reversePath, recipients, func(func(string) bool) ([]byte, bool, error) {
+		return msg, false, nil
+	})
+}
+
+// SendPart encodes root and sends it as SendPartContext does, without a context.  A
+// *RecipientError is returned if delivery to any recipient failed.
+func (s *LMTPSender) SendPart(reversePath string, recipients []string, root *Part) error {
+	res, err := s.SendPartContext(context.Background(), reversePath, recipients, root)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// SendPartContext encodes root and sends it as SendContext does, choosing the
+// TransferEncodingPolicy from the LHLO extensions of the server as SMTPSender.SendPart does.
+func (s *LMTPSender) SendPartContext(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	root *Part,
+) (*SendResult, error) {
+	return s.send(ctx, reversePath, recipients, func(ext func(string) bool) ([]byte, bool, error) {
+		return encodeForExtensions(root, ext)
+	})
+}
+
+// send delivers the message returned by body, which is called once connected to the server.
+func (s *LMTPSender) send(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	body func(ext func(string) bool) (msg []byte, binary bool, err error),
+) (*SendResult, error) {
+	if err := validateLine(reversePath); err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		if err := validateLine(r); err != nil {
+			return nil, err
+		}
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	stop := watchConn(ctx, conn)
+	tp := textproto.NewConn(conn)
+	res, err := s.transaction(tp, reversePath, recipients, body)
+	if !stop() {
+		return nil, errors.WithStack(ctx.Err())
+	}
+	if err != nil {
+		var rcptErr *RecipientError
+		if !errors.As(err, &rcptErr) {
+			return nil, err
+		}
+	}
+	_, _ = lmtpCmd(tp, 221, "QUIT")
+	return res, err
+}
+
+// transaction greets the server over tp, then sends a single message.
+func (s *LMTPSender) transaction(
+	tp *textproto.Conn,
+	reversePath string,
+	recipients []string,
+	body func(ext func(string) bool) ([]byte, bool, error),
+) (*SendResult, error) {
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return nil, err
+	}
+	reply, err := lmtpCmd(tp, 250, "LHLO %s", s.localName)
+	if err != nil {
+		return nil, err
+	}
+	ext := lmtpExtensions(reply)
+	has := func(name string) bool {
+		_, ok := ext[name]
+		return ok
+	}
+
+	envRecipients := recipients
+	if !has("SMTPUTF8") {
+		if reversePath, envRecipients, err = asciiEnvelope(reversePath, recipients); err != nil {
+			return nil, err
+		}
+	}
+	msg, binary, err := body(has)
+	if err != nil {
+		return nil, err
+	}
+	params := ""
+	switch {
+	case binary:
+		params += " BODY=BINARYMIME"
+	case has("8BITMIME"):
+		params += " BODY=8BITMIME"
+	}
+	if has("SMTPUTF8") {
+		params += " SMTPUTF8"
+	}
+	if _, err := lmtpCmd(tp, 250, "MAIL FROM:<%s>%s", reversePath, params); err != nil {
+		return nil, err
+	}
+
+	res := &SendResult{}
+	var accepted []RecipientResult
+	for i, r := range envRecipients {
+		rr := RecipientResult{Recipient: recipients[i]}
+		id, err := tp.Cmd("RCPT TO:<%s>", r)
+		if err != nil {
+			return nil, err
+		}
+		tp.StartResponse(id)
+		rr.Code, rr.Message, err = tp.ReadResponse(25)
+		tp.EndResponse(id)
+		if err != nil {
+			var tpErr *textproto.Error
+			if !errors.As(err, &tpErr) {
+				return nil, err
+			}
+			rr.Code, rr.Message = tpErr.Code, tpErr.Msg
+			res.Rejected = append(res.Rejected, rr)
+			continue
+		}
+		accepted = append(accepted, rr)
+	}
+	if len(accepted) == 0 {
+		return res, res.Err()
+	}
+
+	if err := lmtpData(tp, msg, binary); err != nil {
+		return nil, err
+	}
+	// RFC 2033 4.2: the server replies once for each accepted recipient, in order.
+	for _, rr := range accepted {
+		code, text, err := tp.ReadResponse(250)
+		if err != nil {
+			var tpErr *textproto.Error
+			if !errors.As(err, &tpErr) {
+				return nil, err
+			}
+			code, text = tpErr.Code, tpErr.Msg
+		}
+		rr.Code, rr.Message = code, text
+		res.Code, res.Message = code, text
+		if err != nil {
+			res.Rejected = append(res.Rejected, rr)
+		} else {
+			res.Accepted = append(res.Accepted, rr)
+		}
+	}
+	if len(res.Accepted) == 0 {
+		return res, res.Err()
+	}
+	return res, nil
+}
+
+// lmtpData sends the message content with DATA, or BDAT when binary.  The replies of the server
+// are left to be read by the caller.
+func lmtpData(tp *textproto.Conn, msg []byte, binary bool) error {
+	if binary {
+		if err := tp.PrintfLine("BDAT %d LAST", len(msg)); err != nil {
+			return err
+		}
+		if _, err := tp.W.Write(msg); err != nil {
+			return err
+		}
+		return tp.W.Flush()
+	}
+	if _, err := lmtpCmd(tp, 354, "DATA"); err != nil {
+		return err
+	}
+	w := tp.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// lmtpCmd sends a command to the server, checks for the expected response code, and returns the
+// reply text.
+func lmtpCmd(tp *textproto.Conn, expectCode int, format string, args ...any) (string, error) {
+	id, err := tp.Cmd(format, args...)
+	if err != nil {
+		return "", err
+	}
+	tp.StartResponse(id)
+	defer tp.EndResponse(id)
+	_, msg, err := tp.ReadResponse(expectCode)
+	return msg, err
+}
+
+// lmtpExtensions parses the extensions listed in the reply to LHLO, keyed by upper case name.
+func lmtpExtensions(reply string) map[string]string {
+	ext := make(map[string]string)
+	lines := strings.Split(reply, "\n")
+	// The first line holds the domain of the server.
+	for _, line := range lines[1:] {
+		name, args, _ := strings.Cut(line, " ")
+		ext[strings.ToUpper(name)] = args
+	}
+	return ext
+}
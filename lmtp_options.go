@@ -0,0 +1,31 @@
+package enmime
+
+import "time"
+
+// LMTPOption configures an LMTPSender.
+type LMTPOption interface {
+	apply(s *LMTPSender)
+}
+
+// LMTPLocalName sets the host name sent in the LHLO command, "localhost" by default.
+func LMTPLocalName(name string) LMTPOption {
+	return lmtpLocalNameOption(name)
+}
+
+type lmtpLocalNameOption string
+
+func (o lmtpLocalNameOption) apply(s *LMTPSender) {
+	s.localName = string(o)
+}
+
+// LMTPTimeout limits the time taken to connect and send each message, in addition to any deadline
+// of the context passed to SendContext.  Zero, the default, sets no limit.
+func LMTPTimeout(d time.Duration) LMTPOption {
+	return lmtpTimeoutOption(d)
+}
+
+type lmtpTimeoutOption time.Duration
+
+func (o lmtpTimeoutOption) apply(s *LMTPSender) {
+	s.timeout = time.Duration(o)
+}
@@ -0,0 +1,87 @@
+package enmime_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/smtptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLMTPSenderRecipientResults(t *testing.T) {
+	srv := smtptest.NewServer(t,
+		smtptest.LMTP(),
+		smtptest.RcptResponse(func(rcpt string) smtptest.Reply {
+			if rcpt == "gone@example.com" {
+				return smtptest.Reply{Code: 550, Message: "5.1.1 No such user"}
+			}
+			return smtptest.Reply{}
+		}),
+		smtptest.DataResponse(func(rcpt string, _ *smtptest.Message) smtptest.Reply {
+			if rcpt == "full@example.com" {
+				return smtptest.Reply{Code: 452, Message: "4.2.2 Mailbox full"}
+			}
+			return smtptest.Reply{Code: 250, Message: "2.0.0 <" + rcpt + "> Saved"}
+		}),
+	)
+	s := enmime.NewLMTP("tcp", srv.Addr)
+
+	rcpts := []string{"bob@example.com", "gone@example.com", "full@example.com"}
+	res, err := s.SendContext(context.Background(), "alice@example.com", rcpts,
+		[]byte("Subject: hi\r\n\r\nbody\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []enmime.RecipientResult{
+		{Recipient: "bob@example.com", Code: 250, Message: "2.0.0 <bob@example.com> Saved"},
+	}, res.Accepted)
+	assert.Equal(t, []enmime.RecipientResult{
+		{Recipient: "gone@example.com", Code: 550, Message: "5.1.1 No such user"},
+		{Recipient: "full@example.com", Code: 452, Message: "4.2.2 Mailbox full"},
+	}, res.Rejected)
+
+	msgs := srv.WaitForMessages(t, 1)
+	msgs[0].AssertRecipients(t, "bob@example.com")
+	msgs[0].AssertHeader(t, "Subject", "hi")
+
+	// Send reports the rejections as an error.
+	err = s.Send("alice@example.com", []string{"full@example.com"}, []byte("Subject: hi\r\n\r\n"))
+	var rcptErr *enmime.RecipientError
+	require.ErrorAs(t, err, &rcptErr)
+	assert.True(t, rcptErr.Temporary())
+}
+
+func TestLMTPSenderUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lmtp.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	srv := smtptest.NewServer(t, smtptest.Listener(l), smtptest.LMTP())
+
+	p, err := enmime.Builder().
+		From("Alice", "alice@example.com").
+		To("Bob", "bob@example.com").
+		Subject("Binary").
+		Text([]byte("Hello")).
+		AddAttachment([]byte{0, 1, 2, '\r', '\n', '.', '\r', '\n'}, "application/octet-stream", "b.bin").
+		Build()
+	require.NoError(t, err)
+	s := enmime.NewLMTP("unix", path, enmime.LMTPLocalName("mx.example.com"))
+	require.NoError(t, s.SendPart("alice@example.com", []string{"bob@example.com"}, p))
+
+	msgs := srv.WaitForMessages(t, 1)
+	assert.Contains(t, msgs[0].Params, "BODY=BINARYMIME")
+	att := msgs[0].AssertAttachment(t, "b.bin")
+	require.NotNil(t, att)
+	assert.Equal(t, []byte{0, 1, 2, '\r', '\n', '.', '\r', '\n'}, att.Content)
+}
+
+func TestLMTPSenderRequiresLMTP(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	s := enmime.NewLMTP("tcp", srv.Addr, enmime.LMTPTimeout(5*time.Second))
+	err := s.Send("alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\n"))
+	assert.Error(t, err)
+	srv.AssertCount(t, 0)
+}
@@ -0,0 +1,234 @@
+// Package maildir delivers and reads messages stored in a Maildir, see:
+// https://cr.yp.to/proto/maildir.html
+package maildir
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/pkg/errors"
+)
+
+// Maildir subdirectory names.
+const (
+	dirTmp = "tmp"
+	dirNew = "new"
+	dirCur = "cur"
+)
+
+// Standard info flags, see Message.Flags.
+const (
+	FlagDraft   = 'D'
+	FlagFlagged = 'F'
+	FlagPassed  = 'P'
+	FlagReplied = 'R'
+	FlagSeen    = 'S'
+	FlagTrashed = 'T'
+)
+
+// infoPrefix separates the unique name of a message from its experimental "2," info.
+const infoPrefix = ":2,"
+
+// deliveries counts deliveries made by this process, to guarantee unique names.
+var deliveries atomic.Uint64
+
+// Maildir is a directory containing tmp, new and cur subdirectories.  Maildir also implements
+// enmime.Sender, delivering every message it is asked to send into new.
+type Maildir struct {
+	path   string
+	parser *enmime.Parser
+}
+
+var _ enmime.Sender = &Maildir{}
+
+// New returns a Maildir rooted at path.  The directory is not created or checked, see Create.
+func New(path string) *Maildir {
+	return &Maildir{
+		path:   path,
+		parser: enmime.NewParser(),
+	}
+}
+
+// WithParser configures the Parser used by Message.Envelope.
+func (d *Maildir) WithParser(p *enmime.Parser) *Maildir {
+	if p != nil {
+		d.parser = p
+	}
+	return d
+}
+
+// Path returns the root directory of the Maildir.
+func (d *Maildir) Path() string {
+	return d.path
+}
+
+// Create creates the Maildir and its subdirectories if they do not already exist.
+func (d *Maildir) Create() error {
+	for _, sub := range []string{dirTmp, dirNew, dirCur} {
+		if err := os.MkdirAll(filepath.Join(d.path, sub), 0o700); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// Deliver encodes p and delivers it atomically into new, returning the unique key of the
+// message.
+func (d *Maildir) Deliver(p *enmime.Part) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := p.Encode(buf); err != nil {
+		return "", err
+	}
+	return d.DeliverMessage(buf)
+}
+
+// DeliverMessage copies a raw message from r into tmp, then renames it into new.  Readers of the
+// Maildir never observe a partially written message.  Returns the unique key of the message.
+func (d *Maildir) DeliverMessage(r io.Reader) (string, error) {
+	key, err := uniqueName()
+	if err != nil {
+		return "", err
+	}
+	tmpPath := filepath.Join(d.path, dirTmp, key)
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", errors.WithStack(err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(d.path, dirNew, key)); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", errors.WithStack(err)
+	}
+	return key, nil
+}
+
+// Send delivers msg into new, adding a Return-Path header containing reversePath.  The recipients
+// are ignored, as the Maildir is the sole destination.
+func (d *Maildir) Send(reversePath string, _ []string, msg []byte) error {
+	if strings.ContainsAny(reversePath, "\n\r") {
+		return errors.New("maildir: reverse-path must not contain CR or LF")
+	}
+	r := io.MultiReader(
+		strings.NewReader("Return-Path: <"+reversePath+">\r\n"),
+		bytes.NewReader(msg))
+	_, err := d.DeliverMessage(r)
+	return err
+}
+
+// Messages lists the messages in new and cur, ordered by key.
+func (d *Maildir) Messages() ([]*Message, error) {
+	var msgs []*Message
+	for _, sub := range []string{dirNew, dirCur} {
+		entries, err := os.ReadDir(filepath.Join(d.path, sub))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			msgs = append(msgs, d.newMessage(sub, e.Name()))
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Key < msgs[j].Key
+	})
+	return msgs, nil
+}
+
+// Message locates the message with the specified key in new or cur.
+func (d *Maildir) Message(key string) (*Message, error) {
+	msgs, err := d.Messages()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if m.Key == key {
+			return m, nil
+		}
+	}
+	return nil, errors.WithStack(os.ErrNotExist)
+}
+
+// SetFlags replaces the info flags of m, moving it into cur.  Flags are stored in ASCII order,
+// duplicates are removed.  An error is returned if flags contains anything but ASCII letters.
+func (d *Maildir) SetFlags(m *Message, flags string) error {
+	for _, c := range []byte(flags) {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return errors.Errorf("maildir: invalid flag %q", c)
+		}
+	}
+	flags = normalizeFlags(flags)
+	name := m.Key + infoPrefix + flags
+	newPath := filepath.Join(d.path, dirCur, name)
+	if err := os.Rename(m.path, newPath); err != nil {
+		return errors.WithStack(err)
+	}
+	m.Dir = dirCur
+	m.Flags = flags
+	m.path = newPath
+	return nil
+}
+
+// newMessage builds a Message from a file name found in sub.
+func (d *Maildir) newMessage(sub, name string) *Message {
+	key, flags := parseName(name)
+	return &Message{
+		Key:    key,
+		Dir:    sub,
+		Flags:  flags,
+		path:   filepath.Join(d.path, sub, name),
+		parser: d.parser,
+	}
+}
+
+// uniqueName generates a delivery name following the modern Maildir naming conventions.
+func uniqueName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s",
+		now.Unix(), now.Nanosecond()/1000, os.Getpid(), deliveries.Add(1), host), nil
+}
+
+// parseName splits a file name into its unique key and info flags.
+func parseName(name string) (key, flags string) {
+	key, info, found := strings.Cut(name, ":")
+	if found && strings.HasPrefix(info, "2,") {
+		flags = info[2:]
+	}
+	return key, flags
+}
+
+// normalizeFlags sorts flags and removes duplicates.
+func normalizeFlags(flags string) string {
+	b := []byte(flags)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	out := b[:0]
+	for i, c := range b {
+		if i == 0 || c != b[i-1] {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,131 @@
+package maildir_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/maildir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMaildir(t *testing.T) *maildir.Maildir {
+	t.Helper()
+	d := maildir.New(filepath.Join(t.TempDir(), "Maildir"))
+	require.NoError(t, d.Create())
+	return d
+}
+
+func TestCreate(t *testing.T) {
+	d := newMaildir(t)
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		info, err := os.Stat(filepath.Join(d.Path(), sub))
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+	}
+	// Creating an existing Maildir is not an error.
+	assert.NoError(t, d.Create())
+}
+
+func TestDeliver(t *testing.T) {
+	d := newMaildir(t)
+	p, err := enmime.Builder().
+		From("", "from@example.com").
+		To("", "to@example.com").
+		Subject("delivered").
+		Text([]byte("body")).
+		Build()
+	require.NoError(t, err)
+
+	key1, err := d.Deliver(p)
+	require.NoError(t, err)
+	key2, err := d.DeliverMessage(strings.NewReader("Subject: raw\r\n\r\nraw body\r\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, key1, key2)
+
+	tmp, err := os.ReadDir(filepath.Join(d.Path(), "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp, "tmp should be empty after delivery")
+
+	m, err := d.Message(key1)
+	require.NoError(t, err)
+	assert.Equal(t, "new", m.Dir)
+	assert.Empty(t, m.Flags)
+	env, err := m.Envelope()
+	require.NoError(t, err)
+	assert.Equal(t, "delivered", env.GetHeader("Subject"))
+	assert.Equal(t, "body", env.Text)
+}
+
+func TestMessagesAndFlags(t *testing.T) {
+	d := newMaildir(t)
+	key, err := d.DeliverMessage(strings.NewReader("Subject: one\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = d.DeliverMessage(strings.NewReader("Subject: two\r\n\r\n"))
+	require.NoError(t, err)
+
+	msgs, err := d.Messages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	m, err := d.Message(key)
+	require.NoError(t, err)
+	require.NoError(t, d.SetFlags(m, "SRFS"))
+	assert.Equal(t, "cur", m.Dir)
+	assert.Equal(t, "FRS", m.Flags)
+	assert.True(t, m.HasFlag(maildir.FlagSeen))
+	assert.False(t, m.HasFlag(maildir.FlagTrashed))
+	assert.Equal(t, key+":2,FRS", filepath.Base(m.Path()))
+
+	// Flags survive a fresh listing.
+	m, err = d.Message(key)
+	require.NoError(t, err)
+	assert.Equal(t, "cur", m.Dir)
+	assert.Equal(t, "FRS", m.Flags)
+
+	msgs, err = d.Messages()
+	require.NoError(t, err)
+	assert.Len(t, msgs, 2)
+
+	// Flags may not change the directory of the message.
+	for _, flags := range []string{"S/../../x", "..", "S:2,", "S\x00"} {
+		assert.Error(t, d.SetFlags(m, flags), flags)
+	}
+	assert.Equal(t, "FRS", m.Flags)
+	_, err = os.Stat(m.Path())
+	assert.NoError(t, err)
+}
+
+func TestMessageNotFound(t *testing.T) {
+	d := newMaildir(t)
+	_, err := d.Message("missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSend(t *testing.T) {
+	d := newMaildir(t)
+	err := enmime.Builder().
+		From("", "from@example.com").
+		To("", "to@example.com").
+		Subject("sent").
+		Text([]byte("body")).
+		Send(d)
+	require.NoError(t, err)
+
+	msgs, err := d.Messages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	env, err := msgs[0].Envelope()
+	require.NoError(t, err)
+	assert.Equal(t, "<from@example.com>", env.GetHeader("Return-Path"))
+	assert.Equal(t, "sent", env.GetHeader("Subject"))
+
+	err = d.Send("from@example.com>\r\nX-Injected: yes\r\nX-Tail: <x", nil, []byte("\r\n"))
+	assert.Error(t, err)
+	msgs, err = d.Messages()
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
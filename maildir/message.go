@@ -0,0 +1,45 @@
+package maildir
+
+import (
+	"os"
+	"strings"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/pkg/errors"
+)
+
+// Message is a message stored in a Maildir.
+type Message struct {
+	Key   string // Key is the unique name of the message, without info.
+	Dir   string // Dir is the subdirectory containing the message, "new" or "cur".
+	Flags string // Flags are the info flags of the message, in ASCII order.
+
+	path   string
+	parser *enmime.Parser
+}
+
+// Path returns the current location of the message file.
+func (m *Message) Path() string {
+	return m.path
+}
+
+// HasFlag returns true if flag is set on the message.
+func (m *Message) HasFlag(flag rune) bool {
+	return strings.ContainsRune(m.Flags, flag)
+}
+
+// Open opens the message file for reading.
+func (m *Message) Open() (*os.File, error) {
+	f, err := os.Open(m.path)
+	return f, errors.WithStack(err)
+}
+
+// Envelope reads and parses the message.
+func (m *Message) Envelope() (*enmime.Envelope, error) {
+	f, err := m.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return m.parser.ReadEnvelope(f)
+}
@@ -0,0 +1,71 @@
+// Package mbox reads and writes collections of messages stored in the Unix mbox format.  The
+// mboxo, mboxrd and mboxcl2 variants are supported, see:
+// https://datatracker.ietf.org/doc/html/rfc4155
+package mbox
+
+import (
+	"bytes"
+	"time"
+)
+
+// Format identifies the mbox variant, which determines how "From " lines inside of messages are
+// escaped, and how message boundaries are located.
+type Format int
+
+const (
+	// Mboxo escapes body lines starting with "From " by prefixing them with '>'.  This escaping is
+	// ambiguous, as lines already starting with ">From " are not escaped further.
+	Mboxo Format = iota
+	// Mboxrd escapes body lines matching /^>*From / by prefixing them with an additional '>',
+	// making the escaping reversible.
+	Mboxrd
+	// Mboxcl2 frames each message with a Content-Length header, and performs no escaping.
+	Mboxcl2
+)
+
+// String returns the conventional name of the format.
+func (f Format) String() string {
+	switch f {
+	case Mboxo:
+		return "mboxo"
+	case Mboxrd:
+		return "mboxrd"
+	case Mboxcl2:
+		return "mboxcl2"
+	}
+	return "unknown"
+}
+
+const (
+	// defaultSender is used in the "From " line when the sender is not known.
+	defaultSender = "MAILER-DAEMON"
+	// hnContentLength is the header used to frame mboxcl2 messages.
+	hnContentLength = "Content-Length"
+)
+
+var fromPrefix = []byte("From ")
+
+// isFromLine returns true if line is a message separator.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, fromPrefix)
+}
+
+// isQuotedFromLine returns true if line matches /^>+From /.
+func isQuotedFromLine(line []byte) bool {
+	i := 0
+	for i < len(line) && line[i] == '>' {
+		i++
+	}
+	return i > 0 && isFromLine(line[i:])
+}
+
+// formatFromLine builds a "From " separator line, without the line ending.
+func formatFromLine(sender string, date time.Time) []byte {
+	if sender == "" {
+		sender = defaultSender
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return []byte("From " + sender + " " + date.UTC().Format(time.ANSIC))
+}
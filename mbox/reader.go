@@ -0,0 +1,213 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/pkg/errors"
+)
+
+// Reader splits an mbox stream into individual messages.  Messages are read one at a time, so
+// only the current message is held in memory.
+type Reader struct {
+	br      *bufio.Reader
+	format  Format
+	parser  *enmime.Parser
+	from    string // Separator line of the current message, without the "From " prefix.
+	pending []byte // Separator line of the next message, if already consumed.
+}
+
+// NewReader creates a Reader which splits r according to format.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{
+		br:     bufio.NewReader(r),
+		format: format,
+		parser: enmime.NewParser(),
+	}
+}
+
+// WithParser configures the Parser used by NextEnvelope.
+func (r *Reader) WithParser(p *enmime.Parser) *Reader {
+	if p != nil {
+		r.parser = p
+	}
+	return r
+}
+
+// From returns the separator line of the most recently read message, with the leading "From "
+// removed.  It usually contains the envelope sender followed by the delivery date.
+func (r *Reader) From() string {
+	return r.from
+}
+
+// NextMessage returns a reader for the raw content of the next message, with mbox escaping
+// removed.  It returns io.EOF when no messages remain.
+func (r *Reader) NextMessage() (io.Reader, error) {
+	line, err := r.readFromLine()
+	if err != nil {
+		return nil, err
+	}
+	r.from = string(trimEOL(line[len(fromPrefix):]))
+
+	buf := &bytes.Buffer{}
+	if r.format == Mboxcl2 {
+		err = r.readFramed(buf)
+	} else {
+		err = r.readDelimited(buf, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// NextEnvelope reads the next message and parses it into an Envelope.  It returns io.EOF when no
+// messages remain.
+func (r *Reader) NextEnvelope() (*enmime.Envelope, error) {
+	msg, err := r.NextMessage()
+	if err != nil {
+		return nil, err
+	}
+	return r.parser.ReadEnvelope(msg)
+}
+
+// readFromLine returns the separator line of the next message, skipping any blank lines before
+// it.
+func (r *Reader) readFromLine() ([]byte, error) {
+	if r.pending != nil {
+		line := r.pending
+		r.pending = nil
+		return line, nil
+	}
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, errors.WithStack(err)
+		}
+		if isFromLine(line) {
+			return line, nil
+		}
+		if len(trimEOL(line)) > 0 {
+			return nil, errors.Errorf("mbox: expected From line, got %q", trimEOL(line))
+		}
+		if err != nil {
+			return nil, io.EOF
+		}
+	}
+}
+
+// readDelimited copies lines into buf until the next separator line or EOF.  The blank line
+// preceding the separator is not considered part of the message.
+func (r *Reader) readDelimited(buf *bytes.Buffer, unquote bool) error {
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if isFromLine(line) {
+			r.pending = line
+			break
+		}
+		if unquote {
+			line = r.unquote(line)
+		}
+		buf.Write(line)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return errors.WithStack(err)
+		}
+	}
+	trimSeparator(buf)
+	return nil
+}
+
+// readFramed copies a Content-Length framed message into buf.  Messages without a valid
+// Content-Length header fall back to separator line detection.
+func (r *Reader) readFramed(buf *bytes.Buffer) error {
+	length := -1
+	for {
+		line, err := r.br.ReadBytes('\n')
+		buf.Write(line)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		hline := trimEOL(line)
+		if len(hline) == 0 {
+			break
+		}
+		name, value, found := strings.Cut(string(hline), ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), hnContentLength) {
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+				length = n
+			}
+		}
+	}
+	if length < 0 {
+		return r.readDelimited(buf, false)
+	}
+	if _, err := io.CopyN(buf, r.br, int64(length)); err != nil {
+		if err == io.EOF {
+			return errors.New("mbox: message shorter than Content-Length")
+		}
+		return errors.WithStack(err)
+	}
+	// Only blank lines may appear between the body and the next separator.
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if isFromLine(line) {
+			r.pending = line
+			return nil
+		}
+		if len(trimEOL(line)) > 0 {
+			return errors.Errorf("mbox: unexpected data following Content-Length body: %q",
+				trimEOL(line))
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// unquote reverses the From line escaping applied by the writer of the mbox.
+func (r *Reader) unquote(line []byte) []byte {
+	switch r.format {
+	case Mboxo:
+		if len(line) > 0 && line[0] == '>' && isFromLine(line[1:]) {
+			return line[1:]
+		}
+	case Mboxrd:
+		if isQuotedFromLine(line) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+// trimEOL removes the trailing LF or CRLF from line.
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	return bytes.TrimSuffix(line, []byte{'\r'})
+}
+
+// trimSeparator removes the blank line that separates a message from the following one.
+func trimSeparator(buf *bytes.Buffer) {
+	b := buf.Bytes()
+	switch {
+	case bytes.HasSuffix(b, []byte("\r\n\r\n")):
+		buf.Truncate(len(b) - 2)
+	case bytes.HasSuffix(b, []byte("\n\n")):
+		buf.Truncate(len(b) - 1)
+	}
+}
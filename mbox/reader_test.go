@@ -0,0 +1,120 @@
+package mbox_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2/mbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllMessages(t *testing.T, r *mbox.Reader) (froms []string, msgs []string) {
+	t.Helper()
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return froms, msgs
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(msg)
+		require.NoError(t, err)
+		froms = append(froms, r.From())
+		msgs = append(msgs, string(b))
+	}
+}
+
+func TestReaderUnquote(t *testing.T) {
+	input := "From a@example.com Thu Jan  1 00:00:00 1970\n" +
+		"Subject: one\n" +
+		"\n" +
+		">From the start\n" +
+		">>From deeper\n" +
+		"\n" +
+		"From b@example.com Fri Jan  2 00:00:00 1970\n" +
+		"Subject: two\n" +
+		"\n" +
+		"body\n"
+
+	tcases := map[mbox.Format][]string{
+		mbox.Mboxo: {
+			"Subject: one\n\nFrom the start\n>>From deeper\n",
+			"Subject: two\n\nbody\n",
+		},
+		mbox.Mboxrd: {
+			"Subject: one\n\nFrom the start\n>From deeper\n",
+			"Subject: two\n\nbody\n",
+		},
+	}
+
+	for format, want := range tcases {
+		t.Run(format.String(), func(t *testing.T) {
+			froms, msgs := readAllMessages(t, mbox.NewReader(strings.NewReader(input), format))
+			assert.Equal(t, want, msgs)
+			assert.Equal(t, []string{
+				"a@example.com Thu Jan  1 00:00:00 1970",
+				"b@example.com Fri Jan  2 00:00:00 1970",
+			}, froms)
+		})
+	}
+}
+
+func TestReaderContentLength(t *testing.T) {
+	input := "From a@example.com Thu Jan  1 00:00:00 1970\n" +
+		"Subject: one\n" +
+		"Content-Length: 23\n" +
+		"\n" +
+		"From inside\n" +
+		">From too\n" +
+		"\n" +
+		"\n" +
+		"From b@example.com Fri Jan  2 00:00:00 1970\n" +
+		"Subject: no length\n" +
+		"\n" +
+		"body\n"
+
+	_, msgs := readAllMessages(t, mbox.NewReader(strings.NewReader(input), mbox.Mboxcl2))
+	assert.Equal(t, []string{
+		"Subject: one\nContent-Length: 23\n\nFrom inside\n>From too\n\n",
+		"Subject: no length\n\nbody\n",
+	}, msgs)
+}
+
+func TestReaderContentLengthMismatch(t *testing.T) {
+	input := "From a@example.com Thu Jan  1 00:00:00 1970\n" +
+		"Content-Length: 2\n" +
+		"\n" +
+		"body\n"
+
+	_, err := mbox.NewReader(strings.NewReader(input), mbox.Mboxcl2).NextMessage()
+	assert.Error(t, err)
+}
+
+func TestReaderMissingFromLine(t *testing.T) {
+	_, err := mbox.NewReader(strings.NewReader("Subject: x\n\nbody\n"), mbox.Mboxrd).NextMessage()
+	assert.Error(t, err)
+}
+
+func TestReaderEmpty(t *testing.T) {
+	_, err := mbox.NewReader(strings.NewReader("\n\n"), mbox.Mboxrd).NextMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderNextEnvelope(t *testing.T) {
+	input := "From a@example.com Thu Jan  1 00:00:00 1970\r\n" +
+		"From: a@example.com\r\n" +
+		"Subject: hello\r\n" +
+		"\r\n" +
+		"body\r\n" +
+		"\r\n"
+
+	r := mbox.NewReader(strings.NewReader(input), mbox.Mboxrd)
+	env, err := r.NextEnvelope()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", env.GetHeader("Subject"))
+	assert.Equal(t, "body\r\n", env.Text)
+
+	_, err = r.NextEnvelope()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,146 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/pkg/errors"
+)
+
+// Writer appends messages to an mbox stream, escaping them as required by its Format.
+type Writer struct {
+	bw     *bufio.Writer
+	format Format
+}
+
+// NewWriter creates a Writer which writes format encoded messages to w.
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{
+		bw:     bufio.NewWriter(w),
+		format: format,
+	}
+}
+
+// WritePart encodes p with Part.Encode and appends it to the mbox.  sender and date are recorded
+// in the separator line; an empty sender is replaced with MAILER-DAEMON, and a zero date with the
+// current time.
+func (w *Writer) WritePart(sender string, date time.Time, p *enmime.Part) error {
+	buf := &bytes.Buffer{}
+	if err := p.Encode(buf); err != nil {
+		return err
+	}
+	return w.WriteMessage(sender, date, buf)
+}
+
+// WriteMessage reads a raw message from msg and appends it to the mbox.  See WritePart for the
+// handling of sender and date.
+func (w *Writer) WriteMessage(sender string, date time.Time, msg io.Reader) error {
+	b, err := io.ReadAll(msg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := w.bw.Write(formatFromLine(sender, date)); err != nil {
+		return err
+	}
+	if err := w.bw.WriteByte('\n'); err != nil {
+		return err
+	}
+	if w.format == Mboxcl2 {
+		err = w.writeFramed(b)
+	} else {
+		err = w.writeEscaped(b)
+	}
+	if err != nil {
+		return err
+	}
+	// Messages must end with a newline, followed by a blank separator line.
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		if err := w.bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return w.bw.WriteByte('\n')
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	return w.bw.Flush()
+}
+
+// writeEscaped writes b line by line, escaping From lines.
+func (w *Writer) writeEscaped(b []byte) error {
+	for len(b) > 0 {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+		}
+		b = b[len(line):]
+		if w.needsQuote(line) {
+			if err := w.bw.WriteByte('>'); err != nil {
+				return err
+			}
+		}
+		if _, err := w.bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFramed writes b with a Content-Length header describing its body, replacing any existing
+// Content-Length header.
+func (w *Writer) writeFramed(b []byte) error {
+	var header, body []byte
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		header, body = b[:i+2], b[i+4:]
+	} else if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		header, body = b[:i+1], b[i+2:]
+	} else {
+		header = b
+	}
+	eol := "\n"
+	if bytes.HasSuffix(header, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	for len(header) > 0 {
+		line := header
+		if i := bytes.IndexByte(header, '\n'); i >= 0 {
+			line = header[:i+1]
+		}
+		header = header[len(line):]
+		name, _, found := strings.Cut(string(line), ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), hnContentLength) {
+			continue
+		}
+		if _, err := w.bw.Write(line); err != nil {
+			return err
+		}
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' && len(body) == 0 {
+		// Header without line ending and without body.
+		if _, err := w.bw.WriteString(eol); err != nil {
+			return err
+		}
+	}
+	if _, err := w.bw.WriteString(hnContentLength + ": " + strconv.Itoa(len(body)) + eol + eol); err != nil {
+		return err
+	}
+	_, err := w.bw.Write(body)
+	return err
+}
+
+// needsQuote returns true if line must be escaped for the Writer format.
+func (w *Writer) needsQuote(line []byte) bool {
+	switch w.format {
+	case Mboxo:
+		return isFromLine(line)
+	case Mboxrd:
+		return isFromLine(line) || isQuotedFromLine(line)
+	}
+	return false
+}
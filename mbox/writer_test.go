@@ -0,0 +1,87 @@
+package mbox_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/mbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDate = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestWriterEscape(t *testing.T) {
+	msg := "Subject: x\n\nFrom here\n>From there\nbody"
+
+	tcases := map[mbox.Format]string{
+		mbox.Mboxo: "From a@example.com Thu Jan  1 00:00:00 1970\n" +
+			"Subject: x\n\n>From here\n>From there\nbody\n\n",
+		mbox.Mboxrd: "From a@example.com Thu Jan  1 00:00:00 1970\n" +
+			"Subject: x\n\n>From here\n>>From there\nbody\n\n",
+		mbox.Mboxcl2: "From a@example.com Thu Jan  1 00:00:00 1970\n" +
+			"Subject: x\nContent-Length: 26\n\nFrom here\n>From there\nbody\n\n",
+	}
+
+	for format, want := range tcases {
+		t.Run(format.String(), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := mbox.NewWriter(buf, format)
+			require.NoError(t, w.WriteMessage("a@example.com", testDate, strings.NewReader(msg)))
+			require.NoError(t, w.Flush())
+			assert.Equal(t, want, buf.String())
+		})
+	}
+}
+
+func TestWriterReplacesContentLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := mbox.NewWriter(buf, mbox.Mboxcl2)
+	err := w.WriteMessage("", testDate, strings.NewReader("Content-Length: 99\r\nSubject: x\r\n\r\nab\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	want := "From MAILER-DAEMON Thu Jan  1 00:00:00 1970\n" +
+		"Subject: x\r\nContent-Length: 4\r\n\r\nab\r\n\n"
+	assert.Equal(t, want, buf.String())
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	bodies := []string{
+		"From the start\n>From quoted\n>>From twice\n",
+		"second message\n",
+	}
+
+	for _, format := range []mbox.Format{mbox.Mboxrd, mbox.Mboxcl2} {
+		t.Run(format.String(), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := mbox.NewWriter(buf, format)
+			for _, body := range bodies {
+				p, err := enmime.Builder().
+					From("", "a@example.com").
+					To("", "b@example.com").
+					Subject("test").
+					Date(testDate).
+					Text([]byte(body)).
+					Build()
+				require.NoError(t, err)
+				require.NoError(t, w.WritePart("a@example.com", testDate, p))
+			}
+			require.NoError(t, w.Flush())
+
+			r := mbox.NewReader(buf, format)
+			for _, body := range bodies {
+				env, err := r.NextEnvelope()
+				require.NoError(t, err)
+				// Encoding canonicalizes line endings to CRLF.
+				assert.Equal(t, strings.ReplaceAll(body, "\n", "\r\n"), env.Text)
+			}
+			_, err := r.NextMessage()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
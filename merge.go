@@ -0,0 +1,293 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MergeRecipient is a recipient of a mail merge, along with the data used to personalize their
+// message.
+type MergeRecipient struct {
+	Name    string // Display name, may be empty.
+	Address string
+	Data    any // Passed to the MergeFunc, e.g. for use with TextTemplate and HTMLTemplate.
+}
+
+// MergeFunc personalizes the message for a single recipient of a mail merge.  b is a copy of the
+// template MailBuilder with its To address set to r, and no Cc or Bcc recipients.  A non-nil error
+// skips the recipient, and is reported in its MergeResult.
+//
+// MergeFunc is called concurrently for different recipients, and must not modify data shared
+// between them.
+type MergeFunc func(b MailBuilder, r MergeRecipient) (MailBuilder, error)
+
+// MergeResult reports the outcome of a mail merge for a single recipient.
+type MergeResult struct {
+	Recipient MergeRecipient
+	MessageID string      // Message-ID of the message sent, without angle brackets.
+	Result    *SendResult // Reply of the server when the Sender is a ContextSender, else nil.
+	Err       error       // Personalization, build, or send error; nil if the message was sent.
+}
+
+// SendMerge builds and sends a personalized message to each of recipients, using the MailBuilder
+// as a template, and returns a MergeResult for each recipient, in the order given.  personalize
+// may be nil, in which case every recipient receives the template message addressed to them.
+//
+// The attachments and inlines of the template are encoded once, without SMTPUTF8 so as to suit
+// any server, and shared by every message, rather than being re-encoded for each recipient.
+// Attachments added by personalize are encoded as usual.
+//
+// Messages are sent with the SendPartContext method of SMTPClient and LMTPSender, or
+// ContextSender.SendContext, when supported by sender, reporting the reply of the server for each
+// recipient.  Otherwise PartSender.SendPart or Send is used.  The reverse-path is the From
+// address of each message.  By default one message is sent at a time; see MergeConcurrency and
+// MergeRateLimit.
+//
+// Failures to personalize, build, or send a message are reported in its MergeResult, and do not
+// stop the merge.  An error is returned if the template is invalid, or when ctx is done before
+// every message has been sent, in which case the remaining results hold the error of ctx.
+func (p MailBuilder) SendMerge(
+	ctx context.Context,
+	sender Sender,
+	recipients []MergeRecipient,
+	personalize MergeFunc,
+	opts ...MergeOption,
+) ([]MergeResult, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	m := &merger{
+		template:    p,
+		sender:      sender,
+		personalize: personalize,
+		concurrency: 1,
+		shared:      make(map[*Part]*Part),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(m)
+		}
+	}
+	for _, ap := range slices.Concat(p.inlines, p.attachments) {
+		if ap.ContentReader != nil || len(ap.Content) == 0 {
+			continue
+		}
+		sp, err := encodeShared(ap)
+		if err != nil {
+			return nil, err
+		}
+		m.shared[ap] = sp
+	}
+
+	results := make([]MergeResult, len(recipients))
+	sent := make([]bool, len(recipients))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := max(1, min(m.concurrency, len(recipients)))
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = m.send(ctx, recipients[i])
+				sent[i] = true
+			}
+		}()
+	}
+
+	var timer *time.Timer
+	next := time.Now()
+feed:
+	for i := range recipients {
+		if m.interval > 0 {
+			if d := time.Until(next); d > 0 {
+				if timer == nil {
+					timer = time.NewTimer(d)
+					defer timer.Stop()
+				} else {
+					timer.Reset(d)
+				}
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					break feed
+				}
+			}
+			if now := time.Now(); now.After(next) {
+				next = now
+			}
+			next = next.Add(m.interval)
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var err error
+	for i, r := range recipients {
+		if !sent[i] {
+			err = ctx.Err()
+			results[i] = MergeResult{Recipient: r, Err: err}
+		}
+	}
+	return results, err
+}
+
+// merger holds the state of a SendMerge call.
+type merger struct {
+	template    MailBuilder
+	sender      Sender
+	personalize MergeFunc
+	concurrency int
+	interval    time.Duration   // Minimum time between the start of two sends.
+	shared      map[*Part]*Part // Pre-encoded template parts, by template part.
+}
+
+// partContextSender is implemented by Senders which encode the message for the server, with
+// cancellation and a result per recipient.
+type partContextSender interface {
+	SendPartContext(ctx context.Context, reversePath string, recipients []string, root *Part) (
+		*SendResult, error)
+}
+
+var (
+	_ partContextSender = &SMTPClient{}
+	_ partContextSender = &LMTPSender{}
+)
+
+// send personalizes, builds, and sends the message to r.
+func (m *merger) send(ctx context.Context, r MergeRecipient) MergeResult {
+	res := MergeResult{Recipient: r}
+	if res.Err = ctx.Err(); res.Err != nil {
+		return res
+	}
+	b := m.template
+	b.to = []mail.Address{{Name: r.Name, Address: r.Address}}
+	b.cc, b.bcc = nil, nil
+	b.messageID = ""
+	// Clip the slices shared with the template, so personalize appends to copies.
+	b.inlines, b.attachments = slices.Clip(b.inlines), slices.Clip(b.attachments)
+	if m.personalize != nil {
+		if b, res.Err = m.personalize(b, r); res.Err != nil {
+			return res
+		}
+	}
+	root, err := b.Build()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	m.substituteShared(root, b.inlines, b.attachments)
+	res.MessageID = strings.Trim(root.Header.Get("Message-Id"), "<>")
+
+	from, recips := b.from.Address, b.recipients()
+	switch s := m.sender.(type) {
+	case partContextSender:
+		res.Result, res.Err = s.SendPartContext(ctx, from, recips, root)
+	case ContextSender:
+		buf := &bytes.Buffer{}
+		if res.Err = root.Encode(buf); res.Err != nil {
+			return res
+		}
+		res.Result, res.Err = s.SendContext(ctx, from, recips, buf.Bytes())
+	case PartSender:
+		res.Err = s.SendPart(from, recips, root)
+		return res
+	default:
+		buf := &bytes.Buffer{}
+		if res.Err = root.Encode(buf); res.Err != nil {
+			return res
+		}
+		res.Err = s.Send(from, recips, buf.Bytes())
+		return res
+	}
+	if res.Err == nil {
+		res.Err = res.Result.Err()
+	}
+	return res
+}
+
+// substituteShared replaces the copies of the template parts made by Build with their
+// pre-encoded form.  Build adds inlines, in order, after the body under a multipart/related part,
+// and attachments likewise under the multipart/mixed root.
+func (m *merger) substituteShared(root *Part, inlines, attachments []*Part) {
+	if len(m.shared) == 0 {
+		return
+	}
+	body := root
+	if len(attachments) > 0 {
+		m.substituteChildren(root, attachments)
+		body = root.FirstChild
+	}
+	if len(inlines) > 0 && body != nil {
+		m.substituteChildren(body, inlines)
+	}
+}
+
+// substituteChildren replaces the children of parent following the first, which are copies of
+// templates, with the pre-encoded form of the template.
+func (m *merger) substituteChildren(parent *Part, templates []*Part) {
+	prev := parent.FirstChild
+	if prev == nil {
+		return
+	}
+	for _, tp := range templates {
+		c := prev.NextSibling
+		if c == nil {
+			return
+		}
+		if sp, ok := m.shared[tp]; ok {
+			// Copy the shared part, as each message links it into its own tree.
+			cp := &Part{}
+			*cp = *sp
+			cp.Parent, cp.NextSibling = parent, c.NextSibling
+			prev.NextSibling = cp
+			c = cp
+		}
+		prev = c
+	}
+}
+
+// encodeShared encodes a copy of the template part ap, and returns a Part holding its encoded
+// header and content, which is written as is when encoded.
+func encodeShared(ap *Part) (*Part, error) {
+	cp := &Part{}
+	*cp = *ap
+	cp.Header = make(textproto.MIMEHeader)
+	buf := &bytes.Buffer{}
+	if err := cp.Encode(buf); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(buf)
+	header, fields, err := readHeaderFields(r, &partErrorCollector{cp})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to read encoded part header")
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rawParser := defaultParser
+	rawParser.rawContent = true
+	cp.Header = header
+	cp.OrderedHeader = NewOrderedHeader(fields)
+	cp.Content = content
+	cp.parser = &rawParser
+	cp.encoder = nil
+	return cp, nil
+}
@@ -0,0 +1,39 @@
+package enmime
+
+import "time"
+
+// MergeOption configures a SendMerge call.
+type MergeOption interface {
+	apply(m *merger)
+}
+
+// MergeConcurrency sets the maximum number of messages personalized and sent at once, one by
+// default.  Idle SMTPClient connections are reused when SMTPMaxIdleConns is at least n.
+func MergeConcurrency(n int) MergeOption {
+	return mergeConcurrencyOption(n)
+}
+
+type mergeConcurrencyOption int
+
+func (o mergeConcurrencyOption) apply(m *merger) {
+	if o > 0 {
+		m.concurrency = int(o)
+	}
+}
+
+// MergeRateLimit limits sending to n messages per period, spaced evenly across the period.
+// Sending is not limited by default.
+func MergeRateLimit(n int, per time.Duration) MergeOption {
+	return mergeRateLimitOption{n, per}
+}
+
+type mergeRateLimitOption struct {
+	n   int
+	per time.Duration
+}
+
+func (o mergeRateLimitOption) apply(m *merger) {
+	if o.n > 0 && o.per > 0 {
+		m.interval = o.per / time.Duration(o.n)
+	}
+}
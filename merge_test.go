@@ -0,0 +1,282 @@
+package enmime_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/smtptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mergeSender is a ContextSender recording the messages it accepts, and rejecting the reject
+// recipient.
+type mergeSender struct {
+	mu       sync.Mutex
+	msgs     map[string][]byte
+	reject   string
+	delay    time.Duration
+	active   int
+	maxConns int
+	times    []time.Time
+}
+
+func (s *mergeSender) Send(reversePath string, recipients []string, msg []byte) error {
+	res, err := s.SendContext(context.Background(), reversePath, recipients, msg)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+func (s *mergeSender) SendContext(
+	ctx context.Context,
+	_ string,
+	recipients []string,
+	msg []byte,
+) (*enmime.SendResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.active++
+	s.maxConns = max(s.maxConns, s.active)
+	s.times = append(s.times, time.Now())
+	s.mu.Unlock()
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.msgs == nil {
+		s.msgs = make(map[string][]byte)
+	}
+	res := &enmime.SendResult{Code: 250, Message: "OK"}
+	for _, r := range recipients {
+		if r == s.reject {
+			res.Rejected = append(res.Rejected, enmime.RecipientResult{Recipient: r, Code: 550})
+			continue
+		}
+		res.Accepted = append(res.Accepted, enmime.RecipientResult{Recipient: r, Code: 250})
+		s.msgs[r] = msg
+	}
+	if len(res.Accepted) == 0 {
+		return nil, res.Err()
+	}
+	return res, nil
+}
+
+func mergeRecipients(addrs ...string) []enmime.MergeRecipient {
+	rs := make([]enmime.MergeRecipient, len(addrs))
+	for i, a := range addrs {
+		rs[i] = enmime.MergeRecipient{Address: a, Data: strings.Split(a, "@")[0]}
+	}
+	return rs
+}
+
+func TestSendMerge(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	client := enmime.NewSMTPClient(srv.Addr, enmime.SMTPTimeout(5*time.Second))
+	t.Cleanup(func() { _ = client.Close() })
+
+	tmpl := texttemplate.Must(texttemplate.New("").Parse("Hello {{.}}!"))
+	attachment := bytes.Repeat([]byte{0, 1, 2, 0xff}, 10000)
+	b := enmime.Builder().
+		From("Alice", "alice@example.com").
+		Subject("News").
+		CC("", "ignored@example.com").
+		AddAttachment(attachment, "application/octet-stream", "data.bin")
+	rcpts := mergeRecipients("bob@example.com", "carol@example.com")
+	results, err := b.SendMerge(context.Background(), client, rcpts,
+		func(b enmime.MailBuilder, r enmime.MergeRecipient) (enmime.MailBuilder, error) {
+			return b.TextTemplate(tmpl, r.Data), nil
+		}, enmime.MergeConcurrency(2))
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	ids := make(map[string]bool)
+	for i, res := range results {
+		assert.Equal(t, rcpts[i], res.Recipient)
+		require.NoError(t, res.Err)
+		require.NotNil(t, res.Result)
+		assert.Equal(t, 250, res.Result.Code)
+		assert.NotEmpty(t, res.MessageID)
+		ids[res.MessageID] = true
+	}
+	assert.Len(t, ids, 2, "message IDs should be unique")
+
+	msgs := srv.WaitForMessages(t, 2)
+	for _, m := range msgs {
+		m.AssertFrom(t, "alice@example.com")
+		require.Len(t, m.Recipients, 1)
+		name := strings.Split(m.Recipients[0], "@")[0]
+		m.AssertHeader(t, "To", "<"+m.Recipients[0]+">")
+		m.AssertHeader(t, "Cc", "")
+		m.AssertHeader(t, "Subject", "News")
+		m.AssertTextContains(t, "Hello "+name+"!")
+		if a := m.AssertAttachment(t, "data.bin"); a != nil {
+			assert.Equal(t, attachment, a.Content)
+		}
+	}
+}
+
+func TestSendMergeEncodesAsBuild(t *testing.T) {
+	// Messages sent with shared attachments are identical to those built individually.
+	b := enmime.Builder().
+		RandSeed(42).
+		Date(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)).
+		From("Alice", "alice@example.com").
+		Subject("Report").
+		Text([]byte("See attached.")).
+		HTML([]byte("<p>See attached.</p><img src=\"cid:logo\">")).
+		AddInline([]byte("\x89PNG fake"), "image/png", "logo.png", "logo").
+		AddAttachment([]byte("col1,col2\r\n1,2\r\n"), "text/csv", "report.csv").
+		AddAttachment(bytes.Repeat([]byte{0xfe}, 5000), "application/pdf", "report.pdf")
+
+	s := &mergeSender{}
+	results, err := b.SendMerge(context.Background(), s, mergeRecipients("bob@example.com"), nil)
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+
+	want := &bytes.Buffer{}
+	root, err := b.RandSeed(42).To("", "bob@example.com").Build()
+	require.NoError(t, err)
+	require.NoError(t, root.Encode(want))
+	assert.Equal(t, want.String(), string(s.msgs["bob@example.com"]))
+}
+
+func TestSendMergeReusedContent(t *testing.T) {
+	// Content of a template attachment reused by personalize is encoded for its own part.
+	data := []byte("shared bytes")
+	b := enmime.Builder().
+		From("", "alice@example.com").
+		Subject("Hi").
+		Text([]byte("Hi")).
+		AddAttachment(data, "application/octet-stream", "data.bin")
+	s := &mergeSender{}
+	results, err := b.SendMerge(context.Background(), s, mergeRecipients("bob@example.com"),
+		func(b enmime.MailBuilder, r enmime.MergeRecipient) (enmime.MailBuilder, error) {
+			return b.AddAttachment(data, "text/plain", "copy.txt"), nil
+		})
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+
+	e, err := enmime.ReadEnvelope(bytes.NewReader(s.msgs["bob@example.com"]))
+	require.NoError(t, err)
+	require.Len(t, e.Attachments, 2)
+	assert.Equal(t, "data.bin", e.Attachments[0].FileName)
+	assert.Equal(t, "application/octet-stream", e.Attachments[0].ContentType)
+	assert.Equal(t, "copy.txt", e.Attachments[1].FileName)
+	assert.Equal(t, "text/plain", e.Attachments[1].ContentType)
+	for _, a := range e.Attachments {
+		assert.Equal(t, data, a.Content)
+	}
+}
+
+func TestSendMergeWithoutSMTPUTF8(t *testing.T) {
+	// Messages are encoded for the extensions of the server.
+	srv := smtptest.NewServer(t, smtptest.Extensions("8BITMIME"))
+	client := enmime.NewSMTPClient(srv.Addr, enmime.SMTPTimeout(5*time.Second))
+	t.Cleanup(func() { _ = client.Close() })
+
+	b := enmime.Builder().
+		SMTPUTF8(true).
+		From("Jösé", "jose@bücher.de").
+		Subject("Grüße").
+		Text([]byte("Hallo")).
+		AddAttachment([]byte("data"), "application/octet-stream", "grüße.bin")
+	results, err := b.SendMerge(context.Background(), client, mergeRecipients("bob@example.com"), nil)
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+
+	m := srv.WaitForMessages(t, 1)[0]
+	m.AssertFrom(t, "jose@xn--bcher-kva.de")
+	head, _, _ := strings.Cut(string(m.Data), "\r\n\r\n")
+	for _, c := range head {
+		require.Less(t, c, rune(0x80), "non-ASCII header: %q", head)
+	}
+	m.AssertHeader(t, "Subject", "Grüße")
+	m.AssertAttachment(t, "grüße.bin")
+}
+
+func TestSendMergeErrors(t *testing.T) {
+	s := &mergeSender{reject: "gone@example.com"}
+	b := enmime.Builder().From("", "alice@example.com").Subject("Hi").Text([]byte("Hi"))
+	rcpts := mergeRecipients("bob@example.com", "gone@example.com", "skip@example.com")
+	errSkip := errors.New("skipped")
+	results, err := b.SendMerge(context.Background(), s, rcpts,
+		func(b enmime.MailBuilder, r enmime.MergeRecipient) (enmime.MailBuilder, error) {
+			if r.Address == "skip@example.com" {
+				return b, errSkip
+			}
+			return b, nil
+		})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	var rcptErr *enmime.RecipientError
+	require.ErrorAs(t, results[1].Err, &rcptErr)
+	assert.Equal(t, "gone@example.com", rcptErr.Rejected[0].Recipient)
+	assert.ErrorIs(t, results[2].Err, errSkip)
+	assert.Empty(t, results[2].MessageID)
+	assert.Len(t, s.msgs, 1)
+
+	// An invalid template sends nothing.
+	_, err = b.AddFileAttachment("no-such-file").
+		SendMerge(context.Background(), s, rcpts, nil)
+	assert.Error(t, err)
+}
+
+func TestSendMergeConcurrency(t *testing.T) {
+	s := &mergeSender{delay: 20 * time.Millisecond}
+	b := enmime.Builder().From("", "alice@example.com").Subject("Hi").Text([]byte("Hi"))
+	rcpts := mergeRecipients("a@example.com", "b@example.com", "c@example.com",
+		"d@example.com", "e@example.com", "f@example.com")
+	results, err := b.SendMerge(context.Background(), s, rcpts, nil, enmime.MergeConcurrency(2))
+	require.NoError(t, err)
+	for _, res := range results {
+		assert.NoError(t, res.Err)
+	}
+	assert.Len(t, s.msgs, 6)
+	assert.Equal(t, 2, s.maxConns)
+}
+
+func TestSendMergeRateLimit(t *testing.T) {
+	s := &mergeSender{}
+	b := enmime.Builder().From("", "alice@example.com").Subject("Hi").Text([]byte("Hi"))
+	rcpts := mergeRecipients("a@example.com", "b@example.com", "c@example.com")
+	_, err := b.SendMerge(context.Background(), s, rcpts, nil,
+		enmime.MergeConcurrency(3), enmime.MergeRateLimit(1, 30*time.Millisecond))
+	require.NoError(t, err)
+	require.Len(t, s.times, 3)
+	for i := 1; i < len(s.times); i++ {
+		assert.GreaterOrEqual(t, s.times[i].Sub(s.times[i-1]), 25*time.Millisecond)
+	}
+}
+
+func TestSendMergeCanceled(t *testing.T) {
+	s := &mergeSender{}
+	b := enmime.Builder().From("", "alice@example.com").Subject("Hi").Text([]byte("Hi"))
+	rcpts := mergeRecipients("a@example.com", "b@example.com", "c@example.com")
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := b.SendMerge(ctx, s, rcpts,
+		func(b enmime.MailBuilder, r enmime.MergeRecipient) (enmime.MailBuilder, error) {
+			if r.Address == "a@example.com" {
+				cancel()
+			}
+			return b, nil
+		}, enmime.MergeRateLimit(1, time.Hour))
+	assert.ErrorIs(t, err, context.Canceled)
+	require.Len(t, results, 3)
+	for i, res := range results {
+		assert.Equal(t, rcpts[i], res.Recipient)
+		assert.ErrorIs(t, res.Err, context.Canceled)
+	}
+	assert.Empty(t, s.msgs)
+}
@@ -124,3 +124,16 @@ func (o minCharsetDetectRunesOption) apply(p *Parser) {
 func MinCharsetDetectRunes(minCharsetDetectRunes int) Option {
 	return minCharsetDetectRunesOption(minCharsetDetectRunes)
 }
+
+type decodeFlowedTextOption bool
+
+func (o decodeFlowedTextOption) apply(p *Parser) {
+	p.decodeFlowedText = bool(o)
+}
+
+// DecodeFlowedText sets the decodeFlowedText option. When true, text/plain parts with the RFC 3676
+// format=flowed parameter are reflowed into paragraphs when populating Envelope.Text, honoring
+// delsp=yes and removing space-stuffing.  Part.Content is not modified.
+func DecodeFlowedText(decodeFlowedText bool) Option {
+	return decodeFlowedTextOption(decodeFlowedText)
+}
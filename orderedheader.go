@@ -0,0 +1,187 @@
+package enmime
+
+import (
+	"io"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// HeaderField is a single header field, as it appeared in a parsed message.
+type HeaderField struct {
+	// Name is the field name, with its original case.
+	Name string `json:"name"`
+	// Value is the unfolded value, with surrounding whitespace removed.
+	Value string `json:"value"`
+	// Raw holds the original bytes of the field, including folding and line endings.
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// OrderedHeader holds the header fields of a parsed Part in their original order, including
+// duplicates, original name case, and raw bytes.  It is a read-only record of the header as
+// parsed; Part.Header remains the map view used to modify headers.
+//
+// When a Part is encoded, fields are written in their original order.  Fields whose values are
+// unchanged in Part.Header are written with their raw bytes where possible, changed fields are
+// re-encoded in place, and fields added to Part.Header are written after them.
+type OrderedHeader struct {
+	fields []HeaderField
+}
+
+// NewOrderedHeader creates an OrderedHeader holding a copy of fields.
+func NewOrderedHeader(fields []HeaderField) *OrderedHeader {
+	return &OrderedHeader{fields: append([]HeaderField(nil), fields...)}
+}
+
+// Len returns the number of header fields.
+func (h *OrderedHeader) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// Fields returns a copy of the header fields in their original order.
+func (h *OrderedHeader) Fields() []HeaderField {
+	if h == nil {
+		return nil
+	}
+	return append([]HeaderField(nil), h.fields...)
+}
+
+// Get returns the value of the first field with the specified name, compared case-insensitively,
+// or "" if there is none.
+func (h *OrderedHeader) Get(name string) string {
+	if h == nil {
+		return ""
+	}
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns the values of all fields with the specified name, compared case-insensitively,
+// in their original order.
+func (h *OrderedHeader) Values(name string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// MIMEHeader returns the map view of the header, keyed by canonical field name.
+func (h *OrderedHeader) MIMEHeader() textproto.MIMEHeader {
+	m := make(textproto.MIMEHeader)
+	if h == nil {
+		return m
+	}
+	for _, f := range h.fields {
+		m.Add(f.Name, f.Value)
+	}
+	return m
+}
+
+// WriteTo writes the raw bytes of the header fields to w in their original order, as required
+// to verify DKIM signatures.  Fields without raw bytes are written as "Name: Value" lines.
+func (h *OrderedHeader) WriteTo(w io.Writer) (int64, error) {
+	if h == nil {
+		return 0, nil
+	}
+	var total int64
+	for _, f := range h.fields {
+		raw := f.Raw
+		if raw == nil {
+			raw = []byte(f.Name + ": " + f.Value + "\r\n")
+		}
+		n, err := w.Write(raw)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// encodeOrderedHeader writes the header fields of p in the order of p.OrderedHeader, reconciled
+// with the current contents of p.Header.
+func (p *Part) encodeOrderedHeader(w io.StringWriter) error {
+	// Index the map view by lowercase name, as parsed and canonical keys may differ.
+	keys := make(map[string]string, len(p.Header))
+	for k := range p.Header {
+		keys[strings.ToLower(k)] = k
+	}
+	rawContent := p.parser != nil && p.parser.rawContent
+	smtpUTF8 := false
+	if enc := p.activeEncoder(); enc != nil {
+		smtpUTF8 = enc.smtpUTF8
+	}
+
+	written := make(map[string]bool)
+	for _, f := range p.OrderedHeader.fields {
+		name := strings.ToLower(f.Name)
+		key, ok := keys[name]
+		if !ok || written[name] {
+			// Deleted, or already written in full.
+			continue
+		}
+		values := p.Header[key]
+		if !slices.Equal(values, p.OrderedHeader.Values(f.Name)) {
+			// Changed; write the current values in place of the first field.
+			for _, v := range values {
+				s, err := p.encodeHeaderField(f.Name, v)
+				if err != nil {
+					return err
+				}
+				if _, err := w.WriteString(s); err != nil {
+					return err
+				}
+			}
+			written[name] = true
+			continue
+		}
+		s := ""
+		if f.Raw != nil && (rawContent || smtpUTF8 || !has8BitBytes(f.Raw)) {
+			raw, _ := canonicalLineEndings(f.Raw)
+			s = strings.TrimSuffix(string(raw), "\r\n") + "\r\n"
+		} else {
+			var err error
+			if s, err = p.encodeHeaderField(f.Name, f.Value); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteString(s); err != nil {
+			return err
+		}
+	}
+
+	// Fields added since parsing.
+	var added []string
+	for k := range p.Header {
+		if len(p.OrderedHeader.Values(k)) == 0 {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+	for _, k := range added {
+		for _, v := range p.Header[k] {
+			s, err := p.encodeHeaderField(k, v)
+			if err != nil {
+				return err
+			}
+			if _, err := w.WriteString(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
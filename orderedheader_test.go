@@ -0,0 +1,88 @@
+package enmime_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const orderedMessage = "Received: from b.example.com by c.example.com;\r\n" +
+	"\tMon, 1 Jan 2024 10:00:02 +0000\r\n" +
+	"Received: from a.example.com by b.example.com; Mon, 1 Jan 2024 10:00:01 +0000\r\n" +
+	"Message-Id: <1234@a.example.com>\r\n" +
+	"Subject: A folded\r\n  subject\r\n" +
+	"From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"X-Trace: one\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Body\r\n"
+
+func TestOrderedHeader(t *testing.T) {
+	env, err := enmime.ReadEnvelope(strings.NewReader(orderedMessage))
+	require.NoError(t, err)
+	h := env.OrderedHeader()
+	require.NotNil(t, h)
+	require.Equal(t, 8, h.Len())
+
+	var names []string
+	for _, f := range h.Fields() {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"Received", "Received", "Message-Id", "Subject", "From", "To", "X-Trace",
+		"Content-Type"}, names)
+
+	assert.Equal(t, []string{
+		"from b.example.com by c.example.com; Mon, 1 Jan 2024 10:00:02 +0000",
+		"from a.example.com by b.example.com; Mon, 1 Jan 2024 10:00:01 +0000",
+	}, h.Values("RECEIVED"))
+	assert.Equal(t, "<1234@a.example.com>", h.Get("message-id"))
+	assert.Equal(t, "A folded subject", h.Get("Subject"))
+	assert.Equal(t, "Subject: A folded\r\n  subject\r\n", string(h.Fields()[3].Raw))
+	assert.Equal(t, env.Root.Header, h.MIMEHeader())
+
+	buf := &bytes.Buffer{}
+	_, err = h.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, orderedMessage[:strings.Index(orderedMessage, "\r\n\r\n")+2], buf.String())
+
+	assert.Nil(t, (&enmime.Envelope{}).OrderedHeader())
+	assert.Nil(t, enmime.NewPart("text/plain").OrderedHeader)
+}
+
+func TestOrderedHeaderEncode(t *testing.T) {
+	env, err := enmime.ReadEnvelope(strings.NewReader(orderedMessage))
+	require.NoError(t, err)
+	root := env.Root
+	root.Header.Set("Subject", "Changed")
+	root.Header.Del("X-Trace")
+	root.Header.Add("X-Added", "yes")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, root.Encode(buf))
+	head, _, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.Equal(t, "Received: from b.example.com by c.example.com;\r\n"+
+		"\tMon, 1 Jan 2024 10:00:02 +0000\r\n"+
+		"Received: from a.example.com by b.example.com; Mon, 1 Jan 2024 10:00:01 +0000\r\n"+
+		"Message-Id: <1234@a.example.com>\r\n"+
+		"Subject: Changed\r\n"+
+		"From: alice@example.com\r\n"+
+		"To: bob@example.com\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"X-Added: yes", head)
+}
+
+func TestOrderedHeaderBareLF(t *testing.T) {
+	msg := strings.ReplaceAll(orderedMessage, "\r\n", "\n")
+	env, err := enmime.ReadEnvelope(strings.NewReader(msg))
+	require.NoError(t, err)
+	assert.Equal(t, "Subject: A folded\n  subject\n", string(env.OrderedHeader().Fields()[3].Raw))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, env.Root.Encode(buf))
+	assert.Contains(t, buf.String(), "Subject: A folded\r\n  subject\r\n")
+}
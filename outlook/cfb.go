@@ -0,0 +1,282 @@
+package outlook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// Compound File Binary format constants, see [MS-CFB].
+const (
+	cfbHeaderSize   = 512
+	cfbDirEntrySize = 128
+	cfbHeaderDIFAT  = 109
+
+	secDIFAT      = 0xFFFFFFFC
+	secEndOfChain = 0xFFFFFFFE
+	noStream      = 0xFFFFFFFF
+
+	objStorage = 1
+	objStream  = 2
+	objRoot    = 5
+)
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// dirEntry is a storage or stream within a compound file.
+type dirEntry struct {
+	name     string
+	objType  byte
+	leftID   uint32
+	rightID  uint32
+	childID  uint32
+	start    uint32
+	size     uint64
+	children []*dirEntry
+}
+
+// compoundFile provides read access to the storages and streams of a compound file held in
+// memory.
+type compoundFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     []byte
+	entries        []*dirEntry
+	read           int // Bytes read by readChain, limited to maxRead.
+}
+
+// maxRead returns the limit on the bytes read from sector chains.  Regular chains, and mini
+// chains within the mini stream, do not overlap in a valid file, so at most twice its size is
+// read.
+func (cf *compoundFile) maxRead() int {
+	return 2 * len(cf.data)
+}
+
+// parseCompoundFile validates the header of data and loads its allocation tables and directory.
+func parseCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < cfbHeaderSize || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, errors.New("not a compound file: bad signature")
+	}
+	le := binary.LittleEndian
+	sectorShift := le.Uint16(data[0x1E:])
+	miniShift := le.Uint16(data[0x20:])
+	if sectorShift != 9 && sectorShift != 12 {
+		return nil, errors.Errorf("compound file has invalid sector shift %d", sectorShift)
+	}
+	if miniShift != 6 {
+		return nil, errors.Errorf("compound file has invalid mini sector shift %d", miniShift)
+	}
+	cf := &compoundFile{
+		data:           data,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniShift,
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+
+	// Collect FAT sector locations from the header and the DIFAT chain.
+	numFAT := int(le.Uint32(data[0x2C:]))
+	if numFAT > len(data)/cf.sectorSize {
+		return nil, errors.Errorf("compound file has invalid FAT sector count %d", numFAT)
+	}
+	var fatSectors []uint32
+	for i := 0; i < cfbHeaderDIFAT && len(fatSectors) < numFAT; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4C+4*i:]))
+	}
+	difat := le.Uint32(data[0x44:])
+	perDIFAT := cf.sectorSize/4 - 1
+	for seen := 0; difat < secDIFAT && len(fatSectors) < numFAT; seen++ {
+		if seen > numFAT {
+			return nil, errors.New("compound file DIFAT chain contains a loop")
+		}
+		sec, err := cf.sector(difat)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perDIFAT && len(fatSectors) < numFAT; i++ {
+			fatSectors = append(fatSectors, le.Uint32(sec[4*i:]))
+		}
+		difat = le.Uint32(sec[4*perDIFAT:])
+	}
+	for _, s := range fatSectors {
+		sec, err := cf.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(sec); i += 4 {
+			cf.fat = append(cf.fat, le.Uint32(sec[i:]))
+		}
+	}
+
+	// Load the directory.
+	dir, err := cf.readChain(le.Uint32(data[0x30:]), cf.fat, cf.sector)
+	if err != nil {
+		return nil, errors.WithMessage(err, "reading directory")
+	}
+	for i := 0; i+cfbDirEntrySize <= len(dir); i += cfbDirEntrySize {
+		cf.entries = append(cf.entries, parseDirEntry(dir[i:i+cfbDirEntrySize]))
+	}
+	if len(cf.entries) == 0 || cf.entries[0].objType != objRoot {
+		return nil, errors.New("compound file is missing root entry")
+	}
+
+	// Load the mini FAT and mini stream.
+	if start := le.Uint32(data[0x3C:]); start < secDIFAT {
+		b, err := cf.readChain(start, cf.fat, cf.sector)
+		if err != nil {
+			return nil, errors.WithMessage(err, "reading mini FAT")
+		}
+		for i := 0; i+4 <= len(b); i += 4 {
+			cf.miniFAT = append(cf.miniFAT, le.Uint32(b[i:]))
+		}
+	}
+	root := cf.entries[0]
+	if root.start < secDIFAT {
+		b, err := cf.readChain(root.start, cf.fat, cf.sector)
+		if err != nil {
+			return nil, errors.WithMessage(err, "reading mini stream")
+		}
+		cf.miniStream = b
+	}
+
+	if err := cf.buildTree(root, make(map[uint32]bool)); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// parseDirEntry decodes a single 128 byte directory entry.
+func parseDirEntry(b []byte) *dirEntry {
+	le := binary.LittleEndian
+	nameLen := min(int(le.Uint16(b[0x40:])), 64)
+	units := make([]uint16, 0, nameLen/2)
+	for i := 0; i+1 < nameLen; i += 2 {
+		if u := le.Uint16(b[i:]); u != 0 {
+			units = append(units, u)
+		}
+	}
+	return &dirEntry{
+		name:    string(utf16.Decode(units)),
+		objType: b[0x42],
+		leftID:  le.Uint32(b[0x44:]),
+		rightID: le.Uint32(b[0x48:]),
+		childID: le.Uint32(b[0x4C:]),
+		start:   le.Uint32(b[0x74:]),
+		size:    le.Uint64(b[0x78:]),
+	}
+}
+
+// buildTree populates the children of storage e by walking its red-black tree of siblings.
+func (cf *compoundFile) buildTree(e *dirEntry, visited map[uint32]bool) error {
+	var walk func(id uint32) error
+	walk = func(id uint32) error {
+		if id == noStream {
+			return nil
+		}
+		if int(id) >= len(cf.entries) || visited[id] {
+			return errors.Errorf("compound file directory entry %d is invalid", id)
+		}
+		visited[id] = true
+		c := cf.entries[id]
+		if err := walk(c.leftID); err != nil {
+			return err
+		}
+		e.children = append(e.children, c)
+		if c.objType == objStorage {
+			if err := cf.buildTree(c, visited); err != nil {
+				return err
+			}
+		}
+		return walk(c.rightID)
+	}
+	return walk(e.childID)
+}
+
+// sector returns the content of regular sector n.
+func (cf *compoundFile) sector(n uint32) ([]byte, error) {
+	off := (int64(n) + 1) * int64(cf.sectorSize)
+	if n >= secDIFAT || off+int64(cf.sectorSize) > int64(len(cf.data)) {
+		return nil, errors.Errorf("compound file sector %d out of range", n)
+	}
+	return cf.data[off : off+int64(cf.sectorSize)], nil
+}
+
+// miniSector returns the content of mini sector n.
+func (cf *compoundFile) miniSector(n uint32) ([]byte, error) {
+	off := int64(n) * int64(cf.miniSectorSize)
+	if n >= secDIFAT || off+int64(cf.miniSectorSize) > int64(len(cf.miniStream)) {
+		return nil, errors.Errorf("compound file mini sector %d out of range", n)
+	}
+	return cf.miniStream[off : off+int64(cf.miniSectorSize)], nil
+}
+
+// readChain concatenates the sectors of the chain beginning at start.
+func (cf *compoundFile) readChain(
+	start uint32,
+	table []uint32,
+	sector func(uint32) ([]byte, error),
+) ([]byte, error) {
+	var buf []byte
+	visited := make(map[uint32]bool)
+	for n := start; n != secEndOfChain; {
+		if visited[n] {
+			return nil, errors.New("compound file sector chain contains a loop")
+		}
+		visited[n] = true
+		sec, err := sector(n)
+		if err != nil {
+			return nil, err
+		}
+		if cf.read += len(sec); cf.read > cf.maxRead() {
+			return nil, errors.New("compound file sector chains exceed the file size")
+		}
+		buf = append(buf, sec...)
+		if int(n) >= len(table) {
+			return nil, errors.Errorf("compound file sector %d missing from allocation table", n)
+		}
+		n = table[n]
+	}
+	return buf, nil
+}
+
+// stream returns the content of stream e.
+func (cf *compoundFile) stream(e *dirEntry) ([]byte, error) {
+	size := e.size
+	if cf.sectorSize == 512 {
+		// Version 3 files may have garbage in the high 32 bits.
+		size &= 0xFFFFFFFF
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+	var b []byte
+	var err error
+	if size < cf.miniCutoff {
+		b, err = cf.readChain(e.start, cf.miniFAT, cf.miniSector)
+	} else {
+		b, err = cf.readChain(e.start, cf.fat, cf.sector)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "reading stream %q", e.name)
+	}
+	if uint64(len(b)) < size {
+		return nil, errors.Errorf("compound file stream %q is truncated", e.name)
+	}
+	return b[:size], nil
+}
+
+// child returns the named child of storage e, or nil.  Names are compared case-insensitively.
+func (e *dirEntry) child(name string) *dirEntry {
+	for _, c := range e.children {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package outlook
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// cfbNode describes a storage (when children is non-nil or storage is set) or a stream, used to
+// generate compound files for tests.
+type cfbNode struct {
+	name     string
+	storage  bool
+	data     []byte
+	children []*cfbNode
+}
+
+// buildCFB serializes a version 3 compound file with root as its root storage.  Streams smaller
+// than the cutoff are placed in the mini stream, as required by [MS-CFB].
+func buildCFB(root *cfbNode) []byte {
+	const (
+		sectorSize = 512
+		miniSize   = 64
+		cutoff     = 4096
+	)
+	le := binary.LittleEndian
+
+	// Flatten the tree, assigning directory IDs.  Siblings are linked through right pointers.
+	type entry struct {
+		node               *cfbNode
+		objType            byte
+		left, right, child uint32
+		start              uint32
+		size               uint64
+	}
+	entries := []*entry{{node: root, objType: objRoot, left: noStream, right: noStream, child: noStream}}
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if e.objType == objStream {
+			continue
+		}
+		for j, c := range e.node.children {
+			id := uint32(len(entries))
+			if j == 0 {
+				e.child = id
+			} else {
+				entries[id-1].right = id
+			}
+			typ := byte(objStream)
+			if c.storage || c.children != nil {
+				typ = objStorage
+			}
+			entries = append(entries, &entry{
+				node: c, objType: typ, left: noStream, right: noStream, child: noStream,
+				start: secEndOfChain, size: uint64(len(c.data)),
+			})
+		}
+	}
+
+	// Lay out the mini stream.
+	var miniStream []byte
+	var miniFAT []uint32
+	var bigStreams []*entry
+	for _, e := range entries {
+		if e.objType != objStream || e.size == 0 {
+			continue
+		}
+		if e.size >= cutoff {
+			bigStreams = append(bigStreams, e)
+			continue
+		}
+		e.start = uint32(len(miniFAT))
+		n := (len(e.node.data) + miniSize - 1) / miniSize
+		for k := range n {
+			next := uint32(secEndOfChain)
+			if k < n-1 {
+				next = e.start + uint32(k) + 1
+			}
+			miniFAT = append(miniFAT, next)
+		}
+		padded := make([]byte, n*miniSize)
+		copy(padded, e.node.data)
+		miniStream = append(miniStream, padded...)
+	}
+
+	// Allocate regular sectors: directory, mini FAT, mini stream, large streams, then FAT.
+	sectorsFor := func(n int) int { return (n + sectorSize - 1) / sectorSize }
+	var fat []uint32
+	var body []byte
+	alloc := func(data []byte) uint32 {
+		n := sectorsFor(len(data))
+		if n == 0 {
+			return secEndOfChain
+		}
+		start := uint32(len(fat))
+		for k := range n {
+			next := uint32(secEndOfChain)
+			if k < n-1 {
+				next = start + uint32(k) + 1
+			}
+			fat = append(fat, next)
+		}
+		padded := make([]byte, n*sectorSize)
+		copy(padded, data)
+		body = append(body, padded...)
+		return start
+	}
+
+	entries[0].size = uint64(len(miniStream))
+	// Directory content depends on start sectors, reserve its space first.
+	dirSectors := sectorsFor(len(entries) * cfbDirEntrySize)
+	dirStart := alloc(make([]byte, dirSectors*sectorSize))
+	miniFATBytes := make([]byte, 4*len(miniFAT))
+	for i, v := range miniFAT {
+		le.PutUint32(miniFATBytes[4*i:], v)
+	}
+	miniFATStart := alloc(miniFATBytes)
+	entries[0].start = alloc(miniStream)
+	for _, e := range bigStreams {
+		e.start = alloc(e.node.data)
+	}
+
+	// Write the directory into its reserved sectors.
+	for i, e := range entries {
+		b := body[int(dirStart)*sectorSize+i*cfbDirEntrySize:]
+		name := utf16.Encode([]rune(e.node.name))
+		for k, u := range name {
+			le.PutUint16(b[2*k:], u)
+		}
+		le.PutUint16(b[0x40:], uint16(2*(len(name)+1)))
+		b[0x42] = e.objType
+		b[0x43] = 1 // Black.
+		le.PutUint32(b[0x44:], e.left)
+		le.PutUint32(b[0x48:], e.right)
+		le.PutUint32(b[0x4C:], e.child)
+		le.PutUint32(b[0x74:], e.start)
+		le.PutUint64(b[0x78:], e.size)
+	}
+	for i := len(entries); i < dirSectors*sectorSize/cfbDirEntrySize; i++ {
+		b := body[int(dirStart)*sectorSize+i*cfbDirEntrySize:]
+		le.PutUint32(b[0x44:], noStream)
+		le.PutUint32(b[0x48:], noStream)
+		le.PutUint32(b[0x4C:], noStream)
+	}
+
+	// Append FAT sectors, which must also describe themselves.
+	numFAT := 0
+	for numFAT*sectorSize/4 < len(fat)+numFAT {
+		numFAT++
+	}
+	fatStart := uint32(len(fat))
+	for range numFAT {
+		fat = append(fat, 0xFFFFFFFD)
+	}
+	fatBytes := make([]byte, numFAT*sectorSize)
+	for i := range fatBytes {
+		fatBytes[i] = 0xFF
+	}
+	for i, v := range fat {
+		le.PutUint32(fatBytes[4*i:], v)
+	}
+	body = append(body, fatBytes...)
+
+	header := make([]byte, cfbHeaderSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], uint32(numFAT))
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x38:], cutoff)
+	le.PutUint32(header[0x3C:], miniFATStart)
+	le.PutUint32(header[0x40:], uint32(sectorsFor(len(miniFATBytes))))
+	le.PutUint32(header[0x44:], secEndOfChain)
+	for i := range cfbHeaderDIFAT {
+		v := uint32(0xFFFFFFFF)
+		if i < numFAT {
+			v = fatStart + uint32(i)
+		}
+		le.PutUint32(header[0x4C+4*i:], v)
+	}
+	return append(header, body...)
+}
+
+// testProp is a MAPI property for generated .msg files.  value may be a string (PtypString),
+// []byte (PtypBinary), int32 (PtypInteger32), bool (PtypBoolean) or time.Time (PtypTime).
+type testProp struct {
+	id    uint16
+	value any
+}
+
+// msgStorage builds the property stream and substorage streams for a storage, followed by any
+// extra child storages.
+func msgStorage(name string, headerSize int, props []testProp, extra ...*cfbNode) *cfbNode {
+	le := binary.LittleEndian
+	node := &cfbNode{name: name, storage: true}
+	stream := make([]byte, headerSize)
+	for _, p := range props {
+		entry := make([]byte, propEntrySize)
+		var typ uint16
+		var data []byte
+		switch v := p.value.(type) {
+		case string:
+			typ = ptUnicode
+			for _, u := range utf16.Encode([]rune(v + "\x00")) {
+				data = le.AppendUint16(data, u)
+			}
+		case []byte:
+			typ, data = ptBinary, v
+		case int32:
+			typ = ptInt32
+			le.PutUint32(entry[8:], uint32(v))
+		case bool:
+			typ = ptBoolean
+			if v {
+				entry[8] = 1
+			}
+		case time.Time:
+			typ = ptSysTime
+			le.PutUint64(entry[8:], uint64(v.Unix()+11644473600)*10000000)
+		default:
+			panic(fmt.Sprintf("unsupported test property type %T", v))
+		}
+		le.PutUint16(entry[0:], typ)
+		le.PutUint16(entry[2:], p.id)
+		le.PutUint32(entry[4:], 6)
+		if data != nil {
+			le.PutUint32(entry[8:], uint32(len(data)))
+			node.children = append(node.children, &cfbNode{
+				name: fmt.Sprintf("%s%04X%04X", prefixSubstorage, p.id, typ),
+				data: data,
+			})
+		}
+		stream = append(stream, entry...)
+	}
+	node.children = append(node.children, &cfbNode{name: nameProperties, data: stream})
+	node.children = append(node.children, extra...)
+	return node
+}
@@ -0,0 +1,349 @@
+// Package outlook converts Outlook .msg files into enmime Part trees and Envelopes.  A .msg file
+// is a Compound File Binary container (also known as OLE2) holding MAPI properties, see:
+// https://learn.microsoft.com/en-us/openspecs/exchange_server_protocols/ms-oxmsg
+//
+// The subject, sender, recipients, dates, message IDs, bodies (plain text, HTML and RTF),
+// attachments and embedded messages are converted into an equivalent MIME message.  Internet
+// headers recorded by the transport, such as Received, are preserved when available.
+package outlook
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// attachMethodEmbedded indicates an attachment containing another message.
+	attachMethodEmbedded = 5
+	// maxEmbedDepth limits the nesting of embedded messages.
+	maxEmbedDepth = 16
+
+	ctAppOctetStream   = "application/octet-stream"
+	ctMessageRFC822    = "message/rfc822"
+	ctMultipartAltern  = "multipart/alternative"
+	ctMultipartMixed   = "multipart/mixed"
+	ctMultipartRelated = "multipart/related"
+	ctTextHTML         = "text/html"
+	ctTextPlain        = "text/plain"
+	ctTextRTF          = "text/rtf"
+
+	utf8 = "utf-8"
+)
+
+// errNotMessage is returned when a compound file does not contain message properties.
+var errNotMessage = errors.New("compound file is not an Outlook message")
+
+// Recipient types, see PidTagRecipientType.
+const (
+	recipTo  = 1
+	recipCc  = 2
+	recipBcc = 3
+)
+
+// ReadEnvelope reads an Outlook .msg file and converts it into an Envelope.
+func ReadEnvelope(r io.Reader) (*enmime.Envelope, error) {
+	b, err := convert(r)
+	if err != nil {
+		return nil, err
+	}
+	return enmime.ReadEnvelope(bytes.NewReader(b))
+}
+
+// ReadParts reads an Outlook .msg file and converts it into a tree of Parts.
+func ReadParts(r io.Reader) (*enmime.Part, error) {
+	b, err := convert(r)
+	if err != nil {
+		return nil, err
+	}
+	return enmime.ReadParts(bytes.NewReader(b))
+}
+
+// convert reads a .msg file and returns the equivalent MIME encoded message.
+func convert(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cf, err := parseCompoundFile(data)
+	if err != nil {
+		return nil, err
+	}
+	root, err := buildMessage(cf, cf.entries[0], propHeaderTopLevel, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// buildMessage converts the message stored in storage into a Part tree.
+func buildMessage(cf *compoundFile, storage *dirEntry, headerSize, depth int) (*enmime.Part, error) {
+	if depth > maxEmbedDepth {
+		return nil, errors.New("embedded messages nested too deeply")
+	}
+	props, err := readProperties(cf, storage, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(props.values) == 0 {
+		return nil, errNotMessage
+	}
+	header, err := buildHeader(cf, storage, props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bodies.
+	text := props.string(pidBody)
+	html := props.string(pidHTML)
+	var rtf []byte
+	if html == "" {
+		if b := props.binary(pidRTFCompressed); b != nil {
+			// An undecodable RTF body is dropped, plain text is usually also present.
+			rtf, _ = decompressRTF(b)
+		}
+	}
+	var bodies []*enmime.Part
+	if text != "" || html == "" {
+		bodies = append(bodies, textPart(ctTextPlain, text))
+	}
+	if html != "" {
+		bodies = append(bodies, textPart(ctTextHTML, html))
+	}
+	if rtf != nil {
+		bodies = append(bodies, textPart(ctTextRTF, string(rtf)))
+	}
+	root := bodies[0]
+	if len(bodies) > 1 {
+		root = enmime.NewPart(ctMultipartAltern)
+		for _, p := range bodies {
+			root.AddChild(p)
+		}
+	}
+
+	// Attachments.
+	var inlines, attachments []*enmime.Part
+	for _, e := range storage.children {
+		if e.objType != objStorage || !strings.HasPrefix(e.name, prefixAttachment) {
+			continue
+		}
+		p, inline, err := buildAttachment(cf, e, html, depth)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p == nil:
+			// Unsupported attachment method, such as OLE objects.
+		case inline:
+			inlines = append(inlines, p)
+		default:
+			attachments = append(attachments, p)
+		}
+	}
+	if len(inlines) > 0 {
+		p := root
+		root = enmime.NewPart(ctMultipartRelated)
+		root.AddChild(p)
+		for _, p := range inlines {
+			root.AddChild(p)
+		}
+	}
+	if len(attachments) > 0 {
+		p := root
+		root = enmime.NewPart(ctMultipartMixed)
+		root.AddChild(p)
+		for _, p := range attachments {
+			root.AddChild(p)
+		}
+	}
+
+	root.Header = header
+	root.Header.Set("MIME-Version", "1.0")
+	return root, nil
+}
+
+// buildHeader builds the top-level message header from the transport headers and MAPI
+// properties.  Transport headers take precedence, as they were recorded as sent.
+func buildHeader(cf *compoundFile, storage *dirEntry, props *properties) (textproto.MIMEHeader, error) {
+	header := make(textproto.MIMEHeader)
+	if raw := props.string(pidTransportMessageHeaders); raw != "" {
+		r := bufio.NewReader(strings.NewReader(strings.TrimRight(raw, "\r\n") + "\r\n\r\n"))
+		h, err := enmime.ReadHeader(r, discardErrors{})
+		if err == nil {
+			for k, v := range h {
+				lk := strings.ToLower(k)
+				if strings.HasPrefix(lk, "content-") || lk == "mime-version" {
+					// Regenerated during encoding.
+					continue
+				}
+				header[k] = v
+			}
+		}
+	}
+	setDefault := func(k, v string) {
+		if v != "" && header.Get(k) == "" {
+			header.Set(k, v)
+		}
+	}
+
+	from := mail.Address{
+		Name:    props.string(pidSentRepresentingName),
+		Address: props.string(pidSentRepresentingSMTP),
+	}
+	if from.Address == "" {
+		from.Address = props.string(pidSentRepresentingEmail)
+	}
+	if from.Name == "" && from.Address == "" {
+		from.Name = props.string(pidSenderName)
+		from.Address = props.string(pidSenderSMTPAddress)
+		if from.Address == "" {
+			from.Address = props.string(pidSenderEmail)
+		}
+	}
+	if isSMTPAddress(from.Address) {
+		setDefault("From", from.String())
+	}
+
+	var to, cc, bcc []string
+	for _, e := range storage.children {
+		if e.objType != objStorage || !strings.HasPrefix(e.name, prefixRecipient) {
+			continue
+		}
+		rprops, err := readProperties(cf, e, propHeaderOther)
+		if err != nil {
+			return nil, err
+		}
+		addr := rprops.string(pidSMTPAddress)
+		if addr == "" {
+			addr = rprops.string(pidEmailAddress)
+		}
+		if !isSMTPAddress(addr) {
+			continue
+		}
+		a := (&mail.Address{Name: rprops.string(pidDisplayName), Address: addr}).String()
+		switch rprops.int32(pidRecipientType) {
+		case recipCc:
+			cc = append(cc, a)
+		case recipBcc:
+			bcc = append(bcc, a)
+		default:
+			to = append(to, a)
+		}
+	}
+	setDefault("To", strings.Join(to, ", "))
+	setDefault("Cc", strings.Join(cc, ", "))
+	setDefault("Bcc", strings.Join(bcc, ", "))
+
+	setDefault("Subject", props.string(pidSubject))
+	date := props.time(pidClientSubmitTime)
+	if date.IsZero() {
+		date = props.time(pidMessageDeliveryTime)
+	}
+	if !date.IsZero() {
+		setDefault("Date", date.Format(time.RFC1123Z))
+	}
+	setDefault("Message-Id", props.string(pidInternetMessageID))
+	setDefault("In-Reply-To", props.string(pidInReplyTo))
+	setDefault("References", props.string(pidReferences))
+	return header, nil
+}
+
+// buildAttachment converts an attachment storage into a Part.  Returns a nil Part for
+// attachments without convertible content.
+func buildAttachment(
+	cf *compoundFile,
+	storage *dirEntry,
+	html string,
+	depth int,
+) (part *enmime.Part, inline bool, err error) {
+	props, err := readProperties(cf, storage, propHeaderOther)
+	if err != nil {
+		return nil, false, err
+	}
+	name := props.string(pidAttachLongFilename)
+	if name == "" {
+		name = props.string(pidAttachFilename)
+	}
+	if name == "" {
+		name = props.string(pidDisplayName)
+	}
+
+	if props.int32(pidAttachMethod) == attachMethodEmbedded {
+		sub := props.substorage(pidAttachData)
+		if sub == nil {
+			return nil, false, nil
+		}
+		msg, err := buildMessage(cf, sub, propHeaderEmbedded, depth+1)
+		if err != nil {
+			return nil, false, errors.WithMessagef(err, "embedded message %q", name)
+		}
+		buf := &bytes.Buffer{}
+		if err := msg.Encode(buf); err != nil {
+			return nil, false, err
+		}
+		if filepath.Ext(name) == "" {
+			name += ".eml"
+		}
+		part = enmime.NewPart(ctMessageRFC822)
+		part.Content = buf.Bytes()
+		part.FileName = name
+		part.Disposition = "attachment"
+		return part, false, nil
+	}
+
+	data := props.binary(pidAttachData)
+	if data == nil {
+		return nil, false, nil
+	}
+	ctype := props.string(pidAttachMIMETag)
+	if ctype == "" {
+		ctype = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if ctype == "" {
+		ctype = ctAppOctetStream
+	}
+	part = enmime.NewPart(ctype)
+	part.Content = data
+	part.FileName = name
+	part.ContentID = props.string(pidAttachContentID)
+	inline = part.ContentID != "" &&
+		(props.bool(pidAttachmentHidden) || strings.Contains(html, "cid:"+part.ContentID))
+	if inline {
+		part.Disposition = "inline"
+	} else {
+		part.Disposition = "attachment"
+	}
+	return part, inline, nil
+}
+
+// textPart creates a UTF-8 text part.
+func textPart(contentType, content string) *enmime.Part {
+	p := enmime.NewPart(contentType)
+	p.Content = []byte(content)
+	p.Charset = utf8
+	return p
+}
+
+// isSMTPAddress rejects Exchange X.500 distinguished names, which are not deliverable.
+func isSMTPAddress(addr string) bool {
+	return strings.Contains(addr, "@") && !strings.HasPrefix(addr, "/")
+}
+
+// discardErrors is an enmime.ErrorCollector which ignores transport header warnings.
+type discardErrors struct{}
+
+func (discardErrors) AddError(string, string, ...any)   {}
+func (discardErrors) AddWarning(string, string, ...any) {}
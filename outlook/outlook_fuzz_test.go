@@ -0,0 +1,34 @@
+package outlook
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzReadEnvelope(f *testing.F) {
+	f.Add(buildCFB(msgStorage("Root Entry", propHeaderTopLevel, []testProp{
+		{pidSubject, "Fuzz"},
+		{pidBody, "body"},
+	}, recipient(0, recipTo, "", "to@example.com"))))
+	f.Add(withUint32(minimalMsg(), 0x2C, 0x7FFFFFFF))
+	f.Add(shortFixedPropsMsg())
+	f.Add(selfLoopMsg())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		envelope, err := ReadEnvelope(bytes.NewReader(b))
+		if envelope != nil && err != nil {
+			t.Error("envelope and error are not nil")
+		}
+	})
+}
+
+func FuzzDecompressRTF(f *testing.F) {
+	f.Add([]byte{
+		0x2d, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x7f, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5,
+		0xc7, 0xa7, 0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42,
+		0x32, 0x0a, 0xf3, 0x20, 0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0,
+		0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f, 0xa0,
+	})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = decompressRTF(b)
+	})
+}
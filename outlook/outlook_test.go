@@ -0,0 +1,211 @@
+package outlook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDate = time.Date(2024, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+func recipient(n int, typ int32, name, addr string) *cfbNode {
+	return msgStorage(prefixRecipient+"#0000000"+string(rune('0'+n)), propHeaderOther, []testProp{
+		{pidRecipientType, typ},
+		{pidDisplayName, name},
+		{pidSMTPAddress, addr},
+	})
+}
+
+func attachment(n int, props []testProp, extra ...*cfbNode) *cfbNode {
+	return msgStorage(prefixAttachment+"#0000000"+string(rune('0'+n)), propHeaderOther, props, extra...)
+}
+
+func TestReadEnvelope(t *testing.T) {
+	largeAttachment := bytes.Repeat([]byte("0123456789"), 1000)
+	embedded := msgStorage(prefixSubstorage+"3701000D", propHeaderEmbedded, []testProp{
+		{pidSubject, "Inner message"},
+		{pidSenderName, "Inner Sender"},
+		{pidSenderSMTPAddress, "inner@example.com"},
+		{pidBody, "inner body"},
+	}, recipient(0, recipTo, "", "outer@example.com"))
+
+	root := msgStorage("Root Entry", propHeaderTopLevel, []testProp{
+		{pidSubject, "Grüße from Outlook"},
+		{pidSentRepresentingName, "Sender Name"},
+		{pidSentRepresentingSMTP, "sender@example.com"},
+		{pidClientSubmitTime, testDate},
+		{pidInternetMessageID, "<abc@example.com>"},
+		{pidBody, "plain body"},
+		{pidHTML, []byte(`<html><body>html body <img src="cid:logo@example.com"></body></html>`)},
+		{pidInternetCodepage, int32(65001)},
+		{pidTransportMessageHeaders, "Received: from mx.example.com\r\nX-Mailer: Outlook\r\n"},
+	},
+		recipient(0, recipTo, "To Person", "to@example.com"),
+		recipient(1, recipCc, "", "cc@example.com"),
+		recipient(2, recipTo, "Exchange User", "/O=EXCHANGE/OU=GROUP/CN=USER"),
+		attachment(0, []testProp{
+			{pidAttachLongFilename, "logo.png"},
+			{pidAttachMIMETag, "image/png"},
+			{pidAttachContentID, "logo@example.com"},
+			{pidAttachData, []byte("png data")},
+		}),
+		attachment(1, []testProp{
+			{pidAttachLongFilename, "numbers.txt"},
+			{pidAttachData, largeAttachment},
+		}),
+		attachment(2, []testProp{
+			{pidDisplayName, "Inner message"},
+			{pidAttachMethod, int32(attachMethodEmbedded)},
+		}, embedded),
+	)
+
+	env, err := ReadEnvelope(bytes.NewReader(buildCFB(root)))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Grüße from Outlook", env.GetHeader("Subject"))
+	assert.Equal(t, `"Sender Name" <sender@example.com>`, env.GetHeader("From"))
+	assert.Equal(t, `"To Person" <to@example.com>`, env.GetHeader("To"))
+	assert.Equal(t, "<cc@example.com>", env.GetHeader("Cc"))
+	assert.Equal(t, "<abc@example.com>", env.GetHeader("Message-Id"))
+	assert.Equal(t, "from mx.example.com", env.GetHeader("Received"))
+	assert.Equal(t, "Outlook", env.GetHeader("X-Mailer"))
+	date, err := env.Date()
+	require.NoError(t, err)
+	assert.True(t, testDate.Equal(date))
+
+	assert.Equal(t, "plain body", env.Text)
+	assert.Contains(t, env.HTML, `<img src="cid:logo@example.com">`)
+
+	require.Len(t, env.Inlines, 1)
+	assert.Equal(t, "logo.png", env.Inlines[0].FileName)
+	assert.Equal(t, "logo@example.com", env.Inlines[0].ContentID)
+	assert.Equal(t, "png data", string(env.Inlines[0].Content))
+
+	require.Len(t, env.Attachments, 2)
+	assert.Equal(t, "numbers.txt", env.Attachments[0].FileName)
+	assert.Equal(t, largeAttachment, env.Attachments[0].Content)
+
+	inner := env.Attachments[1]
+	assert.Equal(t, "message/rfc822", inner.ContentType)
+	assert.Equal(t, "Inner message.eml", inner.FileName)
+	innerEnv, err := enmime.ReadEnvelope(bytes.NewReader(inner.Content))
+	require.NoError(t, err)
+	assert.Equal(t, "Inner message", innerEnv.GetHeader("Subject"))
+	assert.Equal(t, `"Inner Sender" <inner@example.com>`, innerEnv.GetHeader("From"))
+	assert.Equal(t, "<outer@example.com>", innerEnv.GetHeader("To"))
+	assert.Equal(t, "inner body", innerEnv.Text)
+}
+
+func TestReadEnvelopeRTFBody(t *testing.T) {
+	rtf := `{\rtf1 hello}`
+	compressed := append([]byte{
+		byte(len(rtf) + 12), 0, 0, 0, byte(len(rtf)), 0, 0, 0, 0x4d, 0x45, 0x4c, 0x41, 0, 0, 0, 0,
+	}, rtf...)
+	root := msgStorage("Root Entry", propHeaderTopLevel, []testProp{
+		{pidSubject, "RTF only"},
+		{pidRTFCompressed, compressed},
+	})
+
+	env, err := ReadEnvelope(bytes.NewReader(buildCFB(root)))
+	require.NoError(t, err)
+	assert.Empty(t, env.Text)
+	require.Len(t, env.OtherParts, 1)
+	assert.Equal(t, "text/rtf", env.OtherParts[0].ContentType)
+	assert.Equal(t, rtf, string(env.OtherParts[0].Content))
+}
+
+func TestReadParts(t *testing.T) {
+	root := msgStorage("Root Entry", propHeaderTopLevel, []testProp{
+		{pidSubject, "Parts"},
+		{pidBody, "text"},
+	})
+	p, err := ReadParts(bytes.NewReader(buildCFB(root)))
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", p.ContentType)
+	assert.Equal(t, "Parts", p.Header.Get("Subject"))
+	assert.Equal(t, "text", string(p.Content))
+}
+
+func TestReadEnvelopeInvalid(t *testing.T) {
+	tcases := map[string][]byte{
+		"not cfb":   []byte(strings.Repeat("x", 1024)),
+		"truncated": buildCFB(msgStorage("Root Entry", propHeaderTopLevel, nil))[:600],
+		"no props":  buildCFB(&cfbNode{name: "Root Entry", storage: true}),
+		// The FAT sector count must not exceed the number of sectors in the file.
+		"huge FAT count": withUint32(minimalMsg(), 0x2C, 0x7FFFFFFF),
+		"FAT count":      withUint32(minimalMsg(), 0x2C, 1000),
+		"sector loop":    selfLoopMsg(),
+	}
+	for name, data := range tcases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadEnvelope(bytes.NewReader(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// withUint32 returns a copy of b with the little-endian uint32 at off replaced by v.
+func withUint32(b []byte, off int, v uint32) []byte {
+	b = bytes.Clone(b)
+	binary.LittleEndian.PutUint32(b[off:], v)
+	return b
+}
+
+// selfLoopMsg returns a message whose directory sector chain links back to itself.
+func selfLoopMsg() []byte {
+	b := minimalMsg()
+	le := binary.LittleEndian
+	fatStart := int(le.Uint32(b[0x4C:]))
+	dirStart := le.Uint32(b[0x30:])
+	return withUint32(b, (fatStart+1)*512+4*int(dirStart), dirStart)
+}
+
+func TestReadChainLimit(t *testing.T) {
+	// Chains shared by many streams cannot read more than twice the size of the file in total.
+	b := minimalMsg()
+	cf, err := parseCompoundFile(b)
+	require.NoError(t, err)
+	dirStart := binary.LittleEndian.Uint32(b[0x30:])
+	for range len(b) {
+		if _, err = cf.readChain(dirStart, cf.fat, cf.sector); err != nil {
+			break
+		}
+	}
+	require.Error(t, err)
+	assert.LessOrEqual(t, cf.read, 2*len(b)+512)
+}
+
+// minimalMsg returns a message with an empty property stream.
+func minimalMsg() []byte {
+	return buildCFB(msgStorage("Root Entry", propHeaderTopLevel, nil))
+}
+
+// shortFixedPropsMsg returns a message holding 1 byte time and integer properties in substorage
+// streams, rather than the property stream.
+func shortFixedPropsMsg() []byte {
+	root := msgStorage("Root Entry", propHeaderTopLevel, []testProp{{pidSubject, "Short"}})
+	for _, tag := range []uint32{
+		uint32(pidMessageDeliveryTime)<<16 | ptSysTime,
+		uint32(pidMessageCodepage)<<16 | ptInt32,
+	} {
+		root.children = append(root.children,
+			&cfbNode{name: fmt.Sprintf("%s%08X", prefixSubstorage, tag), data: []byte{1}})
+	}
+	return buildCFB(root)
+}
+
+func TestReadEnvelopeFixedSizeSubstorage(t *testing.T) {
+	// Fixed size properties are only read from the property stream.
+	e, err := ReadEnvelope(bytes.NewReader(shortFixedPropsMsg()))
+	require.NoError(t, err)
+	assert.Equal(t, "Short", e.GetHeader("Subject"))
+	_, err = e.Date()
+	assert.Error(t, err)
+}
@@ -0,0 +1,283 @@
+package outlook
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/jhillyerd/enmime/v2/internal/coding"
+)
+
+// MAPI property types, see [MS-OXCDATA].
+const (
+	ptInt16   = 0x0002
+	ptInt32   = 0x0003
+	ptBoolean = 0x000B
+	ptObject  = 0x000D
+	ptString8 = 0x001E
+	ptUnicode = 0x001F
+	ptSysTime = 0x0040
+	ptBinary  = 0x0102
+)
+
+// MAPI property IDs used when converting messages, see [MS-OXPROPS].
+const (
+	pidSubject                 = 0x0037
+	pidClientSubmitTime        = 0x0039
+	pidSentRepresentingName    = 0x0042
+	pidSentRepresentingEmail   = 0x0065
+	pidTransportMessageHeaders = 0x007D
+	pidRecipientType           = 0x0C15
+	pidSenderName              = 0x0C1A
+	pidSenderEmail             = 0x0C1F
+	pidMessageDeliveryTime     = 0x0E06
+	pidBody                    = 0x1000
+	pidRTFCompressed           = 0x1009
+	pidHTML                    = 0x1013
+	pidInternetMessageID       = 0x1035
+	pidReferences              = 0x1039
+	pidInReplyTo               = 0x1042
+	pidDisplayName             = 0x3001
+	pidEmailAddress            = 0x3003
+	pidAttachData              = 0x3701
+	pidAttachFilename          = 0x3704
+	pidAttachMethod            = 0x3705
+	pidAttachLongFilename      = 0x3707
+	pidAttachMIMETag           = 0x370E
+	pidAttachContentID         = 0x3712
+	pidSMTPAddress             = 0x39FE
+	pidInternetCodepage        = 0x3FDE
+	pidMessageCodepage         = 0x3FFD
+	pidSenderSMTPAddress       = 0x5D01
+	pidSentRepresentingSMTP    = 0x5D02
+	pidAttachmentHidden        = 0x7FFE
+)
+
+// Storage and stream names used within .msg files, see [MS-OXMSG].
+const (
+	nameProperties   = "__properties_version1.0"
+	prefixSubstorage = "__substg1.0_"
+	prefixRecipient  = "__recip_version1.0_"
+	prefixAttachment = "__attach_version1.0_"
+)
+
+// Sizes of the property stream header, which vary with the storage type.
+const (
+	propHeaderTopLevel = 32
+	propHeaderEmbedded = 24
+	propHeaderOther    = 8
+	propEntrySize      = 16
+)
+
+// codepages maps Windows code page identifiers to charset names understood by the coding
+// package.
+var codepages = map[uint32]string{
+	874:   "windows-874",
+	932:   "shift_jis",
+	936:   "gbk",
+	949:   "euc-kr",
+	950:   "big5",
+	1250:  "windows-1250",
+	1251:  "windows-1251",
+	1252:  "windows-1252",
+	1253:  "windows-1253",
+	1254:  "windows-1254",
+	1255:  "windows-1255",
+	1256:  "windows-1256",
+	1257:  "windows-1257",
+	1258:  "windows-1258",
+	20866: "koi8-r",
+	21866: "koi8-u",
+	28591: "iso-8859-1",
+	28592: "iso-8859-2",
+	28605: "iso-8859-15",
+	50220: "iso-2022-jp",
+	51932: "euc-jp",
+	65001: "utf-8",
+}
+
+// property is a single MAPI property.  Fixed size values are stored inline, variable size values
+// hold the content of their substorage stream.
+type property struct {
+	typ  uint16
+	data []byte
+}
+
+// properties holds the MAPI properties of a message, recipient or attachment storage.
+type properties struct {
+	storage *dirEntry
+	values  map[uint16]property
+	charset string
+}
+
+// readProperties loads the property stream and variable length property streams of storage.
+func readProperties(cf *compoundFile, storage *dirEntry, headerSize int) (*properties, error) {
+	props := &properties{
+		storage: storage,
+		values:  make(map[uint16]property),
+	}
+	if e := storage.child(nameProperties); e != nil {
+		b, err := cf.stream(e)
+		if err != nil {
+			return nil, err
+		}
+		le := binary.LittleEndian
+		for i := headerSize; i+propEntrySize <= len(b); i += propEntrySize {
+			typ := le.Uint16(b[i:])
+			id := le.Uint16(b[i+2:])
+			switch typ {
+			case ptInt16, ptInt32, ptBoolean, ptSysTime:
+				props.values[id] = property{typ: typ, data: b[i+8 : i+16]}
+			}
+		}
+	}
+	for _, e := range storage.children {
+		if e.objType != objStream || !strings.HasPrefix(e.name, prefixSubstorage) {
+			continue
+		}
+		id, typ, ok := parseSubstorageName(e.name)
+		if !ok {
+			continue
+		}
+		switch typ {
+		case ptString8, ptUnicode, ptBinary, ptObject:
+		default:
+			// Fixed size values belong in the property stream, and multi-valued properties are
+			// not used.
+			continue
+		}
+		b, err := cf.stream(e)
+		if err != nil {
+			return nil, err
+		}
+		props.values[id] = property{typ: typ, data: b}
+	}
+	cp := props.int32(pidInternetCodepage)
+	if cp == 0 {
+		cp = props.int32(pidMessageCodepage)
+	}
+	props.charset = codepages[uint32(cp)]
+	return props, nil
+}
+
+// parseSubstorageName extracts the property ID and type from a name like __substg1.0_0037001F.
+func parseSubstorageName(name string) (id, typ uint16, ok bool) {
+	tag := strings.TrimPrefix(name, prefixSubstorage)
+	if len(tag) != 8 {
+		return 0, 0, false
+	}
+	v, err := strconv.ParseUint(tag, 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint16(v >> 16), uint16(v), true
+}
+
+// substorage returns the named child storage for an object property, or nil.
+func (p *properties) substorage(id uint16) *dirEntry {
+	e := p.storage.child(fmt.Sprintf("%s%04X%04X", prefixSubstorage, id, ptObject))
+	if e == nil || e.objType != objStorage {
+		return nil
+	}
+	return e
+}
+
+// string returns a string property converted to UTF-8, or an empty string.
+func (p *properties) string(id uint16) string {
+	v, ok := p.values[id]
+	if !ok {
+		return ""
+	}
+	switch v.typ {
+	case ptUnicode:
+		return decodeUTF16(v.data)
+	case ptString8, ptBinary:
+		return p.decode8Bit(v.data)
+	}
+	return ""
+}
+
+// decode8Bit converts b from the message code page to UTF-8.
+func (p *properties) decode8Bit(b []byte) string {
+	b = trimNulls(b)
+	if p.charset != "" {
+		if s, err := coding.ConvertToUTF8String(p.charset, b); err == nil {
+			return s
+		}
+	}
+	return string(b)
+}
+
+// binary returns the content of a binary property, or nil.
+func (p *properties) binary(id uint16) []byte {
+	v, ok := p.values[id]
+	if !ok || v.typ != ptBinary {
+		return nil
+	}
+	return v.data
+}
+
+// int32 returns an integer property, or zero.
+func (p *properties) int32(id uint16) int32 {
+	v, ok := p.values[id]
+	if !ok {
+		return 0
+	}
+	switch v.typ {
+	case ptInt16:
+		return int32(int16(binary.LittleEndian.Uint16(v.data)))
+	case ptInt32:
+		return int32(binary.LittleEndian.Uint32(v.data))
+	}
+	return 0
+}
+
+// bool returns a boolean property, or false.
+func (p *properties) bool(id uint16) bool {
+	v, ok := p.values[id]
+	return ok && v.typ == ptBoolean && v.data[0] != 0
+}
+
+// time returns a time property, or the zero time.
+func (p *properties) time(id uint16) time.Time {
+	v, ok := p.values[id]
+	if !ok || v.typ != ptSysTime {
+		return time.Time{}
+	}
+	return filetimeToTime(binary.LittleEndian.Uint64(v.data))
+}
+
+// filetimeToTime converts a count of 100ns intervals since 1601-01-01 UTC into a time.Time.
+func filetimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	// Seconds between 1601-01-01 and 1970-01-01.
+	const epochDelta = 11644473600
+	secs := int64(ft/10000000) - epochDelta
+	nsecs := int64(ft%10000000) * 100
+	return time.Unix(secs, nsecs).UTC()
+}
+
+// decodeUTF16 converts little endian UTF-16 to a string, dropping any null terminator.
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(b[i:]))
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units))
+}
+
+// trimNulls removes trailing null terminators.
+func trimNulls(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
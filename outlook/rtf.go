@@ -0,0 +1,87 @@
+package outlook
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Compressed RTF format constants, see [MS-OXRTFCP].
+const (
+	rtfHeaderSize   = 16
+	rtfDictSize     = 4096
+	rtfCompressed   = 0x75465A4C // "LZFu"
+	rtfUncompressed = 0x414C454D // "MELA"
+)
+
+// rtfPrebuffer initializes the LZFu dictionary.
+const rtfPrebuffer = `{\rtf1\ansi\mac\deff0\deftab720{\fonttbl;}{\f0\fnil \froman \fswiss \fmodern ` +
+	`\fscript \fdecor MS Sans SerifSymbolArialTimes New RomanCourier{\colortbl\red0\green0\blue0` +
+	"\r\n" + `\par \pard\plain\f0\fs20\b\i\u\tab\tx`
+
+// decompressRTF decodes the PidTagRtfCompressed property into plain RTF.
+func decompressRTF(b []byte) ([]byte, error) {
+	if len(b) < rtfHeaderSize {
+		return nil, errors.New("compressed RTF header is truncated")
+	}
+	le := binary.LittleEndian
+	compSize := int(le.Uint32(b[0:]))
+	rawSize := int(le.Uint32(b[4:]))
+	compType := le.Uint32(b[8:])
+	// compSize excludes its own field.
+	if end := compSize + 4; end >= rtfHeaderSize && end < len(b) {
+		b = b[:end]
+	}
+	data := b[rtfHeaderSize:]
+
+	switch compType {
+	case rtfUncompressed:
+		if rawSize < len(data) {
+			data = data[:rawSize]
+		}
+		return data, nil
+	case rtfCompressed:
+		// Handled below.
+	default:
+		return nil, errors.Errorf("unknown compressed RTF type %#x", compType)
+	}
+
+	var dict [rtfDictSize]byte
+	copy(dict[:], rtfPrebuffer)
+	wpos := len(rtfPrebuffer)
+	// rawSize is untrusted; a dictionary reference of two bytes expands to at most 17.
+	out := make([]byte, 0, min(rawSize, len(data)*9))
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+		for bit := 0; bit < 8 && i < len(data); bit++ {
+			if control&(1<<bit) == 0 {
+				// Literal byte.
+				dict[wpos] = data[i]
+				wpos = (wpos + 1) % rtfDictSize
+				out = append(out, data[i])
+				i++
+				continue
+			}
+			// Dictionary reference: 12 bit offset, 4 bit length.
+			if i+1 >= len(data) {
+				return nil, errors.New("compressed RTF dictionary reference is truncated")
+			}
+			ref := int(data[i])<<8 | int(data[i+1])
+			i += 2
+			offset := ref >> 4
+			if offset == wpos {
+				// End of stream.
+				return out, nil
+			}
+			length := ref&0xF + 2
+			for j := 0; j < length; j++ {
+				c := dict[(offset+j)%rtfDictSize]
+				dict[wpos] = c
+				wpos = (wpos + 1) % rtfDictSize
+				out = append(out, c)
+			}
+		}
+	}
+	return out, nil
+}
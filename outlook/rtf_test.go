@@ -0,0 +1,64 @@
+package outlook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTFPrebufferLength(t *testing.T) {
+	assert.Len(t, rtfPrebuffer, 207)
+}
+
+func TestDecompressRTF(t *testing.T) {
+	tcases := map[string]struct {
+		input []byte
+		want  string
+	}{
+		// Example from [MS-OXRTFCP] section 3.1.
+		"compressed": {
+			input: []byte{
+				0x2d, 0x00, 0x00, 0x00, 0x2b, 0x00, 0x00, 0x00, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5,
+				0xc7, 0xa7, 0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42,
+				0x32, 0x0a, 0xf3, 0x20, 0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0,
+				0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f, 0xa0,
+			},
+			want: `{\rtf1\ansi\ansicpg1252\pard hello world}` + "\r\n",
+		},
+		"untrusted raw size": {
+			input: []byte{
+				0x2d, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x7f, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5,
+				0xc7, 0xa7, 0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42,
+				0x32, 0x0a, 0xf3, 0x20, 0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0,
+				0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f, 0xa0,
+			},
+			want: `{\rtf1\ansi\ansicpg1252\pard hello world}` + "\r\n",
+		},
+		"uncompressed": {
+			input: append([]byte{
+				0x15, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x4d, 0x45, 0x4c, 0x41, 0x00, 0x00,
+				0x00, 0x00,
+			}, []byte(`{\rtf}`)...),
+			want: `{\rtf`,
+		},
+	}
+	for name, tc := range tcases {
+		t.Run(name, func(t *testing.T) {
+			got, err := decompressRTF(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestDecompressRTFErrors(t *testing.T) {
+	_, err := decompressRTF([]byte{1, 2, 3})
+	assert.Error(t, err)
+
+	_, err = decompressRTF([]byte{
+		0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x41, 0x41, 0x41, 0x41, 0x00, 0x00,
+		0x00, 0x00,
+	})
+	assert.Error(t, err)
+}
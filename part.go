@@ -32,6 +32,9 @@ type Part struct {
 	NextSibling *Part                // NextSibling of this part.
 	Header      textproto.MIMEHeader // Header for this part.
 
+	// OrderedHeader holds the header fields of a parsed part in their original order.
+	OrderedHeader *OrderedHeader
+
 	Boundary          string            // Boundary marker used within this part.
 	ContentID         string            // ContentID header for cid URL scheme.
 	ContentType       string            // ContentType header without parameters.
@@ -385,6 +388,9 @@ func (p *Part) Clone(parent *Part) *Part {
 		Content:     p.Content,
 		Epilogue:    p.Epilogue,
 	}
+	if p.OrderedHeader != nil {
+		newPart.OrderedHeader = NewOrderedHeader(p.OrderedHeader.Fields())
+	}
 	newPart.FirstChild = p.FirstChild.Clone(newPart)
 	newPart.NextSibling = p.NextSibling.Clone(parent)
 
@@ -511,6 +517,8 @@ func parseParts(parent *Part, reader *bufio.Reader) error {
 	return nil
 }
 
+// WithEncoder sets the Encoder used to encode this Part, and any descendants that do not have an
+// Encoder of their own.
 func (p *Part) WithEncoder(e *Encoder) *Part {
 	if e != nil {
 		p.encoder = e
@@ -1,6 +1,8 @@
 package enmime_test
 
 import (
+	"bytes"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -987,6 +989,25 @@ func TestClonePart(t *testing.T) {
 
 	clone := p.Clone(nil)
 	test.ComparePart(t, clone, p)
+
+	// The original header order is retained.
+	if p.OrderedHeader.Len() == 0 {
+		t.Fatal("Root node should have an ordered header")
+	}
+	got, want := clone.OrderedHeader.Fields(), p.OrderedHeader.Fields()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Clone OrderedHeader got: %v, want: %v", got, want)
+	}
+	wantBuf, gotBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := p.Encode(wantBuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Encode(gotBuf); err != nil {
+		t.Fatal(err)
+	}
+	if gotBuf.String() != wantBuf.String() {
+		t.Errorf("Clone encoded as:\n%s\nwant:\n%s", gotBuf, wantBuf)
+	}
 }
 
 func TestHtmlPartHasBoundary(t *testing.T) {
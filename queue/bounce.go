@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/dsn"
+	"github.com/pkg/errors"
+)
+
+// enhancedStatus matches an RFC 3463 enhanced status code at the start of a reply.
+var enhancedStatus = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})\b`)
+
+// classify records the outcome of a delivery attempt for e, adding the recipients which failed
+// permanently to e.Failed, and returns the recipients to retry.  Replies with a 5xx code are
+// permanent failures; 4xx replies, and errors without a reply such as connection failures, are
+// retried.
+func (q *Queue) classify(e *entry, res *enmime.SendResult, err error, now time.Time) []string {
+	var retry []string
+	rejected := func(rejected []enmime.RecipientResult) {
+		for _, r := range rejected {
+			if r.Code >= 500 {
+				e.Failed = append(e.Failed, newFailure(r.Recipient, r.Code, r.Message, now))
+				continue
+			}
+			retry = append(retry, r.Recipient)
+			e.LastCode, e.LastError = r.Code, r.Message
+		}
+	}
+
+	var rcptErr *enmime.RecipientError
+	switch {
+	case err == nil:
+		if res != nil {
+			rejected(res.Rejected)
+		}
+	case errors.As(err, &rcptErr):
+		rejected(rcptErr.Rejected)
+	default:
+		code := replyCode(err)
+		if permanent(err) {
+			for _, r := range e.Recipients {
+				e.Failed = append(e.Failed, newFailure(r, code, err.Error(), now))
+			}
+			return nil
+		}
+		e.LastCode, e.LastError = code, err.Error()
+		return e.Recipients
+	}
+	return retry
+}
+
+// permanent returns true if err reports a failure that is not worth retrying.
+func permanent(err error) bool {
+	if code := replyCode(err); code != 0 {
+		return code >= 500
+	}
+	var serr *enmime.SendmailError
+	if errors.As(err, &serr) {
+		return serr.ExitCode > 0 && !serr.Temporary()
+	}
+	return false
+}
+
+// replyCode returns the SMTP reply code reported by err, or 0 if there is none.
+func replyCode(err error) int {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code
+	}
+	return 0
+}
+
+// newFailure returns a failure for a recipient rejected with the specified reply.
+func newFailure(recipient string, code int, msg string, now time.Time) failure {
+	status := "5.0.0"
+	if m := enhancedStatus.FindStringSubmatch(msg); m != nil {
+		status = m[1]
+	} else if code >= 400 && code < 500 {
+		status = "4.0.0"
+	}
+	return failure{Recipient: recipient, Code: code, Message: msg, Status: status, Date: now}
+}
+
+// bounce queues a delivery status notification reporting the failed recipients of e to its
+// reverse-path.  Notifications are sent with an empty reverse-path, so are never bounced
+// themselves.
+func (q *Queue) bounce(e *entry, msg []byte) error {
+	if e.ReversePath == "" || e.ReversePath == "<>" {
+		q.logger.Warn("queue: not reporting failures of message without a reverse-path",
+			"id", e.ID)
+		return nil
+	}
+
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "This is the mail system at host %s.\r\n\r\n", q.hostname)
+	text.WriteString("Your message could not be delivered to one or more recipients.\r\n\r\n")
+	report := &dsn.Report{OriginalMessage: msg}
+	report.DeliveryStatus.MessageDSNs = append(report.DeliveryStatus.MessageDSNs, map[string][]string{
+		"Reporting-MTA": {"dns; " + q.hostname},
+		"Arrival-Date":  {e.Created.Format(time.RFC1123Z)},
+	})
+	for _, f := range e.Failed {
+		fields := map[string][]string{
+			"Final-Recipient":   {"rfc822; " + f.Recipient},
+			"Action":            {"failed"},
+			"Status":            {f.Status},
+			"Last-Attempt-Date": {f.Date.Format(time.RFC1123Z)},
+		}
+		diag := f.Message
+		if f.Code != 0 {
+			diag = strconv.Itoa(f.Code) + " " + diag
+			fields["Diagnostic-Code"] = []string{"smtp; " + strings.ReplaceAll(diag, "\n", " ")}
+		}
+		if f.Status == "4.4.7" {
+			diag = "delivery time expired: " + diag
+		}
+		fmt.Fprintf(text, "<%s>: %s\r\n", f.Recipient, diag)
+		report.DeliveryStatus.RecipientDSNs = append(report.DeliveryStatus.RecipientDSNs, fields)
+	}
+	report.Explanation.Text = text.String()
+
+	root := report.Part()
+	h := root.Header
+	h.Set("MIME-Version", "1.0")
+	h.Set("From", "Mail Delivery System <MAILER-DAEMON@"+q.hostname+">")
+	h.Set("To", e.ReversePath)
+	h.Set("Subject", "Undelivered Mail Returned to Sender")
+	h.Set("Date", time.Now().Format(time.RFC1123Z))
+	h.Set("Message-Id", "<"+e.ID+".dsn@"+q.hostname+">")
+	h.Set("Auto-Submitted", "auto-replied")
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		return err
+	}
+	// Added directly, as notifications are queued while Close waits for deliveries to finish.
+	_, err := q.add("", []string{e.ReversePath}, buf.Bytes())
+	return err
+}
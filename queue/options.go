@@ -0,0 +1,100 @@
+package queue
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Defaults for Queue options.
+const (
+	defaultWorkers    = 4
+	defaultMinBackoff = time.Minute
+	defaultMaxBackoff = time.Hour
+	defaultExpiry     = 5 * 24 * time.Hour
+
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// Option configures a Queue.
+type Option interface {
+	apply(q *Queue)
+}
+
+// Workers sets the number of messages delivered concurrently, 4 by default.
+func Workers(n int) Option {
+	return workersOption(n)
+}
+
+type workersOption int
+
+func (o workersOption) apply(q *Queue) {
+	q.workers = max(int(o), 1)
+}
+
+// Backoff sets the delay before retrying a message after a temporary failure.  The delay starts
+// at minDelay, doubling after each attempt up to maxDelay.  The defaults are one minute and one
+// hour.
+func Backoff(minDelay, maxDelay time.Duration) Option {
+	return backoffOption{minDelay, maxDelay}
+}
+
+type backoffOption struct {
+	minDelay, maxDelay time.Duration
+}
+
+func (o backoffOption) apply(q *Queue) {
+	q.minBackoff = o.minDelay
+	q.maxBackoff = max(o.maxDelay, o.minDelay)
+}
+
+// Expiry sets how long delivery of a message is retried, measured from when it was queued, five
+// days by default.  Recipients still failing when a message expires are reported to its sender in
+// a delivery status notification.
+func Expiry(d time.Duration) Option {
+	return expiryOption(d)
+}
+
+type expiryOption time.Duration
+
+func (o expiryOption) apply(q *Queue) {
+	q.expiry = time.Duration(o)
+}
+
+// ShutdownTimeout sets how long Close waits for the messages being delivered before interrupting
+// them, 30 seconds by default.
+func ShutdownTimeout(d time.Duration) Option {
+	return shutdownTimeoutOption(d)
+}
+
+type shutdownTimeoutOption time.Duration
+
+func (o shutdownTimeoutOption) apply(q *Queue) {
+	q.shutdown = max(time.Duration(o), 0)
+}
+
+// Hostname sets the host name reported in delivery status notifications, the name of the local
+// host by default.
+func Hostname(name string) Option {
+	return hostnameOption(name)
+}
+
+type hostnameOption string
+
+func (o hostnameOption) apply(q *Queue) {
+	q.hostname = string(o)
+}
+
+// Logger sets the logger reporting failed delivery attempts, slog.Default by default.
+func Logger(l *slog.Logger) Option {
+	return loggerOption{l}
+}
+
+type loggerOption struct {
+	l *slog.Logger
+}
+
+func (o loggerOption) apply(q *Queue) {
+	if o.l != nil {
+		q.logger = o.l
+	}
+}
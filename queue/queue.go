@@ -0,0 +1,344 @@
+// Package queue provides a durable outbound mail queue.  A Queue is an enmime.Sender which
+// persists each message to a spool directory, then delivers it in the background through another
+// Sender, such as an enmime.SMTPClient, retrying temporary failures with exponential backoff.
+// Recipients which cannot be delivered to are reported to the sender of the message in a delivery
+// status notification, built with the dsn package.
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/sendmw"
+	"github.com/pkg/errors"
+)
+
+// Queue is a Sender queueing messages in a spool directory for delivery by a pool of workers.
+// Messages remaining in the spool when the Queue is closed are delivered once a new Queue is
+// created for the directory.  Only one Queue may use a spool directory at a time.
+type Queue struct {
+	dir        string
+	next       enmime.Sender
+	workers    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	expiry     time.Duration
+	shutdown   time.Duration
+	hostname   string
+	logger     *slog.Logger
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	closed    bool
+	enqueuing sync.WaitGroup // Enqueue calls writing to the spool.
+
+	stop   context.CancelFunc // Stops the dispatcher and idle workers.
+	cancel context.CancelFunc // Interrupts the messages being delivered.
+	work   chan *entry
+	wake   chan struct{}
+	wg     sync.WaitGroup
+}
+
+var _ enmime.Sender = &Queue{}
+
+// New creates a Queue spooling messages to dir, which is created if necessary, and delivering
+// them with next.  Messages already in the spool are loaded, and delivery starts immediately.
+func New(dir string, next enmime.Sender, opts ...Option) (*Queue, error) {
+	q := &Queue{
+		dir:        dir,
+		next:       next,
+		workers:    defaultWorkers,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		expiry:     defaultExpiry,
+		shutdown:   defaultShutdownTimeout,
+		logger:     slog.Default(),
+		entries:    make(map[string]*entry),
+		work:       make(chan *entry),
+		wake:       make(chan struct{}, 1),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(q)
+		}
+	}
+	if q.hostname == "" {
+		if q.hostname, _ = os.Hostname(); q.hostname == "" {
+			q.hostname = "localhost"
+		}
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	stopCtx, stop := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	q.stop, q.cancel = stop, cancel
+	q.wg.Add(q.workers + 1)
+	go q.dispatch(stopCtx)
+	for range q.workers {
+		go q.worker(stopCtx, ctx)
+	}
+	return q, nil
+}
+
+// Send queues msg for delivery to the specified recipients, returning once it has been written to
+// the spool.  Delivery failures are not reported to the caller, but to reversePath by a delivery
+// status notification; none is sent when reversePath is empty.
+func (q *Queue) Send(reversePath string, recipients []string, msg []byte) error {
+	_, err := q.Enqueue(reversePath, recipients, msg)
+	return err
+}
+
+// Enqueue queues msg as Send does, and returns its queue ID.
+func (q *Queue) Enqueue(reversePath string, recipients []string, msg []byte) (string, error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return "", errors.New("queue: closed")
+	}
+	// Close waits for the message to be written to the spool.
+	q.enqueuing.Add(1)
+	q.mu.Unlock()
+	defer q.enqueuing.Done()
+	return q.add(reversePath, recipients, msg)
+}
+
+// add writes msg to the spool, and queues it for delivery.
+func (q *Queue) add(reversePath string, recipients []string, msg []byte) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("queue: no recipients")
+	}
+	now := time.Now()
+	e := &entry{
+		ID:          newID(),
+		ReversePath: reversePath,
+		Recipients:  slices.Clone(recipients),
+		Created:     now,
+		NextAttempt: now,
+	}
+	if err := writeFile(q.path(e.ID, extMessage), msg); err != nil {
+		return "", err
+	}
+	if err := q.save(e); err != nil {
+		_ = q.remove(e)
+		return "", err
+	}
+	q.mu.Lock()
+	q.entries[e.ID] = e
+	q.mu.Unlock()
+	q.signal()
+	return e.ID, nil
+}
+
+// Len returns the number of messages in the queue, including those being delivered.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Close stops delivery, and waits for the workers to exit.  Messages being delivered are given
+// the ShutdownTimeout to complete, after which they are interrupted, and attempted again by the
+// next Queue.  Queued messages remain in the spool.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.enqueuing.Wait()
+
+	q.stop()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(q.shutdown)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		q.cancel()
+		<-done
+	}
+	q.cancel()
+	return nil
+}
+
+// signal wakes the dispatcher to check for messages due for delivery.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch passes messages to the workers when they are due for delivery.
+func (q *Queue) dispatch(ctx context.Context) {
+	defer q.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var due []*entry
+		var next time.Time
+		for _, e := range q.entries {
+			switch {
+			case e.busy:
+			case !e.NextAttempt.After(now):
+				e.busy = true
+				due = append(due, e)
+			case next.IsZero() || e.NextAttempt.Before(next):
+				next = e.NextAttempt
+			}
+		}
+		q.mu.Unlock()
+
+		// Deliver the oldest messages first.
+		slices.SortFunc(due, func(a, b *entry) int { return a.Created.Compare(b.Created) })
+		for _, e := range due {
+			select {
+			case q.work <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// worker delivers messages passed by the dispatcher until stopCtx is done.  Deliveries are
+// interrupted when ctx is done.
+func (q *Queue) worker(stopCtx, ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		select {
+		case e := <-q.work:
+			if stopCtx.Err() == nil {
+				q.deliver(ctx, e)
+			}
+			q.mu.Lock()
+			e.busy = false
+			q.mu.Unlock()
+			q.signal()
+		case <-stopCtx.Done():
+			return
+		}
+	}
+}
+
+// deliver makes a delivery attempt for e.  e is owned by the calling worker while busy.
+func (q *Queue) deliver(ctx context.Context, e *entry) {
+	log := q.logger.With("id", e.ID)
+	msg, err := os.ReadFile(q.path(e.ID, extMessage))
+	if err != nil {
+		log.Error("queue: message content unreadable, dropping message", "error", err)
+		q.drop(e)
+		return
+	}
+
+	if len(e.Recipients) > 0 && !q.attempt(ctx, e, msg) {
+		return
+	}
+
+	// Delivery is complete.
+	if len(e.Failed) > 0 {
+		if err := q.bounce(e, msg); err != nil {
+			// Keep the message until the notification can be queued.
+			log.Error("queue: failed to queue delivery status notification", "error", err)
+			e.Recipients = nil
+			e.NextAttempt = time.Now().Add(q.minBackoff)
+			if err := q.save(e); err != nil {
+				log.Error("queue: failed to update spool", "error", err)
+			}
+			return
+		}
+	}
+	q.drop(e)
+}
+
+// attempt sends msg to the pending recipients of e, and returns true if there are none left to
+// retry.
+func (q *Queue) attempt(ctx context.Context, e *entry, msg []byte) bool {
+	log := q.logger.With("id", e.ID)
+	res, err := sendmw.SendContext(ctx, q.next, e.ReversePath, e.Recipients, msg)
+	if ctx.Err() != nil {
+		// Interrupted by Close; the attempt is made again by the next Queue.
+		return false
+	}
+	now := time.Now()
+	e.Attempts++
+	retry := q.classify(e, res, err, now)
+	e.Recipients = retry
+	if len(retry) == 0 {
+		return true
+	}
+
+	if q.expiry > 0 && !now.Before(e.Created.Add(q.expiry)) {
+		log.Error("queue: message expired", "recipients", retry, "error", e.LastError)
+		for _, r := range retry {
+			e.Failed = append(e.Failed, failure{
+				Recipient: r,
+				Code:      e.LastCode,
+				Message:   e.LastError,
+				Status:    "4.4.7",
+				Date:      now,
+			})
+		}
+		e.Recipients = nil
+		return true
+	}
+
+	e.NextAttempt = now.Add(q.backoff(e.Attempts))
+	if q.expiry > 0 {
+		// Make a last attempt when the message expires.
+		if deadline := e.Created.Add(q.expiry); e.NextAttempt.After(deadline) {
+			e.NextAttempt = deadline
+		}
+	}
+	log.Warn("queue: delivery deferred", "recipients", retry, "attempts", e.Attempts,
+		"next_attempt", e.NextAttempt, "code", e.LastCode, "error", e.LastError)
+	if err := q.save(e); err != nil {
+		log.Error("queue: failed to update spool", "error", err)
+	}
+	return false
+}
+
+// drop removes e from the queue and the spool.
+func (q *Queue) drop(e *entry) {
+	if err := q.remove(e); err != nil {
+		q.logger.Error("queue: failed to remove message from spool", "id", e.ID, "error", err)
+	}
+	q.mu.Lock()
+	delete(q.entries, e.ID)
+	q.mu.Unlock()
+}
+
+// backoff returns the delay before the next delivery attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.minBackoff
+	for i := 1; i < attempts && d < q.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.maxBackoff)
+}
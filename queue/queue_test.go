@@ -0,0 +1,352 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/dsn"
+	"github.com/jhillyerd/enmime/v2/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: alice@example.com\r\n" +
+	"To: bob@example.com, carol@example.com\r\n" +
+	"Subject: Queued\r\n" +
+	"\r\n" +
+	"Hello\r\n"
+
+type delivery struct {
+	reversePath string
+	recipients  []string
+	msg         []byte
+}
+
+// fakeSender delivers messages by calling reply for each attempt, recording the messages
+// accepted for some recipient.
+type fakeSender struct {
+	mu        sync.Mutex
+	attempts  int
+	delivered []delivery
+	reply     func(attempt int, recipients []string) (*enmime.SendResult, error)
+}
+
+func (f *fakeSender) Send(reversePath string, recipients []string, msg []byte) error {
+	_, err := f.SendContext(context.Background(), reversePath, recipients, msg)
+	return err
+}
+
+func (f *fakeSender) SendContext(
+	_ context.Context,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*enmime.SendResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	res := &enmime.SendResult{}
+	var err error
+	if f.reply != nil && reversePath != "" {
+		res, err = f.reply(f.attempts, recipients)
+	} else {
+		for _, r := range recipients {
+			res.Accepted = append(res.Accepted, enmime.RecipientResult{Recipient: r, Code: 250})
+		}
+	}
+	if res != nil && len(res.Accepted) > 0 {
+		accepted := make([]string, len(res.Accepted))
+		for i, r := range res.Accepted {
+			accepted[i] = r.Recipient
+		}
+		f.delivered = append(f.delivered, delivery{reversePath, accepted, msg})
+	}
+	return res, err
+}
+
+func (f *fakeSender) deliveries() []delivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]delivery(nil), f.delivered...)
+}
+
+func newQueue(t *testing.T, dir string, next enmime.Sender, opts ...queue.Option) *queue.Queue {
+	t.Helper()
+	opts = append([]queue.Option{
+		queue.Backoff(10*time.Millisecond, 40*time.Millisecond),
+		queue.Hostname("mx.example.com"),
+		queue.Logger(slog.New(slog.DiscardHandler)),
+	}, opts...)
+	q, err := queue.New(dir, next, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = q.Close() })
+	return q
+}
+
+func waitEmpty(t *testing.T, q *queue.Queue) {
+	t.Helper()
+	require.Eventually(t, func() bool { return q.Len() == 0 }, 5*time.Second, 5*time.Millisecond)
+}
+
+// readReport parses a delivery status notification.
+func readReport(t *testing.T, d delivery) *dsn.Report {
+	t.Helper()
+	assert.Empty(t, d.reversePath)
+	assert.Equal(t, []string{"alice@example.com"}, d.recipients)
+	e, err := enmime.ReadEnvelope(strings.NewReader(string(d.msg)))
+	require.NoError(t, err)
+	assert.Equal(t, "auto-replied", e.GetHeader("Auto-Submitted"))
+	report, err := dsn.ParseReport(e.Root)
+	require.NoError(t, err)
+	require.NotNil(t, report)
+	return report
+}
+
+func TestQueueDelivery(t *testing.T) {
+	dir := t.TempDir()
+	f := &fakeSender{}
+	q := newQueue(t, dir, f)
+	rcpts := []string{"bob@example.com", "carol@example.com"}
+	require.NoError(t, q.Send("alice@example.com", rcpts, []byte(testMessage)))
+	waitEmpty(t, q)
+
+	d := f.deliveries()
+	require.Len(t, d, 1)
+	assert.Equal(t, "alice@example.com", d[0].reversePath)
+	assert.Equal(t, rcpts, d[0].recipients)
+	assert.Equal(t, testMessage, string(d[0].msg))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.Error(t, q.Send("alice@example.com", nil, []byte(testMessage)))
+}
+
+func TestQueueRetry(t *testing.T) {
+	f := &fakeSender{reply: func(attempt int, rcpts []string) (*enmime.SendResult, error) {
+		switch attempt {
+		case 1:
+			return nil, errors.New("dial tcp: connection refused")
+		case 2:
+			return nil, &textproto.Error{Code: 421, Msg: "4.3.2 Try again later"}
+		case 3:
+			// Partial success, carol is retried.
+			return &enmime.SendResult{
+				Accepted: []enmime.RecipientResult{{Recipient: rcpts[0], Code: 250}},
+				Rejected: []enmime.RecipientResult{{Recipient: rcpts[1], Code: 452}},
+			}, nil
+		}
+		res := &enmime.SendResult{}
+		for _, r := range rcpts {
+			res.Accepted = append(res.Accepted, enmime.RecipientResult{Recipient: r, Code: 250})
+		}
+		return res, nil
+	}}
+	q := newQueue(t, t.TempDir(), f)
+	require.NoError(t, q.Send("alice@example.com",
+		[]string{"bob@example.com", "carol@example.com"}, []byte(testMessage)))
+	waitEmpty(t, q)
+
+	d := f.deliveries()
+	require.Len(t, d, 2)
+	assert.Equal(t, []string{"bob@example.com"}, d[0].recipients)
+	assert.Equal(t, []string{"carol@example.com"}, d[1].recipients)
+}
+
+func TestQueuePermanentFailure(t *testing.T) {
+	f := &fakeSender{reply: func(_ int, rcpts []string) (*enmime.SendResult, error) {
+		return &enmime.SendResult{
+			Accepted: []enmime.RecipientResult{{Recipient: rcpts[0], Code: 250}},
+			Rejected: []enmime.RecipientResult{
+				{Recipient: rcpts[1], Code: 550, Message: "5.1.1 No such user"},
+			},
+		}, nil
+	}}
+	q := newQueue(t, t.TempDir(), f)
+	require.NoError(t, q.Send("alice@example.com",
+		[]string{"bob@example.com", "carol@example.com"}, []byte(testMessage)))
+	waitEmpty(t, q)
+
+	d := f.deliveries()
+	require.Len(t, d, 2)
+	report := readReport(t, d[1])
+	assert.Contains(t, report.Explanation.Text, "<carol@example.com>: 550 5.1.1 No such user")
+	assert.Equal(t, "dns; mx.example.com", report.DeliveryStatus.MessageDSNs[0].Get("Reporting-MTA"))
+	require.Len(t, report.DeliveryStatus.RecipientDSNs, 1)
+	rcpt := report.DeliveryStatus.RecipientDSNs[0]
+	assert.Equal(t, "rfc822; carol@example.com", rcpt.Get("Final-Recipient"))
+	assert.True(t, dsn.IsFailed(rcpt))
+	assert.Equal(t, "5.1.1", rcpt.Get("Status"))
+	assert.Equal(t, "smtp; 550 5.1.1 No such user", rcpt.Get("Diagnostic-Code"))
+	assert.Contains(t, string(report.OriginalMessage), "Subject: Queued")
+}
+
+func TestQueueExpiry(t *testing.T) {
+	f := &fakeSender{reply: func(_ int, rcpts []string) (*enmime.SendResult, error) {
+		rejected := make([]enmime.RecipientResult, len(rcpts))
+		for i, r := range rcpts {
+			rejected[i] = enmime.RecipientResult{Recipient: r, Code: 452, Message: "4.2.2 Mailbox full"}
+		}
+		return nil, &enmime.RecipientError{Rejected: rejected}
+	}}
+	q := newQueue(t, t.TempDir(), f, queue.Expiry(100*time.Millisecond))
+	require.NoError(t, q.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	waitEmpty(t, q)
+
+	d := f.deliveries()
+	require.Len(t, d, 1)
+	report := readReport(t, d[0])
+	require.Len(t, report.DeliveryStatus.RecipientDSNs, 1)
+	rcpt := report.DeliveryStatus.RecipientDSNs[0]
+	assert.Equal(t, "4.4.7", rcpt.Get("Status"))
+	assert.Equal(t, "smtp; 452 4.2.2 Mailbox full", rcpt.Get("Diagnostic-Code"))
+	assert.Contains(t, report.Explanation.Text, "delivery time expired")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Greater(t, f.attempts, 3)
+}
+
+func TestQueueDurable(t *testing.T) {
+	dir := t.TempDir()
+	failing := &fakeSender{reply: func(int, []string) (*enmime.SendResult, error) {
+		return nil, errors.New("connection refused")
+	}}
+	q, err := queue.New(dir, failing, queue.Backoff(time.Hour, time.Hour),
+		queue.Logger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+	require.NoError(t, q.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	require.Eventually(t, func() bool {
+		failing.mu.Lock()
+		defer failing.mu.Unlock()
+		return failing.attempts == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, q.Close())
+	assert.Error(t, q.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+
+	// Leftovers of an interrupted write are removed.
+	require.NoError(t, os.WriteFile(dir+"/orphan.eml", []byte(testMessage), 0o600))
+
+	// A new queue loads the message, and delivers it when due.
+	f := &fakeSender{}
+	q = newQueue(t, dir, f, queue.Backoff(time.Hour, time.Hour))
+	assert.Equal(t, 1, q.Len())
+	assert.Empty(t, f.deliveries())
+	require.NoError(t, q.Close())
+
+	q = newQueue(t, dir, f, queue.Expiry(time.Nanosecond))
+	waitEmpty(t, q)
+	require.Len(t, f.deliveries(), 1)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+// blockingSender holds each delivery attempt until released, or its context is done.
+type blockingSender struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSender) Send(reversePath string, recipients []string, msg []byte) error {
+	_, err := b.SendContext(context.Background(), reversePath, recipients, msg)
+	return err
+}
+
+func (b *blockingSender) SendContext(
+	ctx context.Context,
+	_ string,
+	recipients []string,
+	_ []byte,
+) (*enmime.SendResult, error) {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	res := &enmime.SendResult{}
+	for _, r := range recipients {
+		res.Accepted = append(res.Accepted, enmime.RecipientResult{Recipient: r, Code: 250})
+	}
+	return res, nil
+}
+
+func TestQueueCloseWaitsForDelivery(t *testing.T) {
+	dir := t.TempDir()
+	b := &blockingSender{started: make(chan struct{}, 1), release: make(chan struct{})}
+	q := newQueue(t, dir, b)
+	require.NoError(t, q.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	<-b.started
+
+	closed := make(chan struct{})
+	go func() {
+		assert.NoError(t, q.Close())
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the delivery finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(b.release)
+	<-closed
+
+	// The delivered message was removed from the spool.
+	assert.Equal(t, 0, q.Len())
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestQueueCloseTimeout(t *testing.T) {
+	dir := t.TempDir()
+	b := &blockingSender{started: make(chan struct{}, 1), release: make(chan struct{})}
+	q := newQueue(t, dir, b, queue.ShutdownTimeout(20*time.Millisecond))
+	require.NoError(t, q.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	<-b.started
+
+	start := time.Now()
+	require.NoError(t, q.Close())
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// The interrupted message remains in the spool.
+	f := &fakeSender{}
+	q = newQueue(t, dir, f)
+	waitEmpty(t, q)
+	require.Len(t, f.deliveries(), 1)
+}
+
+func TestQueueEnqueueDuringClose(t *testing.T) {
+	// Close waits for Enqueue calls writing to the spool, so nothing is written once it returns.
+	dir := t.TempDir()
+	q := newQueue(t, dir, &fakeSender{}, queue.Workers(1))
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for {
+				if _, err := q.Enqueue("", []string{"bob@example.com"}, []byte(testMessage)); err != nil {
+					return
+				}
+			}
+		})
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, q.Close())
+	want, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	wg.Wait()
+	got, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, len(want), len(got))
+	// Each message remains complete in the spool.
+	assert.Zero(t, len(got)%2)
+}
@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Spool file extensions.  The message content is written first, so an entry is complete once its
+// envelope file exists.
+const (
+	extMessage  = ".eml"
+	extEnvelope = ".json"
+	tmpPrefix   = ".tmp-"
+)
+
+// entry is a queued message, persisted as JSON alongside the message content.
+type entry struct {
+	ID          string    `json:"-"`
+	ReversePath string    `json:"reverse_path"`
+	Recipients  []string  `json:"recipients"` // Recipients still to be delivered to.
+	Failed      []failure `json:"failed,omitempty"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastCode    int       `json:"last_code,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	busy bool // Being delivered by a worker.
+}
+
+// failure records a recipient which delivery failed for permanently, to be reported in a DSN.
+type failure struct {
+	Recipient string    `json:"recipient"`
+	Code      int       `json:"code,omitempty"`
+	Message   string    `json:"message"`
+	Status    string    `json:"status"`
+	Date      time.Time `json:"date"`
+}
+
+// newID returns a unique, time ordered, queue ID.
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + hex.EncodeToString(b)
+}
+
+// path returns the path of the spool file of the message id with the specified extension.
+func (q *Queue) path(id, ext string) string {
+	return filepath.Join(q.dir, id+ext)
+}
+
+// writeFile atomically replaces the named file with data, syncing it to disk.
+func writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), tmpPrefix+"*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// save persists the envelope of e.
+func (q *Queue) save(e *entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writeFile(q.path(e.ID, extEnvelope), b)
+}
+
+// remove deletes the spool files of e.
+func (q *Queue) remove(e *entry) error {
+	if err := os.Remove(q.path(e.ID, extEnvelope)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err := os.Remove(q.path(e.ID, extMessage)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// load reads the entries of the spool directory, removing files left by interrupted writes.
+func (q *Queue) load() error {
+	des, err := os.ReadDir(q.dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	envelopes := make(map[string]bool)
+	for _, de := range des {
+		if id, ok := strings.CutSuffix(de.Name(), extEnvelope); ok && !de.IsDir() {
+			envelopes[id] = true
+		}
+	}
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(name, tmpPrefix) {
+			_ = os.Remove(filepath.Join(q.dir, name))
+			continue
+		}
+		if id, ok := strings.CutSuffix(name, extMessage); ok && !envelopes[id] {
+			// Queueing of the message was interrupted.
+			_ = os.Remove(filepath.Join(q.dir, name))
+		}
+	}
+
+	for id := range envelopes {
+		b, err := os.ReadFile(q.path(id, extEnvelope))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		e := &entry{}
+		if err := json.Unmarshal(b, e); err != nil {
+			return errors.Wrapf(err, "reading queue entry %s", id)
+		}
+		e.ID = id
+		if deadline := e.Created.Add(q.expiry); q.expiry > 0 && e.NextAttempt.After(deadline) {
+			// The expiry may have been shortened since the message was queued.
+			e.NextAttempt = deadline
+		}
+		if _, err := os.Stat(q.path(id, extMessage)); err != nil {
+			q.logger.Error("queue: message content missing", "id", id, "error", err)
+			_ = q.remove(e)
+			continue
+		}
+		q.entries[id] = e
+	}
+	return nil
+}
@@ -0,0 +1,475 @@
+package enmime
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ReplySections holds the Text and HTML bodies of an Envelope split into the newly written
+// content, the quoted history of earlier messages, and the sender's signature.  Sections that
+// could not be found are empty.
+type ReplySections struct {
+	Text          string // Newly written plain text content
+	TextQuoted    string // Quoted plain text history, including attribution lines
+	TextSignature string // Plain text signature, excluding the "-- " delimiter
+	HTML          string // Newly written HTML content
+	HTMLQuoted    string // Quoted HTML history, including attribution elements
+	HTMLSignature string // HTML signature
+}
+
+// Reply splits Envelope.Text and Envelope.HTML into the newly written content, the quoted history
+// and the signature.
+//
+// Quoted history is recognized by "On ... wrote:" attribution lines, lines prefixed with ">",
+// Outlook "-----Original Message-----" separators and From:/Sent: header blocks, and in HTML by
+// Gmail gmail_quote, Thunderbird moz-cite-prefix, Yahoo yahoo_quoted and Outlook divRplyFwdMsg
+// elements, and cite blockquotes.  Everything following an Outlook separator is treated as
+// history, as is everything following the first quote in HTML.
+//
+// Signatures begin at a "-- " delimiter line.  Without one, lines following a closing such as
+// "Thanks," and trailing "Sent from my ..." lines are treated as the signature.  In HTML,
+// signature elements created by common clients are recognized.
+//
+// When neither history nor a signature is found, the content is returned unchanged.
+func (e *Envelope) Reply() (*ReplySections, error) {
+	r := &ReplySections{}
+	r.Text, r.TextQuoted, r.TextSignature = splitReplyText(e.Text)
+	var err error
+	r.HTML, r.HTMLQuoted, r.HTMLSignature, err = splitReplyHTML(e.HTML)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+var (
+	// Attribution lines in several languages, for example "On Mon, Jan 1 Bob <b@x> wrote:" and
+	// "Am 01.01.2024 um 10:00 schrieb Bob <b@x>:".
+	attributionRegexp = regexp.MustCompile(
+		`(?i)^(on|am|le|el|il|op)\s.*(wrote|schrieb|écrit|escribió|scritto|schreef).*:$`)
+	originalMessageRegexp = regexp.MustCompile(`(?i)^-{2,}\s*original message\s*-{2,}$`)
+	headerFromRegexp      = regexp.MustCompile(`(?i)^\*?from:\*?\s`)
+	headerSentRegexp      = regexp.MustCompile(`(?i)^\*?(sent|date):\*?\s`)
+	separatorRegexp       = regexp.MustCompile(`^_{10,}$`)
+	closingRegexp         = regexp.MustCompile(
+		`(?i)^(thanks|thank you|many thanks|regards|best regards|kind regards|warm regards|` +
+			`best wishes|cheers|best|sincerely|yours|br)\s*[,.!]?$`)
+	mobileFooterRegexp = regexp.MustCompile(
+		`(?i)^(sent from my|sent from mail for|sent via|get outlook for)\s`)
+)
+
+// Limits on the signature heuristic used when no "-- " delimiter is present.
+const (
+	maxClosingSignatureLines = 4
+	maxSignatureLineLength   = 60
+)
+
+// Line classes used while splitting plain text.
+const (
+	lineContent = iota
+	lineQuoted
+)
+
+// splitReplyText splits a plain text body into content, quoted history and signature.
+func splitReplyText(text string) (content, quoted, signature string) {
+	if text == "" {
+		return "", "", ""
+	}
+	lines := strings.Split(text, "\n")
+	classes := make([]int, len(lines))
+	found := false
+	prev := lineContent
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if historyStart(lines, i) {
+			// Everything following an Outlook separator is history.
+			for ; i < len(lines); i++ {
+				classes[i] = lineQuoted
+			}
+			found = true
+			break
+		}
+		if n := attributionLines(lines, i); n > 0 {
+			for j := 0; j < n; j++ {
+				classes[i+j] = lineQuoted
+			}
+			i += n - 1
+			prev = lineQuoted
+			found = true
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, ">"):
+			classes[i] = lineQuoted
+			prev = lineQuoted
+			found = true
+		case line == "":
+			// Blank lines belong with the lines above them.
+			classes[i] = prev
+		default:
+			classes[i] = lineContent
+			prev = lineContent
+		}
+	}
+
+	var contentLines, quotedLines []string
+	for i, line := range lines {
+		if classes[i] == lineQuoted {
+			quotedLines = append(quotedLines, line)
+		} else {
+			contentLines = append(contentLines, line)
+		}
+	}
+	sigLines := splitSignature(contentLines)
+	if !found && sigLines == nil {
+		return text, "", ""
+	}
+	if sigLines != nil {
+		start := len(contentLines) - len(sigLines)
+		contentLines = contentLines[:start]
+		if isSignatureDelimiter(sigLines[0]) {
+			sigLines = sigLines[1:]
+		}
+	}
+	return joinTrimmed(contentLines), joinTrimmed(quotedLines), joinTrimmed(sigLines)
+}
+
+// historyStart returns true if lines[i] begins an Outlook style block of history,
+// either an "-----Original Message-----" separator, or a From: header block.
+func historyStart(lines []string, i int) bool {
+	line := strings.TrimSpace(lines[i])
+	if originalMessageRegexp.MatchString(line) {
+		return true
+	}
+	if separatorRegexp.MatchString(line) {
+		// Outlook separates replies with a line of underscores followed by a From: header.
+		for j := i + 1; j < len(lines); j++ {
+			next := strings.TrimSpace(lines[j])
+			if next != "" {
+				return headerFromRegexp.MatchString(next)
+			}
+		}
+		return false
+	}
+	if headerFromRegexp.MatchString(line) {
+		for j := i + 1; j < len(lines) && j <= i+3; j++ {
+			if headerSentRegexp.MatchString(strings.TrimSpace(lines[j])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attributionLines returns the number of lines, starting at lines[i], that make up an attribution
+// line such as "On ... wrote:", or 0 if there is none.  Long attributions are often wrapped onto a
+// second line.
+func attributionLines(lines []string, i int) int {
+	line := strings.TrimSpace(lines[i])
+	if line == "" || strings.HasPrefix(line, ">") {
+		return 0
+	}
+	if attributionRegexp.MatchString(line) {
+		return 1
+	}
+	if i+1 < len(lines) {
+		next := strings.TrimSpace(lines[i+1])
+		if next != "" && !strings.HasPrefix(next, ">") &&
+			attributionRegexp.MatchString(line+" "+next) {
+			return 2
+		}
+	}
+	return 0
+}
+
+// splitSignature returns the trailing lines of content that form a signature, or nil.
+func splitSignature(lines []string) []string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if isSignatureDelimiter(lines[i]) {
+			return lines[i:]
+		}
+	}
+
+	// Locate the last few non-blank lines.
+	var tail []int
+	for i := len(lines) - 1; i >= 0 && len(tail) <= maxClosingSignatureLines; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			tail = append(tail, i)
+		}
+	}
+	if len(tail) == 0 {
+		return nil
+	}
+
+	// A short block following a closing such as "Regards,".
+	for n, i := range tail {
+		if n == 0 {
+			continue
+		}
+		if closingRegexp.MatchString(strings.TrimSpace(lines[i])) {
+			for _, j := range tail[:n] {
+				if len(strings.TrimSpace(lines[j])) > maxSignatureLineLength {
+					return nil
+				}
+			}
+			return lines[i+1:]
+		}
+	}
+
+	if last := tail[0]; mobileFooterRegexp.MatchString(strings.TrimSpace(lines[last])) {
+		return lines[last:]
+	}
+	return nil
+}
+
+// isSignatureDelimiter returns true for the "-- " line that precedes a signature.
+func isSignatureDelimiter(line string) bool {
+	return strings.TrimRight(line, " \r") == "--"
+}
+
+// joinTrimmed joins lines, removing leading and trailing blank lines.
+func joinTrimmed(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Class names and IDs of elements that contain quoted history.
+var (
+	quoteClasses = []string{"gmail_quote", "gmail_quote_container", "moz-cite-prefix", "yahoo_quoted"}
+	quoteIDs     = []string{"divRplyFwdMsg", "appendonsend", "stopSpelling"}
+)
+
+// Class names and IDs of elements that contain a signature.
+var (
+	signatureClasses = []string{"gmail_signature", "moz-signature", "signature"}
+	signatureIDs     = []string{"signature", "Signature"}
+)
+
+// splitReplyHTML splits an HTML body into content, quoted history and signature.  Each section
+// is rendered as a complete document, retaining the head and the elements enclosing it.
+func splitReplyHTML(body string) (content, quoted, signature string, err error) {
+	if body == "" {
+		return "", "", "", nil
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", "", "", err
+	}
+	bodyNode := findElement(doc, atom.Body)
+	if bodyNode == nil {
+		return body, "", "", nil
+	}
+
+	// Number the nodes within body in document order.
+	order := make(map[*html.Node]int)
+	var nodes []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		order[n] = len(nodes)
+		nodes = append(nodes, n)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for c := bodyNode.FirstChild; c != nil; c = c.NextSibling {
+		walk(c)
+	}
+
+	quoteAt := len(nodes)
+	for _, n := range nodes {
+		if isQuoteNode(n) {
+			quoteAt = order[liftMarker(attributionBefore(n), bodyNode)]
+			break
+		}
+	}
+	sigAt := quoteAt
+	for _, n := range nodes[:quoteAt] {
+		if isSignatureNode(n) {
+			sigAt = order[liftMarker(n, bodyNode)]
+			break
+		}
+	}
+	if quoteAt == len(nodes) && sigAt == quoteAt {
+		return body, "", "", nil
+	}
+
+	render := func(from, to int) (string, error) {
+		if from >= to {
+			return "", nil
+		}
+		section := copySection(doc, bodyNode, order, from, to)
+		buf := &bytes.Buffer{}
+		if err := html.Render(buf, section); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	if content, err = render(0, sigAt); err != nil {
+		return "", "", "", err
+	}
+	if signature, err = render(sigAt, quoteAt); err != nil {
+		return "", "", "", err
+	}
+	if quoted, err = render(quoteAt, len(nodes)); err != nil {
+		return "", "", "", err
+	}
+	return content, quoted, signature, nil
+}
+
+// isQuoteNode returns true if n begins quoted history.
+func isQuoteNode(n *html.Node) bool {
+	if n.Type == html.TextNode {
+		return originalMessageRegexp.MatchString(strings.TrimSpace(n.Data))
+	}
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if n.DataAtom == atom.Blockquote && strings.EqualFold(getAttr(n, "type"), "cite") {
+		return true
+	}
+	return hasClass(n, quoteClasses) || hasID(n, quoteIDs)
+}
+
+// attributionBefore returns the sibling preceding a quote node when it holds an "On ... wrote:"
+// attribution, otherwise n itself.
+func attributionBefore(n *html.Node) *html.Node {
+	for p := n.PrevSibling; p != nil; p = p.PrevSibling {
+		if p.Type == html.ElementNode && p.DataAtom == atom.Br {
+			continue
+		}
+		text := strings.Join(strings.Fields(textContent(p)), " ")
+		if text == "" {
+			continue
+		}
+		if attributionRegexp.MatchString(text) {
+			return p
+		}
+		break
+	}
+	return n
+}
+
+// liftMarker returns the outermost element within body that begins with the marker n, so that
+// the section boundary does not leave empty elements behind.
+func liftMarker(n, body *html.Node) *html.Node {
+	for n.Parent != nil && n.Parent != body {
+		for p := n.PrevSibling; p != nil; p = p.PrevSibling {
+			if p.Type != html.CommentNode && strings.TrimSpace(textContent(p)) != "" {
+				return n
+			}
+			if p.Type == html.ElementNode && p.DataAtom == atom.Img {
+				return n
+			}
+		}
+		n = n.Parent
+	}
+	return n
+}
+
+// isSignatureNode returns true if n begins a signature.
+func isSignatureNode(n *html.Node) bool {
+	if n.Type == html.TextNode {
+		return strings.TrimSpace(n.Data) == "--"
+	}
+	if n.Type != html.ElementNode {
+		return false
+	}
+	return hasClass(n, signatureClasses) || hasID(n, signatureIDs) ||
+		getAttr(n, "data-smartmail") == "gmail_signature"
+}
+
+// copySection returns a copy of doc retaining the nodes outside of body, and the nodes within
+// body numbered from up to, but not including, to.  Elements enclosing retained nodes are also
+// retained.
+func copySection(doc, body *html.Node, order map[*html.Node]int, from, to int) *html.Node {
+	var cp func(n *html.Node, inBody bool) *html.Node
+	cp = func(n *html.Node, inBody bool) *html.Node {
+		c := &html.Node{
+			Type:      n.Type,
+			DataAtom:  n.DataAtom,
+			Data:      n.Data,
+			Namespace: n.Namespace,
+			Attr:      append([]html.Attribute(nil), n.Attr...),
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			childInBody := inBody || n == body
+			if childInBody && !inSection(child, order, from, to) {
+				continue
+			}
+			c.AppendChild(cp(child, childInBody))
+		}
+		return c
+	}
+	return cp(doc, false)
+}
+
+// inSection returns true if n or any of its descendants is numbered within [from, to).
+func inSection(n *html.Node, order map[*html.Node]int, from, to int) bool {
+	if i := order[n]; i >= to {
+		return false
+	} else if i >= from {
+		return true
+	}
+	last := n
+	for last.LastChild != nil {
+		last = last.LastChild
+	}
+	return order[last] >= from
+}
+
+// findElement returns the first element of type a within n.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// textContent returns the concatenated text within n.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	sb := &strings.Builder{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+// hasClass returns true if n has any of the listed classes.
+func hasClass(n *html.Node, classes []string) bool {
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		for _, want := range classes {
+			if c == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasID returns true if the id of n is any of the listed IDs.
+func hasID(n *html.Node, ids []string) bool {
+	id := getAttr(n, "id")
+	for _, want := range ids {
+		if id == want {
+			return true
+		}
+	}
+	return false
+}
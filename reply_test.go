@@ -0,0 +1,199 @@
+package enmime_test
+
+import (
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyText(t *testing.T) {
+	tcases := []struct {
+		name, input                string
+		content, quoted, signature string
+	}{
+		{
+			name:    "plain",
+			input:   "Hello\n\nNo history here.\n",
+			content: "Hello\n\nNo history here.\n",
+		},
+		{
+			name: "top posted",
+			input: "Sounds good.\n\n" +
+				"On Mon, Jan 1, 2024 at 10:00 AM Bob <bob@example.com> wrote:\n" +
+				"> Shall we meet?\n" +
+				">\n" +
+				"> Bob\n",
+			content: "Sounds good.",
+			quoted: "On Mon, Jan 1, 2024 at 10:00 AM Bob <bob@example.com> wrote:\n" +
+				"> Shall we meet?\n>\n> Bob",
+		},
+		{
+			name: "wrapped attribution",
+			input: "Yes.\n\n" +
+				"On Mon, Jan 1, 2024 at 10:00 AM Bob Example <\n" +
+				"bob@example.com> wrote:\n" +
+				"> Shall we meet?\n",
+			content: "Yes.",
+			quoted: "On Mon, Jan 1, 2024 at 10:00 AM Bob Example <\n" +
+				"bob@example.com> wrote:\n> Shall we meet?",
+		},
+		{
+			name:    "german attribution",
+			input:   "Ja.\n\nAm 01.01.2024 um 10:00 schrieb Bob <bob@example.com>:\n> Treffen?\n",
+			content: "Ja.",
+			quoted:  "Am 01.01.2024 um 10:00 schrieb Bob <bob@example.com>:\n> Treffen?",
+		},
+		{
+			name:    "interleaved",
+			input:   "> First question?\n\nFirst answer.\n\n> Second question?\n\nSecond answer.\n",
+			content: "First answer.\n\nSecond answer.",
+			quoted:  "> First question?\n\n> Second question?",
+		},
+		{
+			name: "signature and quote",
+			input: "Thanks for the update.\n\n-- \nAlice\nExample Corp\n\n" +
+				"On Tue, 2 Jan 2024, Bob wrote:\n> Update attached.\n",
+			content:   "Thanks for the update.",
+			quoted:    "On Tue, 2 Jan 2024, Bob wrote:\n> Update attached.",
+			signature: "Alice\nExample Corp",
+		},
+		{
+			name: "outlook original message",
+			input: "Approved.\r\n\r\n-----Original Message-----\r\nFrom: Bob\r\n" +
+				"Sent: Monday, January 1, 2024 10:00 AM\r\nSubject: Request\r\n\r\nPlease approve.\r\n",
+			content: "Approved.\r",
+			quoted: "-----Original Message-----\r\nFrom: Bob\r\n" +
+				"Sent: Monday, January 1, 2024 10:00 AM\r\nSubject: Request\r\n\r\nPlease approve.\r",
+		},
+		{
+			name: "outlook header block",
+			input: "Done.\n\n________________________________\n" +
+				"From: Bob <bob@example.com>\nSent: Monday\nTo: Alice\nSubject: Task\n\nPlease do it.\n",
+			content: "Done.",
+			quoted: "________________________________\n" +
+				"From: Bob <bob@example.com>\nSent: Monday\nTo: Alice\nSubject: Task\n\nPlease do it.",
+		},
+		{
+			name: "outlook header block without separator",
+			input: "Done.\n\n*From:* Bob <bob@example.com>\n*Sent:* Monday\n*Subject:* Task\n\n" +
+				"Please do it.\n",
+			content: "Done.",
+			quoted: "*From:* Bob <bob@example.com>\n*Sent:* Monday\n*Subject:* Task\n\n" +
+				"Please do it.",
+		},
+		{
+			name:      "closing heuristic",
+			input:     "See you then.\n\nBest regards,\nAlice Smith\nExample Corp\n",
+			content:   "See you then.\n\nBest regards,",
+			signature: "Alice Smith\nExample Corp",
+		},
+		{
+			name:      "mobile footer",
+			input:     "On my way.\n\nSent from my iPhone\n",
+			content:   "On my way.",
+			signature: "Sent from my iPhone",
+		},
+		{
+			name: "closing with long lines",
+			input: "Thanks,\n" +
+				"this line is far too long to be part of a signature block, so it is content\n",
+			content: "Thanks,\n" +
+				"this line is far too long to be part of a signature block, so it is content\n",
+		},
+		{
+			name:    "from in content",
+			input:   "From: the team\nWe made it.\n",
+			content: "From: the team\nWe made it.\n",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &enmime.Envelope{Text: tc.input}
+			r, err := e.Reply()
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, r.Text, "content")
+			assert.Equal(t, tc.quoted, r.TextQuoted, "quoted")
+			assert.Equal(t, tc.signature, r.TextSignature, "signature")
+		})
+	}
+}
+
+func TestReplyHTML(t *testing.T) {
+	const (
+		head = "<html><head><style>p {}</style></head><body>"
+		tail = "</body></html>"
+	)
+	tcases := []struct {
+		name, input                string
+		content, quoted, signature string
+	}{
+		{
+			name:    "plain",
+			input:   "<p>Hello</p>",
+			content: "<p>Hello</p>",
+		},
+		{
+			name: "gmail",
+			input: head + `<div dir="ltr">Sounds good.<br clear="all"><div><br></div>-- <br>` +
+				`<div dir="ltr" class="gmail_signature">Alice</div></div><br>` +
+				`<div class="gmail_quote"><div class="gmail_attr">On Mon, Bob wrote:<br></div>` +
+				`<blockquote class="gmail_quote">Shall we meet?</blockquote></div>` + tail,
+			content: head + `<div dir="ltr">Sounds good.<br clear="all"/><div><br/></div></div>` + tail,
+			signature: head + `<div dir="ltr">-- <br/>` +
+				`<div dir="ltr" class="gmail_signature">Alice</div></div><br/>` + tail,
+			quoted: head + `<div class="gmail_quote"><div class="gmail_attr">On Mon, Bob wrote:<br/></div>` +
+				`<blockquote class="gmail_quote">Shall we meet?</blockquote></div>` + tail,
+		},
+		{
+			name: "thunderbird",
+			input: head + `<p>Yes.</p><div class="moz-signature">Alice</div>` +
+				`<div class="moz-cite-prefix">On 1/1/24 Bob wrote:<br></div>` +
+				`<blockquote type="cite">Meet?</blockquote>` + tail,
+			content:   head + `<p>Yes.</p>` + tail,
+			signature: head + `<div class="moz-signature">Alice</div>` + tail,
+			quoted: head + `<div class="moz-cite-prefix">On 1/1/24 Bob wrote:<br/></div>` +
+				`<blockquote type="cite">Meet?</blockquote>` + tail,
+		},
+		{
+			name: "attribution before cite",
+			input: head + `<p>Yes.</p><div>On Mon, Jan 1, 2024, Bob wrote:</div><br>` +
+				`<blockquote type="cite">Meet?</blockquote>` + tail,
+			content: head + `<p>Yes.</p>` + tail,
+			quoted: head + `<div>On Mon, Jan 1, 2024, Bob wrote:</div><br/>` +
+				`<blockquote type="cite">Meet?</blockquote>` + tail,
+		},
+		{
+			name: "outlook",
+			input: head + `<div id="content"><p>Approved.</p></div><hr>` +
+				`<div id="divRplyFwdMsg"><b>From:</b> Bob</div><div>Please approve.</div>` + tail,
+			content: head + `<div id="content"><p>Approved.</p></div><hr/>` + tail,
+			quoted: head + `<div id="divRplyFwdMsg"><b>From:</b> Bob</div>` +
+				`<div>Please approve.</div>` + tail,
+		},
+		{
+			name: "original message",
+			input: head + `<div><p>Approved.</p><p>-----Original Message-----<br>From: Bob</p>` +
+				`</div>` + tail,
+			content: head + `<div><p>Approved.</p></div>` + tail,
+			quoted:  head + `<div><p>-----Original Message-----<br/>From: Bob</p></div>` + tail,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &enmime.Envelope{HTML: tc.input}
+			r, err := e.Reply()
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, r.HTML, "content")
+			assert.Equal(t, tc.quoted, r.HTMLQuoted, "quoted")
+			assert.Equal(t, tc.signature, r.HTMLSignature, "signature")
+		})
+	}
+}
+
+func TestReplyEmpty(t *testing.T) {
+	r, err := (&enmime.Envelope{}).Reply()
+	require.NoError(t, err)
+	assert.Equal(t, &enmime.ReplySections{}, r)
+}
@@ -0,0 +1,588 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	_utf8 "unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SanitizeOption configures HTML sanitization.
+type SanitizeOption interface {
+	apply(s *sanitizer)
+}
+
+// CIDURLFunc returns the URL used to reference an inline part from sanitized HTML.  Returning an
+// empty string removes the reference.
+type CIDURLFunc func(p *Part) string
+
+type cidURLOption CIDURLFunc
+
+func (o cidURLOption) apply(s *sanitizer) {
+	s.cidURL = CIDURLFunc(o)
+}
+
+// RewriteCID sets the function used to rewrite cid: references, for example to a URL served by
+// the caller.  By default, referenced parts are embedded as data: URIs.
+func RewriteCID(f CIDURLFunc) SanitizeOption {
+	return cidURLOption(f)
+}
+
+type blockRemoteImagesOption bool
+
+func (o blockRemoteImagesOption) apply(s *sanitizer) {
+	s.blockRemote = bool(o)
+}
+
+// BlockRemoteImages sets the blockRemoteImages option.  When true, images, CSS backgrounds and
+// fonts loaded from remote servers are removed, preventing the sender from learning when the
+// message was read.
+func BlockRemoteImages(block bool) SanitizeOption {
+	return blockRemoteImagesOption(block)
+}
+
+type blockTrackingPixelsOption bool
+
+func (o blockTrackingPixelsOption) apply(s *sanitizer) {
+	s.blockTracking = bool(o)
+}
+
+// BlockTrackingPixels sets the blockTrackingPixels option.  When true, remote images that are
+// hidden or no larger than 1x1 pixels are removed, while other remote images are kept.
+func BlockTrackingPixels(block bool) SanitizeOption {
+	return blockTrackingPixelsOption(block)
+}
+
+// SanitizedHTML returns Envelope.HTML with scripts, event handlers, dangerous URLs and dangerous
+// CSS removed, so that it may be displayed in a browser.  cid: references are resolved against
+// the ContentID of Inlines and OtherParts.  Returns an empty string if there is no HTML body.
+func (e *Envelope) SanitizedHTML(opts ...SanitizeOption) (string, error) {
+	if e.HTML == "" {
+		return "", nil
+	}
+	s := &sanitizer{cids: make(map[string]*Part)}
+	for _, parts := range [][]*Part{e.OtherParts, e.Inlines} {
+		for _, p := range parts {
+			if p.ContentID != "" {
+				s.cids[p.ContentID] = p
+			}
+		}
+	}
+	for _, o := range opts {
+		o.apply(s)
+	}
+	if s.cidURL == nil {
+		s.cidURL = dataURI
+	}
+
+	doc, err := html.Parse(strings.NewReader(e.HTML))
+	if err != nil {
+		return "", err
+	}
+	s.sanitize(doc)
+	buf := &bytes.Buffer{}
+	if err := html.Render(buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// dataURI embeds the content of p into a data: URI.
+func dataURI(p *Part) string {
+	ctype := p.ContentType
+	if ctype == "" {
+		ctype = ctAppOctetStream
+	}
+	return "data:" + ctype + ";base64," + base64.StdEncoding.EncodeToString(p.Content)
+}
+
+// Elements removed along with their content.
+var sanitizeDropElements = map[atom.Atom]bool{
+	atom.Applet:   true,
+	atom.Base:     true,
+	atom.Embed:    true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Iframe:   true,
+	atom.Link:     true,
+	atom.Math:     true,
+	atom.Meta:     true,
+	atom.Object:   true,
+	atom.Script:   true,
+	atom.Svg:      true,
+	atom.Template: true,
+}
+
+// Elements replaced by their children, as they allow the message to submit data.
+var sanitizeUnwrapElements = map[atom.Atom]bool{
+	atom.Button:   true,
+	atom.Form:     true,
+	atom.Input:    true,
+	atom.Select:   true,
+	atom.Textarea: true,
+}
+
+// Attributes containing URLs.
+var sanitizeURLAttrs = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"dynsrc":     true,
+	"formaction": true,
+	"href":       true,
+	"longdesc":   true,
+	"lowsrc":     true,
+	"poster":     true,
+	"src":        true,
+	"xlink:href": true,
+}
+
+// Attributes removed regardless of value.
+var sanitizeDropAttrs = map[string]bool{
+	"http-equiv": true,
+	"srcdoc":     true,
+	"srcset":     true,
+}
+
+var (
+	cssCommentRegexp = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssEscapeRegexp  = regexp.MustCompile(`\\([0-9a-fA-F]{1,6}\s?|.)`)
+	cssFuncRegexp    = regexp.MustCompile(`(?i)(-?[a-z_][\w-]*)\(`)
+	cssPixelRegexp   = regexp.MustCompile(`^\s*([0-9.]+)\s*(px)?\s*$`)
+	// cssDangerousRegexp matches CSS with whitespace removed.
+	cssDangerousRegexp = regexp.MustCompile(
+		`(?i)expression\(|javascript:|vbscript:|(^|[;{])behavior:|-moz-binding|@import`)
+)
+
+// sanitizer holds the configuration for a single sanitization pass.
+type sanitizer struct {
+	cids          map[string]*Part
+	cidURL        CIDURLFunc
+	blockRemote   bool
+	blockTracking bool
+}
+
+// sanitize cleans n and its descendants in place.
+func (s *sanitizer) sanitize(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.CommentNode:
+			// Comments may hide conditional markup for old versions of Internet Explorer.
+			n.RemoveChild(c)
+		case html.ElementNode:
+			switch {
+			case sanitizeDropElements[c.DataAtom] || c.Namespace != "":
+				n.RemoveChild(c)
+			case sanitizeUnwrapElements[c.DataAtom]:
+				s.sanitize(c)
+				for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+				}
+				n.RemoveChild(c)
+			case c.DataAtom == atom.Style:
+				if !s.sanitizeStyleElement(c) {
+					n.RemoveChild(c)
+				}
+			default:
+				s.sanitizeAttrs(c)
+				if c.DataAtom == atom.Img && s.isBlockedImage(c) {
+					n.RemoveChild(c)
+					break
+				}
+				s.sanitize(c)
+			}
+		default:
+			s.sanitize(c)
+		}
+		c = next
+	}
+}
+
+// sanitizeAttrs removes event handlers and unsafe attribute values from n.
+func (s *sanitizer) sanitizeAttrs(n *html.Node) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" {
+			key = a.Namespace + ":" + key
+		}
+		switch {
+		case strings.HasPrefix(key, "on") || sanitizeDropAttrs[key]:
+			continue
+		case key == "style":
+			a.Val = s.sanitizeCSS(a.Val, true)
+			if a.Val == "" {
+				continue
+			}
+		case sanitizeURLAttrs[key]:
+			image := key != "href" && key != "cite" && key != "longdesc"
+			a.Val = s.sanitizeURL(a.Val, image)
+			if a.Val == "" {
+				continue
+			}
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+	if n.DataAtom == atom.A && getAttr(n, "href") != "" {
+		setAttr(n, "rel", "noopener noreferrer")
+	}
+}
+
+// sanitizeStyleElement cleans the CSS within a style element, returning false if the element
+// should be removed.
+func (s *sanitizer) sanitizeStyleElement(n *html.Node) bool {
+	buf := &strings.Builder{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.TextNode {
+			return false
+		}
+		buf.WriteString(c.Data)
+	}
+	css := s.sanitizeCSS(buf.String(), false)
+	if css == "" {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = n.FirstChild {
+		n.RemoveChild(c)
+	}
+	n.Attr = nil
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+	return true
+}
+
+// sanitizeCSS rewrites URLs within css and removes dangerous constructs.  Inline styles are
+// filtered per declaration, style sheets are discarded entirely if they contain a dangerous
+// construct.
+func (s *sanitizer) sanitizeCSS(css string, inline bool) string {
+	css = cssCommentRegexp.ReplaceAllString(css, "")
+	css = decodeCSSEscapes(css, false)
+	if !inline {
+		if cssIsDangerous(css) {
+			return ""
+		}
+		return s.rewriteCSSURLs(css)
+	}
+	decls := splitCSSDeclarations(css)
+	kept := decls[:0]
+	for _, d := range decls {
+		if strings.TrimSpace(d) == "" || cssIsDangerous(d) {
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(s.rewriteCSSURLs(d)))
+	}
+	return strings.Join(kept, "; ")
+}
+
+// Functions taking image URLs as url() or plain strings.
+var cssImageFuncs = map[string]bool{
+	"image":              true,
+	"image-set":          true,
+	"-webkit-image-set":  true,
+	"cross-fade":         true,
+	"-webkit-cross-fade": true,
+}
+
+// rewriteCSSURLs applies URL sanitization to each URL referenced by a function within css.
+// Functions that load an image, font, or other resource are replaced with none when a URL they
+// reference is removed, or when they cannot be interpreted.
+func (s *sanitizer) rewriteCSSURLs(css string) string {
+	css, _ = s.rewriteCSSFuncs(css)
+	return css
+}
+
+// rewriteCSSFuncs rewrites the functions within css as rewriteCSSURLs does, reporting whether any
+// URLs were removed.
+func (s *sanitizer) rewriteCSSFuncs(css string) (string, bool) {
+	out := &strings.Builder{}
+	dropped := false
+	for {
+		loc := cssFuncRegexp.FindStringSubmatchIndex(css)
+		if loc == nil {
+			out.WriteString(css)
+			return out.String(), dropped
+		}
+		end := cssFuncEnd(css, loc[1])
+		f, d := s.rewriteCSSFunc(css[loc[2]:loc[3]], css[loc[1]:end])
+		out.WriteString(css[:loc[0]])
+		out.WriteString(f)
+		dropped = dropped || d
+		css = css[min(end+1, len(css)):]
+	}
+}
+
+// rewriteCSSFunc returns a sanitized replacement for the function name(args), reporting whether
+// a URL was removed.
+func (s *sanitizer) rewriteCSSFunc(name, args string) (string, bool) {
+	lname := strings.ToLower(name)
+	switch {
+	case lname == "url" || lname == "src":
+		u := s.sanitizeURL(cssUnquote(args), true)
+		if u == "" {
+			return "none", true
+		}
+		return `url("` + strings.ReplaceAll(u, `"`, `%22`) + `")`, false
+	case cssImageFuncs[lname]:
+		args, dropped := s.rewriteCSSImageArgs(args)
+		if dropped {
+			return "none", true
+		}
+		return name + "(" + args + ")", false
+	case strings.Contains(lname, "url") || strings.Contains(lname, "image"):
+		// An unknown function which may load a resource.
+		return "none", true
+	}
+	args, dropped := s.rewriteCSSFuncs(args)
+	return name + "(" + args + ")", dropped
+}
+
+// rewriteCSSImageArgs sanitizes the URLs given as strings or url() to an image function such as
+// image-set, reporting whether any were removed.
+func (s *sanitizer) rewriteCSSImageArgs(args string) (string, bool) {
+	out := &strings.Builder{}
+	dropped := false
+	depth := 0
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case (c == '"' || c == '\'') && depth == 0:
+			n := strings.IndexByte(args[i+1:], c)
+			if n < 0 {
+				n = len(args) - i - 1
+			}
+			u := s.sanitizeURL(args[i+1:i+1+n], true)
+			if u == "" {
+				dropped = true
+			}
+			out.WriteString(`"` + strings.ReplaceAll(u, `"`, `%22`) + `"`)
+			i += n + 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	rewritten, d := s.rewriteCSSFuncs(out.String())
+	return rewritten, dropped || d
+}
+
+// cssFuncEnd returns the index of the parenthesis closing the function arguments starting at
+// start, or len(css) if they are unterminated.
+func cssFuncEnd(css string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(css)
+}
+
+// cssUnquote returns the argument of a url() function, with whitespace and quotes removed.
+func cssUnquote(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
+
+// cssIsDangerous reports whether css contains constructs capable of running script or loading
+// external style sheets, after CSS escapes and whitespace are removed.
+func cssIsDangerous(css string) bool {
+	css = decodeCSSEscapes(css, true)
+	return cssDangerousRegexp.MatchString(strings.Join(strings.Fields(css), ""))
+}
+
+// decodeCSSEscapes replaces CSS escapes in css with the characters they represent.  Unless all is
+// true, only escaped letters, digits, hyphens and underscores are decoded, as other characters
+// may change the structure of the CSS, or terminate a style element.
+func decodeCSSEscapes(css string, all bool) string {
+	return cssEscapeRegexp.ReplaceAllStringFunc(css, func(m string) string {
+		esc := strings.TrimSpace(m[1:])
+		if esc == "" {
+			// An escaped whitespace character.
+			return m
+		}
+		r, _ := _utf8.DecodeRuneInString(esc)
+		if isHex(esc) {
+			v, err := strconv.ParseUint(esc, 16, 32)
+			if err != nil {
+				return m
+			}
+			r = rune(v)
+		}
+		if all || r < _utf8.RuneSelf && (r == '-' || r == '_' || unicode.IsLetter(r) ||
+			unicode.IsDigit(r)) {
+			return string(r)
+		}
+		return m
+	})
+}
+
+// sanitizeURL returns a safe replacement for u, or an empty string if it must be removed.  image
+// indicates the URL will be loaded automatically by the browser.
+func (s *sanitizer) sanitizeURL(u string, image bool) string {
+	trimmed := strings.TrimSpace(u)
+	// Browsers ignore embedded whitespace and control characters within the scheme.
+	scheme := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, trimmed))
+	switch {
+	case strings.HasPrefix(scheme, "cid:"):
+		cid := strings.TrimSpace(trimmed[strings.Index(strings.ToLower(trimmed), "cid:")+4:])
+		if p := s.cids[cid]; p != nil {
+			return s.cidURL(p)
+		}
+		return ""
+	case strings.HasPrefix(scheme, "http:"), strings.HasPrefix(scheme, "https:"),
+		strings.HasPrefix(scheme, "//"):
+		if image && s.blockRemote {
+			return ""
+		}
+		return trimmed
+	case strings.HasPrefix(scheme, "data:image/"):
+		if image && !strings.HasPrefix(scheme, "data:image/svg") {
+			return trimmed
+		}
+		return ""
+	case strings.HasPrefix(scheme, "mailto:"), strings.HasPrefix(scheme, "tel:"):
+		if image {
+			return ""
+		}
+		return trimmed
+	case strings.HasPrefix(scheme, "#"):
+		return trimmed
+	}
+	return ""
+}
+
+// isBlockedImage reports whether the img element n should be removed entirely.
+func (s *sanitizer) isBlockedImage(n *html.Node) bool {
+	src := getAttr(n, "src")
+	if src == "" {
+		// Removed by URL sanitization, or never present.
+		return s.blockRemote
+	}
+	if !s.blockTracking || strings.HasPrefix(src, "data:") {
+		return false
+	}
+	if getAttr(n, "hidden") != "" {
+		return true
+	}
+	width, height := getAttr(n, "width"), getAttr(n, "height")
+	for _, decl := range strings.Split(getAttr(n, "style"), ";") {
+		k, v, _ := strings.Cut(decl, ":")
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "width":
+			width = v
+		case "height":
+			height = v
+		case "display":
+			if strings.EqualFold(strings.TrimSpace(v), "none") {
+				return true
+			}
+		case "visibility":
+			if strings.EqualFold(strings.TrimSpace(v), "hidden") {
+				return true
+			}
+		}
+	}
+	return isTinyDimension(width) && isTinyDimension(height)
+}
+
+// isTinyDimension reports whether v is a dimension of at most one pixel.
+func isTinyDimension(v string) bool {
+	m := cssPixelRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	return err == nil && f <= 1
+}
+
+// splitCSSDeclarations splits css on semicolons outside of quotes and parentheses, which may
+// appear within data: URIs.
+func splitCSSDeclarations(css string) []string {
+	var decls []string
+	depth := 0
+	var quote rune
+	start := 0
+	for i, r := range css {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case r == ';' && depth == 0:
+			decls = append(decls, css[start:i])
+			start = i + 1
+		}
+	}
+	return append(decls, css[start:])
+}
+
+// isHex reports whether s is a non-empty hexadecimal string.
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,219 @@
+package enmime_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sanitize(t *testing.T, e *enmime.Envelope, opts ...enmime.SanitizeOption) string {
+	t.Helper()
+	got, err := e.SanitizedHTML(opts...)
+	require.NoError(t, err)
+	// Trim the document wrapper added by the HTML parser.
+	got = strings.TrimPrefix(got, "<html><head></head><body>")
+	return strings.TrimSuffix(got, "</body></html>")
+}
+
+func TestSanitizedHTML(t *testing.T) {
+	tcases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "script",
+			input: `<p>hi</p><script>alert(1)</script>`,
+			want:  `<p>hi</p>`,
+		},
+		{
+			name:  "event handler",
+			input: `<p onclick="alert(1)" ONMOUSEOVER="x()" class="c">hi</p>`,
+			want:  `<p class="c">hi</p>`,
+		},
+		{
+			name:  "javascript url",
+			input: `<a href=" java&#x09;script:alert(1)">x</a><a href="https://example.com/">y</a>`,
+			want:  `<a>x</a><a href="https://example.com/" rel="noopener noreferrer">y</a>`,
+		},
+		{
+			name:  "frames and objects",
+			input: `<iframe src="https://example.com"></iframe><object data="x"></object><p>ok</p>`,
+			want:  `<p>ok</p>`,
+		},
+		{
+			name:  "form unwrapped",
+			input: `<form action="https://evil.example.com"><b>text</b><input name="pw"></form>`,
+			want:  `<b>text</b>`,
+		},
+		{
+			name:  "comments",
+			input: `<!--[if IE]><script>x</script><![endif]--><p>ok</p>`,
+			want:  `<p>ok</p>`,
+		},
+		{
+			name:  "dangerous inline css",
+			input: `<p style="color: red; width: expression(alert(1)); background: url(javascript:x)">x</p>`,
+			want:  `<p style="color: red">x</p>`,
+		},
+		{
+			name:  "unsupported css url",
+			input: `<p style="background: url(ftp://example.com/x.png) repeat">x</p>`,
+			want:  `<p style="background: none repeat">x</p>`,
+		},
+		{
+			name:  "escaped inline css",
+			input: `<p style="width: expr\65 ssion(alert(1)); color: blue">x</p>`,
+			want:  `<p style="color: blue">x</p>`,
+		},
+		{
+			name:  "dangerous style sheet",
+			input: `<style>@import url(https://evil.example.com/x.css);</style><p>x</p>`,
+			want:  `<p>x</p>`,
+		},
+		{
+			name:  "safe style sheet",
+			input: `<style>p { color: red; scroll-behavior: smooth; }</style><p>x</p>`,
+			want: `<html><head><style>p { color: red; scroll-behavior: smooth; }</style></head>` +
+				`<body><p>x</p>`,
+		},
+		{
+			name:  "escaped markup in style sheet",
+			input: `<style>p::after { content: "\3c/style\3e"; color: rgb(1, 2, 3) }</style><p>x</p>`,
+			want: `<html><head><style>p::after { content: "\3c/style\3e"; color: rgb(1, 2, 3) }` +
+				`</style></head><body><p>x</p>`,
+		},
+		{
+			name:  "svg",
+			input: `<svg><script>alert(1)</script></svg><p>x</p>`,
+			want:  `<p>x</p>`,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &enmime.Envelope{HTML: tc.input}
+			assert.Equal(t, tc.want, sanitize(t, e))
+		})
+	}
+}
+
+func TestSanitizedHTMLEmpty(t *testing.T) {
+	got, err := (&enmime.Envelope{}).SanitizedHTML()
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestSanitizedHTMLCID(t *testing.T) {
+	inline := enmime.NewPart("image/png")
+	inline.ContentID = "logo@example.com"
+	inline.Content = []byte("png")
+	other := enmime.NewPart("image/gif")
+	other.ContentID = "bg@example.com"
+	other.Content = []byte("gif")
+	e := &enmime.Envelope{
+		HTML: `<img src="cid:logo@example.com"><img src="cid:missing@example.com">` +
+			`<div style="background-image: url('cid:bg@example.com')">x</div>`,
+		Inlines:    []*enmime.Part{inline},
+		OtherParts: []*enmime.Part{other},
+	}
+
+	got := sanitize(t, e)
+	assert.Equal(t, `<img src="data:image/png;base64,cG5n"/><img/>`+
+		`<div style="background-image: url(&#34;data:image/gif;base64,Z2lm&#34;)">x</div>`, got)
+
+	got = sanitize(t, e, enmime.RewriteCID(func(p *enmime.Part) string {
+		return "/parts/" + p.ContentID
+	}))
+	assert.Equal(t, `<img src="/parts/logo@example.com"/><img/>`+
+		`<div style="background-image: url(&#34;/parts/bg@example.com&#34;)">x</div>`, got)
+}
+
+func TestSanitizedHTMLRemoteImages(t *testing.T) {
+	e := &enmime.Envelope{
+		HTML: `<img src="https://example.com/photo.jpg" alt="photo">` +
+			`<img src="https://t.example.com/open.gif" width="1" height="1">` +
+			`<img src="https://t.example.com/hidden.gif" style="display: none">` +
+			`<td background="http://example.com/bg.png">x</td>` +
+			`<a href="https://example.com/">link</a>`,
+	}
+
+	got := sanitize(t, e)
+	assert.Contains(t, got, "photo.jpg")
+	assert.Contains(t, got, "open.gif")
+	assert.Contains(t, got, "hidden.gif")
+
+	got = sanitize(t, e, enmime.BlockTrackingPixels(true))
+	assert.Contains(t, got, "photo.jpg")
+	assert.NotContains(t, got, "open.gif")
+	assert.NotContains(t, got, "hidden.gif")
+
+	got = sanitize(t, e, enmime.BlockRemoteImages(true))
+	assert.NotContains(t, got, "<img")
+	assert.NotContains(t, got, "bg.png")
+	assert.Contains(t, got, `href="https://example.com/"`)
+}
+
+func TestSanitizedHTMLRemoteCSS(t *testing.T) {
+	tcases := []struct {
+		name  string
+		input string
+		want  string // With remote images blocked.
+	}{
+		{
+			name:  "image-set",
+			input: `<p style="background-image:image-set('https://t.example.com/x.png' 1x)">x</p>`,
+			want:  `<p style="background-image:none">x</p>`,
+		},
+		{
+			name:  "webkit image-set url",
+			input: `<p style="background:-webkit-image-set(url(https://t.example.com/x.png) 1x)">x</p>`,
+			want:  `<p style="background:none">x</p>`,
+		},
+		{
+			name:  "escaped url in style attribute",
+			input: `<p style="background:\75rl(https://t.example.com/x.png)">x</p>`,
+			want:  `<p style="background:none">x</p>`,
+		},
+		{
+			name:  "escaped url in style element",
+			input: `<style>p{background:\000075rl("https://t.example.com/x.png")}</style><p>x</p>`,
+			want:  `<html><head><style>p{background:none}</style></head><body><p>x</p>`,
+		},
+		{
+			name: "font-face",
+			input: `<style>@font-face{font-family:f;src:url(https://t.example.com/f.woff) ` +
+				`format("woff")}</style><p>x</p>`,
+			want: `<html><head><style>@font-face{font-family:f;src:none format("woff")}</style>` +
+				`</head><body><p>x</p>`,
+		},
+		{
+			name:  "escaped import",
+			input: `<style>@\69mport "https://t.example.com/x.css";</style><p>x</p>`,
+			want:  `<p>x</p>`,
+		},
+		{
+			name:  "unknown url function",
+			input: `<p style="background:-x-url(https://t.example.com/x.png); color:red">x</p>`,
+			want:  `<p style="background:none; color:red">x</p>`,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &enmime.Envelope{HTML: tc.input}
+			assert.Equal(t, tc.want, sanitize(t, e, enmime.BlockRemoteImages(true)))
+		})
+	}
+
+	// Resolvable references are kept when remote images are allowed.
+	e := &enmime.Envelope{
+		HTML: `<p style="background:image-set('https://example.com/a.png' 1x, ` +
+			`url(https://example.com/b.png) 2x)">x</p>`,
+	}
+	assert.Equal(t, `<p style="background:image-set(&#34;https://example.com/a.png&#34; 1x, `+
+		`url(&#34;https://example.com/b.png&#34;) 2x)">x</p>`, sanitize(t, e))
+	e.HTML = `<p style="background:image-set('cid:missing@example.com' 1x)">x</p>`
+	assert.Equal(t, `<p style="background:none">x</p>`, sanitize(t, e))
+}
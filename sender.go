@@ -1,6 +1,17 @@
 package enmime
 
-import "net/smtp"
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/jhillyerd/enmime/v2/internal/stringutil"
+	"github.com/pkg/errors"
+)
 
 // Sender provides a method for enmime to send an email.
 type Sender interface {
@@ -13,21 +24,293 @@ type Sender interface {
 	Send(reversePath string, recipients []string, msg []byte) error
 }
 
-// SMTPSender is a Sender backed by Go's built-in net/smtp.SendMail function.
+// PartSender is implemented by Senders that encode messages themselves, for example to choose
+// transfer encodings supported by the mail server.  MailBuilder.Send uses SendPart in preference
+// to Send when it is available.
+type PartSender interface {
+	Sender
+
+	// SendPart encodes root and sends it as Send does.
+	SendPart(reversePath string, recipients []string, root *Part) error
+}
+
+// ContextSender is implemented by Senders supporting cancellation, and reporting the outcome of
+// delivery to each recipient.
+type ContextSender interface {
+	Sender
+
+	// SendContext sends msg as Send does, aborting when ctx is done.  An error is returned when the
+	// message could not be sent to any recipient; rejected recipients are otherwise listed in the
+	// result.
+	SendContext(ctx context.Context, reversePath string, recipients []string, msg []byte) (
+		*SendResult, error)
+}
+
+// SendResult reports the outcome of sending a message.
+type SendResult struct {
+	Accepted []RecipientResult // Recipients accepted by the server.
+	Rejected []RecipientResult // Recipients rejected by the server.
+	Code     int               // Reply code of the server to the message content.
+	Message  string            // Reply text of the server, often including a queue ID.
+}
+
+// RecipientResult is the reply of the server to a single recipient.
+type RecipientResult struct {
+	Recipient string
+	Code      int
+	Message   string
+}
+
+// Err returns a *RecipientError listing the rejected recipients, or nil if there were none.
+func (r *SendResult) Err() error {
+	if r == nil || len(r.Rejected) == 0 {
+		return nil
+	}
+	return &RecipientError{Rejected: r.Rejected}
+}
+
+// RecipientError reports recipients rejected by the server.  The message may have been
+// delivered to other recipients.
+type RecipientError struct {
+	Rejected []RecipientResult
+}
+
+// Error implements error.
+func (e *RecipientError) Error() string {
+	msgs := make([]string, len(e.Rejected))
+	for i, r := range e.Rejected {
+		msgs[i] = fmt.Sprintf("%s: %d %s", r.Recipient, r.Code, r.Message)
+	}
+	return "smtp: recipients rejected: " + strings.Join(msgs, "; ")
+}
+
+// Temporary returns true if all of the recipients were rejected with temporary, 4xx, failures,
+// and so may be retried later.
+func (e *RecipientError) Temporary() bool {
+	for _, r := range e.Rejected {
+		if r.Code < 400 || r.Code >= 500 {
+			return false
+		}
+	}
+	return len(e.Rejected) > 0
+}
+
+// SMTPSender is a Sender backed by Go's built-in net/smtp package.
 type SMTPSender struct {
 	addr string
 	auth smtp.Auth
 }
 
-var _ Sender = &SMTPSender{}
+var _ PartSender = &SMTPSender{}
 
-// NewSMTP creates a new SMTPSender, which uses net/smtp.SendMail, and accepts the same
-// authentication parameters.  If no authentication is required, `auth` may be nil.
+// NewSMTP creates a new SMTPSender, which uses net/smtp, and accepts the same authentication
+// parameters as net/smtp.SendMail.  If no authentication is required, `auth` may be nil.
 func NewSMTP(addr string, auth smtp.Auth) *SMTPSender {
 	return &SMTPSender{addr, auth}
 }
 
-// Send a message using net/smtp.SendMail.
+// Send a message using net/smtp, in the manner of net/smtp.SendMail.  Addresses may contain UTF-8
+// local parts and IDN domains.  When the server advertises SMTPUTF8, addresses are sent as is
+// with the SMTPUTF8 parameter; otherwise IDN domains are converted to punycode, and addresses
+// with UTF-8 local parts are rejected.
 func (s *SMTPSender) Send(reversePath string, recipients []string, msg []byte) error {
-	return smtp.SendMail(s.addr, s.auth, reversePath, recipients, msg)
+	return s.send(reversePath, recipients, func(*smtp.Client) ([]byte, bool, error) {
+		return msg, false, nil
+	})
+}
+
+// SendPart encodes root and sends it as Send does, choosing the TransferEncodingPolicy from the
+// EHLO extensions of the server: BinaryPolicy if it supports BINARYMIME and CHUNKING,
+// EightBitPolicy if it supports 8BITMIME, otherwise enmime's 7bit safe default.  Other options
+// of the Encoder of root are retained.
+func (s *SMTPSender) SendPart(reversePath string, recipients []string, root *Part) error {
+	return s.send(reversePath, recipients, func(c *smtp.Client) ([]byte, bool, error) {
+		return encodeForServer(c, root)
+	})
+}
+
+// send delivers the message returned by body, which is called once connected to the server.
+// When body reports a binary message, it is sent with BODY=BINARYMIME using BDAT.
+func (s *SMTPSender) send(
+	reversePath string,
+	recipients []string,
+	body func(c *smtp.Client) (msg []byte, binary bool, err error),
+) error {
+	if err := validateLine(reversePath); err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		if err := validateLine(r); err != nil {
+			return err
+		}
+	}
+
+	c, err := smtp.Dial(s.addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		host, _, _ := net.SplitHostPort(s.addr)
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+
+	smtpUTF8, _ := c.Extension("SMTPUTF8")
+	if !smtpUTF8 {
+		if reversePath, recipients, err = asciiEnvelope(reversePath, recipients); err != nil {
+			return err
+		}
+	}
+
+	msg, binary, err := body(c)
+	if err != nil {
+		return err
+	}
+	if err := smtpMail(c, reversePath, binary, smtpUTF8); err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		if err := c.Rcpt(r); err != nil {
+			return err
+		}
+	}
+	if _, _, err := smtpData(c, msg, binary); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// encodeForServer encodes root with the TransferEncodingPolicy best supported by the EHLO
+// extensions of the server, and reports whether the result must be sent as binary.  Headers are
+// encoded without SMTPUTF8 when the server does not support it.
+func encodeForServer(c *smtp.Client, root *Part) ([]byte, bool, error) {
+	return encodeForExtensions(root, func(name string) bool {
+		ok, _ := c.Extension(name)
+		return ok
+	})
+}
+
+// encodeForExtensions encodes root as encodeForServer does, using ext to check the extensions
+// advertised by the server.
+func encodeForExtensions(root *Part, ext func(name string) bool) ([]byte, bool, error) {
+	var policy TransferEncodingPolicy
+	binary := false
+	if ext("8BITMIME") {
+		policy = EightBitPolicy
+	}
+	if ext("BINARYMIME") && ext("CHUNKING") {
+		policy, binary = BinaryPolicy, true
+	}
+
+	enc := &Encoder{}
+	if root.encoder != nil {
+		*enc = *root.encoder
+	}
+	enc.policy = policy
+	if !ext("SMTPUTF8") {
+		// Headers must be encoded for servers unable to accept raw UTF-8.
+		enc.smtpUTF8 = false
+	}
+	prev := root.encoder
+	root.encoder = enc
+	defer func() { root.encoder = prev }()
+
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), binary, nil
+}
+
+// asciiEnvelope converts the IDN domains of the reverse-path and recipients to punycode, for
+// servers without SMTPUTF8 support.  An error is returned for addresses with UTF-8 local parts.
+func asciiEnvelope(reversePath string, recipients []string) (string, []string, error) {
+	reversePath, err := stringutil.ToASCIIAddress(reversePath)
+	if err != nil {
+		return "", nil, err
+	}
+	ascii := make([]string, len(recipients))
+	for i, r := range recipients {
+		if ascii[i], err = stringutil.ToASCIIAddress(r); err != nil {
+			return "", nil, err
+		}
+	}
+	return reversePath, ascii, nil
+}
+
+// smtpMail issues the MAIL command, declaring a binary body with BODY=BINARYMIME.
+func smtpMail(c *smtp.Client, reversePath string, binary, smtpUTF8 bool) error {
+	if !binary {
+		// Mail adds the 8BITMIME and SMTPUTF8 parameters when supported by the server.
+		return c.Mail(reversePath)
+	}
+	// net/smtp has no support for BINARYMIME, so the MAIL command is issued directly.
+	params := " BODY=BINARYMIME"
+	if smtpUTF8 {
+		params += " SMTPUTF8"
+	}
+	_, _, err := smtpCmdResponse(c, 250, "MAIL FROM:<%s>%s", reversePath, params)
+	return err
+}
+
+// smtpData sends the message content with DATA, or BDAT when binary, and returns the reply of
+// the server.
+func smtpData(c *smtp.Client, msg []byte, binary bool) (int, string, error) {
+	if binary {
+		// RFC 3030: binary content must be sent with BDAT, rather than dot-stuffed DATA.
+		id, err := c.Text.Cmd("BDAT %d LAST", len(msg))
+		if err != nil {
+			return 0, "", err
+		}
+		if _, err := c.Text.W.Write(msg); err != nil {
+			return 0, "", err
+		}
+		if err := c.Text.W.Flush(); err != nil {
+			return 0, "", err
+		}
+		c.Text.StartResponse(id)
+		defer c.Text.EndResponse(id)
+		return c.Text.ReadResponse(250)
+	}
+	if _, _, err := smtpCmdResponse(c, 354, "DATA"); err != nil {
+		return 0, "", err
+	}
+	w := c.Text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return 0, "", err
+	}
+	if err := w.Close(); err != nil {
+		return 0, "", err
+	}
+	return c.Text.ReadResponse(250)
+}
+
+// smtpCmdResponse sends a command to the server, checks for the expected response code, and
+// returns the response.
+func smtpCmdResponse(c *smtp.Client, expectCode int, format string, args ...any) (int, string, error) {
+	id, err := c.Text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	return c.Text.ReadResponse(expectCode)
+}
+
+// validateLine checks that a line does not contain CR or LF, matching net/smtp.
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\n\r") {
+		return errors.New("smtp: A line must not contain CR or LF")
+	}
+	return nil
 }
@@ -1,11 +1,17 @@
 package enmime_test
 
 import (
+	"fmt"
+	"io"
+	"net"
 	"net/smtp"
+	"net/textproto"
 	"strings"
 	"testing"
 
 	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSMTPSend(t *testing.T) {
@@ -23,3 +29,163 @@ func TestSMTPSend(t *testing.T) {
 		t.Fatalf("Send() did not return expected error, failed: %s", err.Error())
 	}
 }
+
+// startSMTPServer starts a minimal SMTP server advertising the specified EHLO extensions, and
+// returns its address along with a channel receiving the commands of each session.
+func startSMTPServer(t *testing.T, extensions ...string) (string, <-chan []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	sessions := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		tp := textproto.NewConn(conn)
+		var cmds []string
+		defer func() { sessions <- cmds }()
+		_ = tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmds = append(cmds, line)
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "EHLO":
+				_ = tp.PrintfLine("250-localhost")
+				for _, ext := range extensions {
+					_ = tp.PrintfLine("250-%s", ext)
+				}
+				_ = tp.PrintfLine("250 HELP")
+			case "DATA":
+				_ = tp.PrintfLine("354 Go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				cmds = append(cmds, string(data))
+				_ = tp.PrintfLine("250 OK")
+			case "BDAT":
+				var n int
+				_, _ = fmt.Sscanf(line, "BDAT %d", &n)
+				data := make([]byte, n)
+				if _, err := io.ReadFull(tp.R, data); err != nil {
+					return
+				}
+				cmds = append(cmds, string(data))
+				_ = tp.PrintfLine("250 OK")
+			case "QUIT":
+				_ = tp.PrintfLine("221 Bye")
+				return
+			default:
+				_ = tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), sessions
+}
+
+func TestSMTPSendUTF8(t *testing.T) {
+	addr, sessions := startSMTPServer(t, "8BITMIME", "SMTPUTF8")
+	s := enmime.NewSMTP(addr, nil)
+	err := s.Send("用户@例子.广告", []string{"bob@bücher.de"}, []byte("Subject: héllo\r\n\r\nbody\r\n"))
+	require.NoError(t, err)
+
+	cmds := <-sessions
+	assert.Contains(t, cmds, "MAIL FROM:<用户@例子.广告> BODY=8BITMIME SMTPUTF8")
+	assert.Contains(t, cmds, "RCPT TO:<bob@bücher.de>")
+	assert.Contains(t, cmds, "Subject: héllo\n\nbody\n")
+}
+
+func TestSMTPSendPunycode(t *testing.T) {
+	addr, sessions := startSMTPServer(t)
+	s := enmime.NewSMTP(addr, nil)
+	err := s.Send("alice@bücher.de", []string{"bob@例子.广告"}, []byte("Subject: hi\r\n\r\nbody\r\n"))
+	require.NoError(t, err)
+
+	cmds := <-sessions
+	assert.Contains(t, cmds, "MAIL FROM:<alice@xn--bcher-kva.de>")
+	assert.Contains(t, cmds, "RCPT TO:<bob@xn--fsqu00a.xn--4rr70v>")
+
+	addr, sessions = startSMTPServer(t)
+	s = enmime.NewSMTP(addr, nil)
+	err = s.Send("alice@example.com", []string{"用户@example.com"}, []byte("\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SMTPUTF8")
+	assert.NotContains(t, strings.Join(<-sessions, "\n"), "MAIL FROM")
+}
+
+func TestSMTPSendPartWithoutSMTPUTF8(t *testing.T) {
+	// Headers of an SMTPUTF8 message are encoded for servers which do not support it.
+	b := enmime.Builder().
+		SMTPUTF8(true).
+		From("Jösé", "jose@bücher.de").
+		To("", "bob@例子.广告").
+		Subject("Grüße").
+		Text([]byte("text"))
+	addr, sessions := startSMTPServer(t, "8BITMIME")
+	require.NoError(t, b.Send(enmime.NewSMTP(addr, nil)))
+	cmds := <-sessions
+	assert.Contains(t, cmds, "MAIL FROM:<jose@xn--bcher-kva.de> BODY=8BITMIME")
+	msg := cmds[len(cmds)-2]
+	head, _, _ := strings.Cut(msg, "\n\n")
+	for _, c := range head {
+		require.Less(t, c, rune(0x80), "non-ASCII header: %q", head)
+	}
+	assert.Contains(t, head, "<jose@xn--bcher-kva.de>")
+	env, err := enmime.ReadEnvelope(strings.NewReader(msg))
+	require.NoError(t, err)
+	assert.Equal(t, "Grüße", env.GetHeader("Subject"))
+
+	// UTF-8 local parts cannot be sent at all.
+	addr, sessions = startSMTPServer(t, "8BITMIME")
+	err = b.To("", "用户@example.com").Send(enmime.NewSMTP(addr, nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SMTPUTF8")
+	assert.NotContains(t, strings.Join(<-sessions, "\n"), "DATA")
+}
+
+func TestSMTPSendPartPolicy(t *testing.T) {
+	text := "Greetings from Köln, the city on the Rhine.\r\n"
+	build := func() enmime.MailBuilder {
+		return enmime.Builder().
+			From("", "alice@example.com").
+			To("", "bob@example.com").
+			Subject("Hi").
+			Text([]byte(text)).
+			AddAttachment([]byte{0, 1, 2, 0xff}, "application/octet-stream", "data.bin")
+	}
+
+	addr, sessions := startSMTPServer(t)
+	require.NoError(t, build().Send(enmime.NewSMTP(addr, nil)))
+	cmds := <-sessions
+	assert.Contains(t, cmds, "MAIL FROM:<alice@example.com>")
+	assert.Contains(t, cmds[len(cmds)-2], "Content-Transfer-Encoding: quoted-printable")
+
+	addr, sessions = startSMTPServer(t, "8BITMIME")
+	require.NoError(t, build().Send(enmime.NewSMTP(addr, nil)))
+	cmds = <-sessions
+	assert.Contains(t, cmds, "MAIL FROM:<alice@example.com> BODY=8BITMIME")
+	msg := cmds[len(cmds)-2]
+	assert.Contains(t, msg, "Content-Transfer-Encoding: 8bit")
+	assert.Contains(t, msg, "Content-Transfer-Encoding: base64")
+	assert.Contains(t, msg, "Köln")
+
+	addr, sessions = startSMTPServer(t, "8BITMIME", "BINARYMIME", "CHUNKING")
+	require.NoError(t, build().Send(enmime.NewSMTP(addr, nil)))
+	cmds = <-sessions
+	assert.Contains(t, cmds, "MAIL FROM:<alice@example.com> BODY=BINARYMIME")
+	msg = cmds[len(cmds)-2]
+	assert.Contains(t, msg, "Content-Transfer-Encoding: 8bit")
+	assert.Contains(t, msg, "Content-Transfer-Encoding: binary")
+	assert.Contains(t, msg, "\x00\x01\x02\xff")
+	env, err := enmime.ReadEnvelope(strings.NewReader(msg))
+	require.NoError(t, err)
+	assert.Equal(t, text, env.Text)
+	require.Len(t, env.Attachments, 1)
+	assert.Equal(t, []byte{0, 1, 2, 0xff}, env.Attachments[0].Content)
+}
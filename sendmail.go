@@ -0,0 +1,135 @@
+package enmime
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults for SendmailSender.
+const (
+	defaultSendmailPath = "/usr/sbin/sendmail"
+	// exTempFail is the sysexits.h status of sendmail for temporary failures.
+	exTempFail = 75
+)
+
+// SendmailSender is a Sender piping messages to a local sendmail compatible binary, such as the
+// one provided by Postfix.  The binary is run once for each message as:
+//
+//	sendmail -i -f <reversePath> -- <recipients>
+type SendmailSender struct {
+	path string
+	args []string
+}
+
+var _ ContextSender = &SendmailSender{}
+
+// NewSendmail creates a new SendmailSender, running /usr/sbin/sendmail unless configured
+// otherwise.
+func NewSendmail(opts ...SendmailOption) *SendmailSender {
+	s := &SendmailSender{
+		path: defaultSendmailPath,
+		args: []string{"-i"},
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(s)
+		}
+	}
+	return s
+}
+
+// Send pipes msg to the sendmail binary for delivery to the specified recipients.  A
+// *SendmailError is returned if the binary fails.
+func (s *SendmailSender) Send(reversePath string, recipients []string, msg []byte) error {
+	_, err := s.SendContext(context.Background(), reversePath, recipients, msg)
+	return err
+}
+
+// SendContext sends msg as Send does, killing the sendmail process when ctx is done.  Sendmail
+// does not report the outcome for each recipient, so on success all recipients are listed as
+// accepted, with a zero reply code.
+func (s *SendmailSender) SendContext(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*SendResult, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("sendmail: no recipients")
+	}
+	if err := validateLine(reversePath); err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		if err := validateLine(r); err != nil {
+			return nil, err
+		}
+	}
+
+	args := make([]string, 0, len(s.args)+len(recipients)+3)
+	args = append(args, s.args...)
+	args = append(args, "-f", reversePath, "--")
+	args = append(args, recipients...)
+	cmd := exec.CommandContext(ctx, s.path, args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	// Don't wait indefinitely for children of a killed binary holding stderr open.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.WithStack(ctx.Err())
+		}
+		serr := &SendmailError{
+			Path:     s.path,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stderr.String()),
+			Err:      err,
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			serr.ExitCode = exitErr.ExitCode()
+		}
+		return nil, serr
+	}
+
+	res := &SendResult{Accepted: make([]RecipientResult, len(recipients))}
+	for i, r := range recipients {
+		res.Accepted[i] = RecipientResult{Recipient: r}
+	}
+	return res, nil
+}
+
+// SendmailError reports the failure of the sendmail binary.
+type SendmailError struct {
+	Path     string // Path of the binary.
+	ExitCode int    // Exit status of the binary, or -1 if it did not exit normally.
+	Stderr   string // Standard error output of the binary.
+	Err      error  // Underlying error from os/exec.
+}
+
+// Error implements error.
+func (e *SendmailError) Error() string {
+	msg := fmt.Sprintf("sendmail: %s failed: %v", e.Path, e.Err)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error from os/exec.
+func (e *SendmailError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary returns true if sendmail exited with EX_TEMPFAIL, indicating the message may be sent
+// again later.
+func (e *SendmailError) Temporary() bool {
+	return e.ExitCode == exTempFail
+}
@@ -0,0 +1,30 @@
+package enmime
+
+// SendmailOption configures a SendmailSender.
+type SendmailOption interface {
+	apply(s *SendmailSender)
+}
+
+// SendmailPath sets the path of the sendmail binary, /usr/sbin/sendmail by default.  A name
+// without path separators is looked up in PATH.
+func SendmailPath(path string) SendmailOption {
+	return sendmailPathOption(path)
+}
+
+type sendmailPathOption string
+
+func (o sendmailPathOption) apply(s *SendmailSender) {
+	s.path = string(o)
+}
+
+// SendmailArgs replaces the arguments passed to the binary before the reverse-path and recipients,
+// "-i" by default.  The "-f <reversePath> -- <recipients>" arguments are always appended.
+func SendmailArgs(args ...string) SendmailOption {
+	return sendmailArgsOption(args)
+}
+
+type sendmailArgsOption []string
+
+func (o sendmailArgsOption) apply(s *SendmailSender) {
+	s.args = append([]string(nil), o...)
+}
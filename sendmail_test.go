@@ -0,0 +1,102 @@
+package enmime_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSendmail writes a script recording its arguments and input next to itself, and failing
+// with the status in the FAIL header of the message.
+func fakeSendmail(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "sendmail")
+	script := `#!/bin/sh
+printf '%s\n' "$@" > "$0.args"
+cat > "$0.msg"
+status=$(sed -n 's/^FAIL: *\([0-9]*\).*/\1/p' "$0.msg")
+if [ -n "$status" ]; then
+	echo "sendmail: fatal: failed with $status" >&2
+	exit "$status"
+fi
+if grep -q '^SLEEP' "$0.msg"; then
+	sleep 5
+fi
+`
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o700))
+	return path
+}
+
+func TestSendmailSender(t *testing.T) {
+	path := fakeSendmail(t)
+	s := enmime.NewSendmail(enmime.SendmailPath(path))
+	msg := "Subject: hi\r\n\r\nbody\r\n"
+	res, err := s.SendContext(context.Background(), "alice@example.com",
+		[]string{"bob@example.com", "-carol@example.com"}, []byte(msg))
+	require.NoError(t, err)
+	assert.Len(t, res.Accepted, 2)
+
+	args, err := os.ReadFile(path + ".args")
+	require.NoError(t, err)
+	assert.Equal(t, "-i\n-f\nalice@example.com\n--\nbob@example.com\n-carol@example.com\n",
+		string(args))
+	got, err := os.ReadFile(path + ".msg")
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(got))
+
+	s = enmime.NewSendmail(enmime.SendmailPath(path), enmime.SendmailArgs("-i", "-odb"))
+	require.NoError(t, s.Send("", []string{"bob@example.com"}, []byte(msg)))
+	args, err = os.ReadFile(path + ".args")
+	require.NoError(t, err)
+	assert.Equal(t, "-i\n-odb\n-f\n\n--\nbob@example.com\n", string(args))
+}
+
+func TestSendmailSenderErrors(t *testing.T) {
+	path := fakeSendmail(t)
+	s := enmime.NewSendmail(enmime.SendmailPath(path))
+
+	err := s.Send("alice@example.com", []string{"bob@example.com"}, []byte("FAIL: 75\r\n\r\n"))
+	var serr *enmime.SendmailError
+	require.ErrorAs(t, err, &serr)
+	assert.Equal(t, 75, serr.ExitCode)
+	assert.Equal(t, "sendmail: fatal: failed with 75", serr.Stderr)
+	assert.True(t, serr.Temporary())
+	assert.Contains(t, err.Error(), "failed with 75")
+
+	err = s.Send("alice@example.com", []string{"bob@example.com"}, []byte("FAIL: 67\r\n\r\n"))
+	require.ErrorAs(t, err, &serr)
+	assert.Equal(t, 67, serr.ExitCode)
+	assert.False(t, serr.Temporary())
+
+	err = s.Send("alice@example.com", nil, []byte("\r\n"))
+	assert.Error(t, err)
+	err = s.Send("alice@example.com", []string{"bob@example.com\r\nDATA"}, []byte("\r\n"))
+	assert.Error(t, err)
+
+	s = enmime.NewSendmail(enmime.SendmailPath(filepath.Join(t.TempDir(), "missing")))
+	err = s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n"))
+	require.ErrorAs(t, err, &serr)
+	assert.Equal(t, -1, serr.ExitCode)
+}
+
+func TestSendmailSenderContext(t *testing.T) {
+	path := fakeSendmail(t)
+	s := enmime.NewSendmail(enmime.SendmailPath(path))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.SendContext(ctx, "alice@example.com", []string{"bob@example.com"},
+		[]byte("SLEEP\r\n"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
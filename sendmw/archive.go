@@ -0,0 +1,71 @@
+package sendmw
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/mbox"
+	"github.com/pkg/errors"
+)
+
+// ArchiveDir writes a copy of each message to a new file in dir before sending it, named with the
+// time it was sent and an .eml extension.  Messages are not sent if they could not be archived.
+func ArchiveDir(dir string) Middleware {
+	return archive(func(_ string, msg []byte) error {
+		f, err := os.CreateTemp(dir, time.Now().UTC().Format("20060102T150405Z")+"-*.eml")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := f.Write(msg); err != nil {
+			_ = f.Close()
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(f.Close())
+	})
+}
+
+// ArchiveMbox appends a copy of each message to the mboxrd file at path before sending it,
+// creating the file if necessary.  The reverse-path of the message is recorded in its separator
+// line.  Messages are not sent if they could not be archived.
+func ArchiveMbox(path string) Middleware {
+	var mu sync.Mutex
+	return archive(func(reversePath string, msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		w := mbox.NewWriter(f, mbox.Mboxrd)
+		if err := w.WriteMessage(reversePath, time.Now(), bytes.NewReader(msg)); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			_ = f.Close()
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(f.Close())
+	})
+}
+
+// archive returns middleware calling store with each message before sending it.
+func archive(store func(reversePath string, msg []byte) error) Middleware {
+	return func(next enmime.Sender) enmime.Sender {
+		return SenderFunc(func(
+			ctx context.Context,
+			reversePath string,
+			recipients []string,
+			msg []byte,
+		) (*enmime.SendResult, error) {
+			if err := store(reversePath, msg); err != nil {
+				return nil, errors.WithMessage(err, "archiving message")
+			}
+			return SendContext(ctx, next, reversePath, recipients, msg)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package sendmw
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net/textproto"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/internal/coding"
+)
+
+// LogSends logs each message sent to logger, or to slog.Default if logger is nil.  Messages are
+// logged at Info level when sent to all recipients, Warn level when some recipients were
+// rejected, and Error level when sending failed.
+func LogSends(logger *slog.Logger) Middleware {
+	return func(next enmime.Sender) enmime.Sender {
+		return SenderFunc(func(
+			ctx context.Context,
+			reversePath string,
+			recipients []string,
+			msg []byte,
+		) (*enmime.SendResult, error) {
+			l := logger
+			if l == nil {
+				l = slog.Default()
+			}
+			start := time.Now()
+			res, err := SendContext(ctx, next, reversePath, recipients, msg)
+
+			attrs := append(messageAttrs(msg),
+				slog.String("reverse_path", reversePath),
+				slog.Any("recipients", recipients),
+				slog.Int("size", len(msg)),
+				slog.Duration("duration", time.Since(start)),
+			)
+			level := slog.LevelInfo
+			if res != nil {
+				attrs = append(attrs, slog.Int("accepted", len(res.Accepted)))
+				if res.Code != 0 {
+					attrs = append(attrs, slog.String("reply", res.Message))
+				}
+				if len(res.Rejected) > 0 {
+					attrs = append(attrs, slog.Any("rejected", res.Err()))
+					level = slog.LevelWarn
+				}
+			}
+			if err != nil {
+				attrs = append(attrs, slog.Any("error", err))
+				level = slog.LevelError
+			}
+			l.LogAttrs(ctx, level, "enmime: send", attrs...)
+			return res, err
+		})
+	}
+}
+
+// messageAttrs returns log attributes identifying msg, from its header.
+func messageAttrs(msg []byte) []slog.Attr {
+	// A partial header is returned along with any error.
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(msg))).ReadMIMEHeader()
+	var attrs []slog.Attr
+	if id := header.Get("Message-Id"); id != "" {
+		attrs = append(attrs, slog.String("message_id", coding.FromIDHeader(id)))
+	}
+	if subject := header.Get("Subject"); subject != "" {
+		attrs = append(attrs, slog.String("subject", coding.DecodeExtHeader(subject)))
+	}
+	return attrs
+}
@@ -0,0 +1,117 @@
+package sendmw
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"strings"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/pkg/errors"
+)
+
+// DryRun discards messages instead of sending them, reporting all recipients as accepted.  It is
+// usually combined with LogSends or an archive, which must come before it in the Chain.
+func DryRun() Middleware {
+	return func(enmime.Sender) enmime.Sender {
+		return SenderFunc(func(
+			ctx context.Context,
+			_ string,
+			recipients []string,
+			_ []byte,
+		) (*enmime.SendResult, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return acceptAll(recipients), nil
+		})
+	}
+}
+
+// SetHeader sets a header field of each message before sending it, replacing any existing fields
+// with the same name.  Non-ASCII values are encoded per RFC 2047.
+func SetHeader(name, value string) Middleware {
+	return func(next enmime.Sender) enmime.Sender {
+		return SenderFunc(func(
+			ctx context.Context,
+			reversePath string,
+			recipients []string,
+			msg []byte,
+		) (*enmime.SendResult, error) {
+			msg, err := setHeader(msg, name, value)
+			if err != nil {
+				return nil, err
+			}
+			return SendContext(ctx, next, reversePath, recipients, msg)
+		})
+	}
+}
+
+// Redirect replaces the recipients of each message with addrs, for example to deliver all mail
+// sent from a staging environment to a catch-all mailbox.  The message header is not modified.
+func Redirect(addrs ...string) Middleware {
+	addrs = append([]string(nil), addrs...)
+	return func(next enmime.Sender) enmime.Sender {
+		return SenderFunc(func(
+			ctx context.Context,
+			reversePath string,
+			_ []string,
+			msg []byte,
+		) (*enmime.SendResult, error) {
+			if len(addrs) == 0 {
+				return nil, errors.New("sendmw: no redirect recipients")
+			}
+			return SendContext(ctx, next, reversePath, addrs, msg)
+		})
+	}
+}
+
+// setHeader returns a copy of msg with the named header field set to value.  The field is added
+// at the end of the header, using the line ending of the first line of msg.
+func setHeader(msg []byte, name, value string) ([]byte, error) {
+	if name == "" || strings.ContainsAny(name, ": \t\r\n") {
+		return nil, errors.Errorf("sendmw: invalid header field name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return nil, errors.Errorf("sendmw: header field %s contains a line break", name)
+	}
+	eol := []byte("\n")
+	if i := bytes.IndexByte(msg, '\n'); i > 0 && msg[i-1] == '\r' {
+		eol = []byte("\r\n")
+	}
+
+	out := make([]byte, 0, len(msg)+len(name)+len(value)+4)
+	rest := msg
+	skip := false
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// End of header.
+			break
+		}
+		rest = rest[len(line):]
+		if line[0] != ' ' && line[0] != '\t' {
+			// Lines starting with whitespace continue the previous field.
+			field, _, _ := bytes.Cut(line, []byte(":"))
+			skip = strings.EqualFold(strings.TrimSpace(string(field)), name)
+		}
+		if !skip {
+			out = append(out, line...)
+			if line[len(line)-1] != '\n' {
+				out = append(out, eol...)
+			}
+		}
+	}
+
+	out = append(out, name+": "...)
+	out = append(out, mime.QEncoding.Encode("utf-8", value)...)
+	out = append(out, eol...)
+	if len(rest) == 0 {
+		// The message has no body.
+		return append(out, eol...), nil
+	}
+	return append(out, rest...), nil
+}
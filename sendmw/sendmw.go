@@ -0,0 +1,92 @@
+// Package sendmw provides middleware for enmime Senders: logging, archiving, dry-runs, header
+// injection and recipient redirection.  Middleware is composed with Chain, for example:
+//
+//	sender := sendmw.Chain(enmime.NewSMTPClient("smtp.example.com:587"),
+//		sendmw.LogSends(slog.Default()),
+//		sendmw.SetHeader("X-Environment", "staging"),
+//		sendmw.Redirect("catch-all@example.com"),
+//	)
+//
+// The Senders returned by middleware implement enmime.ContextSender, passing the context and
+// result through to the wrapped Sender when it does too.  They do not implement
+// enmime.PartSender, so messages sent with MailBuilder.Send are encoded with the default
+// TransferEncodingPolicy.
+package sendmw
+
+import (
+	"context"
+
+	"github.com/jhillyerd/enmime/v2"
+)
+
+// Middleware wraps a Sender, returning a Sender which adds some behavior.
+type Middleware func(next enmime.Sender) enmime.Sender
+
+// Chain wraps s with each of the middleware.  The first middleware is the outermost, so sees each
+// message first.
+func Chain(s enmime.Sender, mw ...Middleware) enmime.Sender {
+	for i := len(mw) - 1; i >= 0; i-- {
+		s = mw[i](s)
+	}
+	return s
+}
+
+// SenderFunc is an adapter allowing a function to be used as an enmime.ContextSender.
+type SenderFunc func(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*enmime.SendResult, error)
+
+var _ enmime.ContextSender = SenderFunc(nil)
+
+// Send calls f without a context.  A *enmime.RecipientError is returned if any recipient was
+// rejected.
+func (f SenderFunc) Send(reversePath string, recipients []string, msg []byte) error {
+	res, err := f(context.Background(), reversePath, recipients, msg)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// SendContext calls f.
+func (f SenderFunc) SendContext(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*enmime.SendResult, error) {
+	return f(ctx, reversePath, recipients, msg)
+}
+
+// SendContext sends msg with s.SendContext if s is an enmime.ContextSender.  Otherwise it calls
+// s.Send, and reports all recipients as accepted when it succeeds.
+func SendContext(
+	ctx context.Context,
+	s enmime.Sender,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*enmime.SendResult, error) {
+	if cs, ok := s.(enmime.ContextSender); ok {
+		return cs.SendContext(ctx, reversePath, recipients, msg)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.Send(reversePath, recipients, msg); err != nil {
+		return nil, err
+	}
+	return acceptAll(recipients), nil
+}
+
+// acceptAll returns a result listing all recipients as accepted.
+func acceptAll(recipients []string) *enmime.SendResult {
+	res := &enmime.SendResult{Accepted: make([]enmime.RecipientResult, len(recipients))}
+	for i, r := range recipients {
+		res.Accepted[i] = enmime.RecipientResult{Recipient: r}
+	}
+	return res
+}
@@ -0,0 +1,180 @@
+package sendmw_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/mbox"
+	"github.com/jhillyerd/enmime/v2/sendmw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: =?utf-8?q?Caf=C3=A9?=\r\n" +
+	"Message-Id: <1@example.com>\r\n" +
+	"\r\n" +
+	"From here\r\n"
+
+// recorder is a plain Sender recording the messages sent.
+type recorder struct {
+	reversePath string
+	recipients  []string
+	msgs        [][]byte
+	err         error
+}
+
+func (r *recorder) Send(reversePath string, recipients []string, msg []byte) error {
+	r.reversePath = reversePath
+	r.recipients = recipients
+	r.msgs = append(r.msgs, msg)
+	return r.err
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) sendmw.Middleware {
+		return func(next enmime.Sender) enmime.Sender {
+			return sendmw.SenderFunc(func(
+				ctx context.Context, rp string, rcpts []string, msg []byte,
+			) (*enmime.SendResult, error) {
+				order = append(order, name)
+				return sendmw.SendContext(ctx, next, rp, rcpts, msg)
+			})
+		}
+	}
+	rec := &recorder{}
+	s := sendmw.Chain(rec, mw("a"), mw("b"))
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+	assert.Equal(t, []string{"a", "b"}, order)
+	assert.Len(t, rec.msgs, 1)
+}
+
+func TestSetHeaderAndRedirect(t *testing.T) {
+	rec := &recorder{}
+	s := sendmw.Chain(rec,
+		sendmw.SetHeader("X-Environment", "staging"),
+		sendmw.SetHeader("Subject", "Grüße"),
+		sendmw.Redirect("catch-all@example.com"),
+	)
+	msg := "Subject: old\r\n  folded\r\nTo: bob@example.com\r\n\r\nBody\r\n"
+	res, err := s.(enmime.ContextSender).SendContext(context.Background(), "alice@example.com",
+		[]string{"bob@example.com", "carol@example.com"}, []byte(msg))
+	require.NoError(t, err)
+	require.Len(t, res.Accepted, 1)
+	assert.Equal(t, "catch-all@example.com", res.Accepted[0].Recipient)
+	assert.Equal(t, []string{"catch-all@example.com"}, rec.recipients)
+	assert.Equal(t, "To: bob@example.com\r\n"+
+		"X-Environment: staging\r\n"+
+		"Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n"+
+		"\r\n"+
+		"Body\r\n", string(rec.msgs[0]))
+
+	// Messages without a body, with LF line endings.
+	require.NoError(t, s.Send("", []string{"bob@example.com"}, []byte("To: bob@example.com\n")))
+	assert.Equal(t, "To: bob@example.com\nX-Environment: staging\n"+
+		"Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\n\n", string(rec.msgs[1]))
+
+	s = sendmw.Chain(rec, sendmw.SetHeader("Bad\r\nName", "x"))
+	assert.Error(t, s.Send("", []string{"bob@example.com"}, []byte("\r\n")))
+	s = sendmw.Chain(rec, sendmw.Redirect())
+	assert.Error(t, s.Send("", []string{"bob@example.com"}, []byte("\r\n")))
+}
+
+func TestLogSends(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	rec := &recorder{}
+	s := sendmw.Chain(rec, sendmw.LogSends(logger))
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "INFO", entry["level"])
+	assert.Equal(t, "enmime: send", entry["msg"])
+	assert.Equal(t, "1@example.com", entry["message_id"])
+	assert.Equal(t, "Café", entry["subject"])
+	assert.Equal(t, "alice@example.com", entry["reverse_path"])
+	assert.Equal(t, []any{"bob@example.com"}, entry["recipients"])
+	assert.InDelta(t, len(testMessage), entry["size"], 0)
+	assert.InDelta(t, 1, entry["accepted"], 0)
+
+	buf.Reset()
+	rec.err = errors.New("connection refused")
+	require.Error(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	entry = nil
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "connection refused", entry["error"])
+}
+
+func TestDryRun(t *testing.T) {
+	rec := &recorder{}
+	s := sendmw.Chain(rec, sendmw.DryRun())
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	assert.Empty(t, rec.msgs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.(enmime.ContextSender).SendContext(ctx, "", []string{"bob@example.com"}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestArchiveDir(t *testing.T) {
+	dir := t.TempDir()
+	rec := &recorder{}
+	s := sendmw.Chain(rec, sendmw.ArchiveDir(dir))
+	for range 2 {
+		require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	}
+	assert.Len(t, rec.msgs, 2)
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	b, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, testMessage, string(b))
+
+	// Messages are not sent when archiving fails.
+	s = sendmw.Chain(rec, sendmw.ArchiveDir(filepath.Join(dir, "missing")))
+	assert.Error(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	assert.Len(t, rec.msgs, 2)
+}
+
+func TestArchiveMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent.mbox")
+	rec := &recorder{}
+	s := sendmw.Chain(rec, sendmw.ArchiveMbox(path))
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte(testMessage)))
+	require.NoError(t, s.Send("", []string{"bob@example.com"}, []byte(testMessage)))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	r := mbox.NewReader(f, mbox.Mboxrd)
+	var senders []string
+	for {
+		msg, err := r.NextMessage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(msg)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), "From here")
+		assert.NotContains(t, string(b), ">From here")
+		senders = append(senders, r.From())
+	}
+	require.Len(t, senders, 2)
+	assert.Contains(t, senders[0], "alice@example.com ")
+	assert.Contains(t, senders[1], "MAILER-DAEMON ")
+}
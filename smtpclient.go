@@ -0,0 +1,388 @@
+package enmime
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TLSMode selects how an SMTPClient secures its connections.
+type TLSMode int
+
+const (
+	// TLSOpportunistic upgrades connections with STARTTLS when the server offers it.
+	TLSOpportunistic TLSMode = iota
+	// TLSRequired upgrades connections with STARTTLS, failing if the server does not offer it.
+	TLSRequired
+	// TLSImplicit connects with TLS from the start, as used on port 465 (RFC 8314).
+	TLSImplicit
+	// TLSDisabled never uses TLS.
+	TLSDisabled
+)
+
+// Defaults for SMTPClient connection pooling.
+const (
+	defaultSMTPMaxIdleConns = 2
+	defaultSMTPIdleTimeout  = 30 * time.Second
+)
+
+// SMTPClient is a Sender delivering messages to a single SMTP server.  Unlike SMTPSender, it
+// supports cancellation through SendContext, TLS configuration, and reports the reply of the
+// server to each recipient.  Connections are kept open between messages and reused, making it
+// efficient for sending batches of messages; call Close to release them.  SMTPClient is safe for
+// concurrent use, each concurrent send using a connection of its own.
+type SMTPClient struct {
+	addr        string
+	auth        smtp.Auth
+	tlsConfig   *tls.Config
+	tlsMode     TLSMode
+	localName   string
+	timeout     time.Duration
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*smtpConn
+	closed bool
+}
+
+var (
+	_ ContextSender = &SMTPClient{}
+	_ PartSender    = &SMTPClient{}
+)
+
+// smtpConn is an open connection to the server of an SMTPClient.
+type smtpConn struct {
+	conn     net.Conn
+	c        *smtp.Client
+	smtpUTF8 bool
+	lastUsed time.Time
+}
+
+// NewSMTPClient creates a new SMTPClient for the server at addr, a host:port pair.
+func NewSMTPClient(addr string, opts ...SMTPOption) *SMTPClient {
+	s := &SMTPClient{
+		addr:        addr,
+		maxIdle:     defaultSMTPMaxIdleConns,
+		idleTimeout: defaultSMTPIdleTimeout,
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(s)
+		}
+	}
+	return s
+}
+
+// Send sends msg as SendContext does, without a context.  A *RecipientError is returned if any
+// recipient was rejected.
+func (s *SMTPClient) Send(reversePath string, recipients []string, msg []byte) error {
+	res, err := s.SendContext(context.Background(), reversePath, recipients, msg)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// SendContext sends msg to the specified recipients, aborting when ctx is done.  Addresses are
+// handled as by SMTPSender.Send.  An error is returned if the message could not be sent to any
+// recipient; when only some recipients were rejected, they are listed in the result, and the
+// message is sent to the others.
+func (s *SMTPClient) SendContext(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	msg []byte,
+) (*SendResult, error) {
+	return s.send(ctx, reversePath, recipients, func(*smtpConn) ([]byte, bool, error) {
+		return msg, false, nil
+	})
+}
+
+// SendPart encodes root and sends it as SendPartContext does, without a context.  A
+// *RecipientError is returned if any recipient was rejected.
+func (s *SMTPClient) SendPart(reversePath string, recipients []string, root *Part) error {
+	res, err := s.SendPartContext(context.Background(), reversePath, recipients, root)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// SendPartContext encodes root and sends it as SendContext does, choosing the
+// TransferEncodingPolicy from the EHLO extensions of the server as SMTPSender.SendPart does.
+func (s *SMTPClient) SendPartContext(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	root *Part,
+) (*SendResult, error) {
+	return s.send(ctx, reversePath, recipients, func(sc *smtpConn) ([]byte, bool, error) {
+		return encodeForServer(sc.c, root)
+	})
+}
+
+// Close closes the idle connections of the client.  Connections in use are closed once their
+// message has been sent.
+func (s *SMTPClient) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.closed = true
+	s.mu.Unlock()
+	for _, sc := range idle {
+		sc.quit()
+	}
+	return nil
+}
+
+// send delivers the message returned by body, which is called once connected to the server.
+func (s *SMTPClient) send(
+	ctx context.Context,
+	reversePath string,
+	recipients []string,
+	body func(sc *smtpConn) (msg []byte, binary bool, err error),
+) (*SendResult, error) {
+	if err := validateLine(reversePath); err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		if err := validateLine(r); err != nil {
+			return nil, err
+		}
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	for {
+		sc, reused, err := s.conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stop := sc.watch(ctx)
+		if reused {
+			// Check the idle connection is still open before using it.
+			if err := sc.c.Reset(); err != nil {
+				stop()
+				sc.close()
+				if ctx.Err() != nil {
+					return nil, errors.WithStack(ctx.Err())
+				}
+				continue
+			}
+		}
+
+		res, err := s.transaction(sc, reversePath, recipients, body)
+		if !stop() {
+			// The context was done, interrupting the connection.
+			sc.close()
+			return nil, errors.WithStack(ctx.Err())
+		}
+		if err != nil {
+			var tpErr *textproto.Error
+			var rcptErr *RecipientError
+			if !errors.As(err, &tpErr) && !errors.As(err, &rcptErr) {
+				// Connection or encoding failure.
+				sc.close()
+				return nil, err
+			}
+			// Rejected by the server; end the transaction so that the connection may be reused.
+			if sc.c.Reset() != nil {
+				sc.close()
+				return res, err
+			}
+		}
+		s.put(sc)
+		return res, err
+	}
+}
+
+// transaction sends a single message over sc.
+func (s *SMTPClient) transaction(
+	sc *smtpConn,
+	reversePath string,
+	recipients []string,
+	body func(sc *smtpConn) ([]byte, bool, error),
+) (*SendResult, error) {
+	envRecipients := recipients
+	if !sc.smtpUTF8 {
+		var err error
+		if reversePath, envRecipients, err = asciiEnvelope(reversePath, recipients); err != nil {
+			return nil, err
+		}
+	}
+	msg, binary, err := body(sc)
+	if err != nil {
+		return nil, err
+	}
+	if err := smtpMail(sc.c, reversePath, binary, sc.smtpUTF8); err != nil {
+		return nil, err
+	}
+
+	res := &SendResult{}
+	for i, r := range envRecipients {
+		rr := RecipientResult{Recipient: recipients[i]}
+		rr.Code, rr.Message, err = smtpCmdResponse(sc.c, 25, "RCPT TO:<%s>", r)
+		if err != nil {
+			var tpErr *textproto.Error
+			if !errors.As(err, &tpErr) {
+				return nil, err
+			}
+			rr.Code, rr.Message = tpErr.Code, tpErr.Msg
+			res.Rejected = append(res.Rejected, rr)
+			continue
+		}
+		res.Accepted = append(res.Accepted, rr)
+	}
+	if len(res.Accepted) == 0 {
+		return res, res.Err()
+	}
+	if res.Code, res.Message, err = smtpData(sc.c, msg, binary); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// conn returns an idle connection, or a new one when there are none.  reused is true for idle
+// connections.
+func (s *SMTPClient) conn(ctx context.Context) (sc *smtpConn, reused bool, err error) {
+	s.mu.Lock()
+	for len(s.idle) > 0 {
+		sc = s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		if time.Since(sc.lastUsed) <= s.idleTimeout {
+			s.mu.Unlock()
+			return sc, true, nil
+		}
+		// Close expired connections without holding the lock.
+		s.mu.Unlock()
+		sc.quit()
+		s.mu.Lock()
+	}
+	s.mu.Unlock()
+	sc, err = s.dial(ctx)
+	return sc, false, err
+}
+
+// put returns a connection to the idle pool, or closes it if the pool is full.
+func (s *SMTPClient) put(sc *smtpConn) {
+	sc.lastUsed = time.Now()
+	_ = sc.conn.SetDeadline(time.Time{})
+	s.mu.Lock()
+	if !s.closed && len(s.idle) < s.maxIdle {
+		s.idle = append(s.idle, sc)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	sc.quit()
+}
+
+// dial connects to the server, greets it, secures the connection and authenticates.
+func (s *SMTPClient) dial(ctx context.Context) (*smtpConn, error) {
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cfg := &tls.Config{}
+	if s.tlsConfig != nil {
+		cfg = s.tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	var conn net.Conn
+	if s.tlsMode == TLSImplicit {
+		conn, err = (&tls.Dialer{Config: cfg}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc := &smtpConn{conn: conn}
+	stop := sc.watch(ctx)
+	err = s.setup(sc, host, cfg)
+	if !stop() {
+		err = errors.WithStack(ctx.Err())
+	}
+	if err != nil {
+		sc.close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// setup greets the server over a new connection, then secures it and authenticates as configured.
+func (s *SMTPClient) setup(sc *smtpConn, host string, cfg *tls.Config) error {
+	c, err := smtp.NewClient(sc.conn, host)
+	if err != nil {
+		return err
+	}
+	sc.c = c
+	if s.localName != "" {
+		if err := c.Hello(s.localName); err != nil {
+			return err
+		}
+	}
+	if s.tlsMode == TLSOpportunistic || s.tlsMode == TLSRequired {
+		ok, _ := c.Extension("STARTTLS")
+		if ok {
+			if err := c.StartTLS(cfg); err != nil {
+				return err
+			}
+		} else if s.tlsMode == TLSRequired {
+			return errors.New("smtp: server doesn't support STARTTLS")
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	sc.smtpUTF8, _ = c.Extension("SMTPUTF8")
+	return nil
+}
+
+// watch interrupts the connection when ctx is done.  The returned stop function returns false if
+// the connection was interrupted.
+func (sc *smtpConn) watch(ctx context.Context) (stop func() bool) {
+	return watchConn(ctx, sc.conn)
+}
+
+// watchConn interrupts conn when ctx is done, by moving its deadline to the past.  The deadline of
+// ctx is not applied to conn directly, so that an interrupted operation is always reported as
+// ctx.Err() rather than as a timeout of the connection.  The returned stop function returns false
+// if the connection was interrupted.
+func watchConn(ctx context.Context, conn net.Conn) (stop func() bool) {
+	_ = conn.SetDeadline(time.Time{})
+	return context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+// quit ends the session politely, then closes the connection.
+func (sc *smtpConn) quit() {
+	_ = sc.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := sc.c.Quit(); err != nil {
+		sc.close()
+	}
+}
+
+// close closes the connection immediately.
+func (sc *smtpConn) close() {
+	_ = sc.conn.Close()
+}
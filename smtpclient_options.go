@@ -0,0 +1,98 @@
+package enmime
+
+import (
+	"crypto/tls"
+	"net/smtp"
+	"time"
+)
+
+// SMTPOption configures an SMTPClient.
+type SMTPOption interface {
+	apply(s *SMTPClient)
+}
+
+// SMTPAuth sets the authentication mechanism used after connecting, as accepted by
+// net/smtp.SendMail.  Authentication fails if the server does not advertise AUTH.
+func SMTPAuth(auth smtp.Auth) SMTPOption {
+	return smtpAuthOption{auth}
+}
+
+type smtpAuthOption struct {
+	auth smtp.Auth
+}
+
+func (o smtpAuthOption) apply(s *SMTPClient) {
+	s.auth = o.auth
+}
+
+// SMTPTLSConfig sets the TLS configuration used for STARTTLS and implicit TLS.  When the
+// ServerName is empty, the host of the server address is used.
+func SMTPTLSConfig(cfg *tls.Config) SMTPOption {
+	return smtpTLSConfigOption{cfg}
+}
+
+type smtpTLSConfigOption struct {
+	cfg *tls.Config
+}
+
+func (o smtpTLSConfigOption) apply(s *SMTPClient) {
+	s.tlsConfig = o.cfg
+}
+
+// SMTPTLSMode sets how connections are secured, TLSOpportunistic by default.
+func SMTPTLSMode(mode TLSMode) SMTPOption {
+	return smtpTLSModeOption(mode)
+}
+
+type smtpTLSModeOption TLSMode
+
+func (o smtpTLSModeOption) apply(s *SMTPClient) {
+	s.tlsMode = TLSMode(o)
+}
+
+// SMTPLocalName sets the host name sent in the EHLO command, "localhost" by default.
+func SMTPLocalName(name string) SMTPOption {
+	return smtpLocalNameOption(name)
+}
+
+type smtpLocalNameOption string
+
+func (o smtpLocalNameOption) apply(s *SMTPClient) {
+	s.localName = string(o)
+}
+
+// SMTPTimeout limits the time taken to connect and send each message, in addition to any deadline
+// of the context passed to SendContext.  Zero, the default, sets no limit.
+func SMTPTimeout(d time.Duration) SMTPOption {
+	return smtpTimeoutOption(d)
+}
+
+type smtpTimeoutOption time.Duration
+
+func (o smtpTimeoutOption) apply(s *SMTPClient) {
+	s.timeout = time.Duration(o)
+}
+
+// SMTPMaxIdleConns sets the number of connections kept open for reuse between messages, 2 by
+// default.  Zero disables reuse, closing each connection once its message has been sent.
+func SMTPMaxIdleConns(n int) SMTPOption {
+	return smtpMaxIdleConnsOption(n)
+}
+
+type smtpMaxIdleConnsOption int
+
+func (o smtpMaxIdleConnsOption) apply(s *SMTPClient) {
+	s.maxIdle = int(o)
+}
+
+// SMTPIdleTimeout sets how long an idle connection is kept open for reuse, 30 seconds by default.
+// Servers commonly close idle connections after a few minutes.
+func SMTPIdleTimeout(d time.Duration) SMTPOption {
+	return smtpIdleTimeoutOption(d)
+}
+
+type smtpIdleTimeoutOption time.Duration
+
+func (o smtpIdleTimeoutOption) apply(s *SMTPClient) {
+	s.idleTimeout = time.Duration(o)
+}
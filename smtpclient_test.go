@@ -0,0 +1,310 @@
+package enmime_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a stand-in SMTP server accepting any number of sessions.
+type fakeSMTP struct {
+	addr      string
+	reject    map[string]string // Reply to RCPT by recipient address.
+	dataDelay time.Duration     // Delay before replying to message content.
+	tlsConfig *tls.Config       // Enables STARTTLS when set.
+
+	mu       sync.Mutex
+	sessions [][]string // Commands received by each session.
+}
+
+// startFakeSMTP starts f, listening with TLS from the start when implicitTLS is true.
+func startFakeSMTP(t *testing.T, f *fakeSMTP, implicitTLS bool) {
+	t.Helper()
+	var l net.Listener
+	var err error
+	if implicitTLS {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", f.tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	f.addr = l.Addr().String()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.sessions = append(f.sessions, nil)
+			session := len(f.sessions) - 1
+			f.mu.Unlock()
+			go f.serve(conn, session, implicitTLS)
+		}
+	}()
+}
+
+func (f *fakeSMTP) record(session int, cmd string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions[session] = append(f.sessions[session], cmd)
+}
+
+// commands returns the commands received by each session.
+func (f *fakeSMTP) commands() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([][]string, len(f.sessions))
+	for i, s := range f.sessions {
+		out[i] = append([]string(nil), s...)
+	}
+	return out
+}
+
+func (f *fakeSMTP) serve(conn net.Conn, session int, secure bool) {
+	defer func() { _ = conn.Close() }()
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		f.record(session, line)
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_ = tp.PrintfLine("500 Empty command")
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO":
+			_ = tp.PrintfLine("250-localhost")
+			if f.tlsConfig != nil && !secure {
+				_ = tp.PrintfLine("250-STARTTLS")
+			}
+			_ = tp.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 Ready to start TLS")
+			tc := tls.Server(conn, f.tlsConfig)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tc, true
+			tp = textproto.NewConn(conn)
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			if reply, ok := f.reject[rcpt]; ok {
+				_ = tp.PrintfLine("%s", reply)
+			} else {
+				_ = tp.PrintfLine("250 2.1.5 OK")
+			}
+		case "DATA":
+			_ = tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			f.record(session, string(data))
+			time.Sleep(f.dataDelay)
+			_ = tp.PrintfLine("250 2.0.0 Queued as 1234")
+		case "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+// testTLSConfigs returns server and client TLS configurations using a self-signed certificate
+// for 127.0.0.1.
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return server, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+}
+
+func TestSMTPClientRecipientResults(t *testing.T) {
+	f := &fakeSMTP{reject: map[string]string{
+		"gone@example.com": "550 5.1.1 No such user",
+		"full@example.com": "452 4.2.2 Mailbox full",
+	}}
+	startFakeSMTP(t, f, false)
+	s := enmime.NewSMTPClient(f.addr)
+	defer func() { _ = s.Close() }()
+
+	rcpts := []string{"bob@example.com", "gone@example.com", "full@example.com"}
+	res, err := s.SendContext(context.Background(), "alice@example.com", rcpts,
+		[]byte("Subject: hi\r\n\r\nbody\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []enmime.RecipientResult{
+		{Recipient: "bob@example.com", Code: 250, Message: "2.1.5 OK"},
+	}, res.Accepted)
+	assert.Equal(t, []enmime.RecipientResult{
+		{Recipient: "gone@example.com", Code: 550, Message: "5.1.1 No such user"},
+		{Recipient: "full@example.com", Code: 452, Message: "4.2.2 Mailbox full"},
+	}, res.Rejected)
+	assert.Equal(t, 250, res.Code)
+	assert.Equal(t, "2.0.0 Queued as 1234", res.Message)
+
+	var rcptErr *enmime.RecipientError
+	require.ErrorAs(t, res.Err(), &rcptErr)
+	assert.False(t, rcptErr.Temporary())
+	assert.Contains(t, rcptErr.Error(), "gone@example.com: 550 5.1.1 No such user")
+
+	// Send reports the rejected recipients as an error.
+	err = s.Send("alice@example.com", []string{"full@example.com", "bob@example.com"},
+		[]byte("\r\n"))
+	require.ErrorAs(t, err, &rcptErr)
+	assert.True(t, rcptErr.Temporary())
+
+	// When every recipient is rejected, no message is sent.
+	res, err = s.SendContext(context.Background(), "alice@example.com",
+		[]string{"gone@example.com"}, []byte("\r\n"))
+	require.ErrorAs(t, err, &rcptErr)
+	require.NotNil(t, res)
+	assert.Empty(t, res.Accepted)
+	assert.Zero(t, res.Code)
+
+	// The connection was reused throughout.
+	sessions := f.commands()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, 2, strings.Count(strings.Join(sessions[0], "\n"), "\nDATA"))
+}
+
+func TestSMTPClientConnectionReuse(t *testing.T) {
+	f := &fakeSMTP{}
+	startFakeSMTP(t, f, false)
+	s := enmime.NewSMTPClient(f.addr)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+	}
+	require.NoError(t, s.Close())
+	require.Eventually(t, func() bool {
+		sessions := f.commands()
+		return len(sessions) == 1 && sessions[0][len(sessions[0])-1] == "QUIT"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, strings.Count(strings.Join(f.commands()[0], "\n"), "RSET"))
+
+	// Disabled reuse opens a connection per message.
+	f = &fakeSMTP{}
+	startFakeSMTP(t, f, false)
+	s = enmime.NewSMTPClient(f.addr, enmime.SMTPMaxIdleConns(0))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+	}
+	assert.Len(t, f.commands(), 2)
+}
+
+func TestSMTPClientContext(t *testing.T) {
+	f := &fakeSMTP{dataDelay: 2 * time.Second}
+	startFakeSMTP(t, f, false)
+	s := enmime.NewSMTPClient(f.addr)
+	defer func() { _ = s.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.SendContext(ctx, "alice@example.com", []string{"bob@example.com"}, []byte("\r\n"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	s = enmime.NewSMTPClient(f.addr, enmime.SMTPTimeout(50*time.Millisecond))
+	err = s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = s.SendContext(ctx, "alice@example.com", []string{"bob@example.com"}, []byte("\r\n"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSMTPClientTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+
+	f := &fakeSMTP{tlsConfig: serverTLS}
+	startFakeSMTP(t, f, false)
+	s := enmime.NewSMTPClient(f.addr,
+		enmime.SMTPTLSConfig(clientTLS), enmime.SMTPTLSMode(enmime.TLSRequired))
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+	assert.Contains(t, f.commands()[0], "STARTTLS")
+
+	// An untrusted certificate fails.
+	s = enmime.NewSMTPClient(f.addr, enmime.SMTPMaxIdleConns(0))
+	assert.Error(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+
+	// Disabled TLS ignores STARTTLS.
+	s = enmime.NewSMTPClient(f.addr, enmime.SMTPTLSMode(enmime.TLSDisabled))
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+	sessions := f.commands()
+	assert.NotContains(t, sessions[len(sessions)-1], "STARTTLS")
+
+	// Required TLS fails without STARTTLS.
+	f = &fakeSMTP{}
+	startFakeSMTP(t, f, false)
+	s = enmime.NewSMTPClient(f.addr, enmime.SMTPTLSMode(enmime.TLSRequired))
+	err := s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n"))
+	assert.ErrorContains(t, err, "STARTTLS")
+
+	// Implicit TLS.
+	f = &fakeSMTP{tlsConfig: serverTLS}
+	startFakeSMTP(t, f, true)
+	s = enmime.NewSMTPClient(f.addr,
+		enmime.SMTPTLSConfig(clientTLS), enmime.SMTPTLSMode(enmime.TLSImplicit))
+	require.NoError(t, s.Send("alice@example.com", []string{"bob@example.com"}, []byte("\r\n")))
+	assert.Contains(t, f.commands()[0], "MAIL FROM:<alice@example.com> BODY=8BITMIME")
+}
+
+func TestSMTPClientSendPart(t *testing.T) {
+	f := &fakeSMTP{}
+	startFakeSMTP(t, f, false)
+	s := enmime.NewSMTPClient(f.addr)
+	defer func() { _ = s.Close() }()
+
+	err := enmime.Builder().
+		From("", "alice@example.com").
+		To("", "bob@example.com").
+		Subject("Hi").
+		Text([]byte("Greetings from Köln, the city on the Rhine.\r\n")).
+		Send(s)
+	require.NoError(t, err)
+	cmds := f.commands()[0]
+	assert.Contains(t, cmds, "MAIL FROM:<alice@example.com> BODY=8BITMIME")
+	assert.Contains(t, cmds[len(cmds)-1], "Content-Transfer-Encoding: 8bit")
+}
@@ -0,0 +1,115 @@
+package smtptest
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+)
+
+// Message is a message accepted by a Server.
+type Message struct {
+	// From is the reverse-path of the MAIL command, or "<>" for the null reverse-path.
+	From string
+	// Params are the parameters of the MAIL command, such as BODY=8BITMIME.
+	Params []string
+	// Recipients are the accepted recipients of the message.
+	Recipients []string
+	// Data is the message content, with dot-stuffing removed and CRLF line endings.
+	Data []byte
+	// TLS is true if the message was received over a connection secured with STARTTLS.
+	TLS bool
+	// Envelope is Data parsed with enmime.ReadEnvelope, nil if parsing failed.
+	Envelope *enmime.Envelope
+	// ParseErr is the error returned by enmime.ReadEnvelope.
+	ParseErr error
+}
+
+// AssertFrom checks the reverse-path of the message.
+func (m *Message) AssertFrom(tb testing.TB, want string) bool {
+	tb.Helper()
+	if m.From != want {
+		tb.Errorf("smtptest: reverse-path is %q, wanted %q", m.From, want)
+		return false
+	}
+	return true
+}
+
+// AssertRecipients checks the recipients of the message, ignoring their order.
+func (m *Message) AssertRecipients(tb testing.TB, want ...string) bool {
+	tb.Helper()
+	got := slices.Sorted(slices.Values(m.Recipients))
+	if !slices.Equal(got, slices.Sorted(slices.Values(want))) {
+		tb.Errorf("smtptest: recipients are %q, wanted %q", m.Recipients, want)
+		return false
+	}
+	return true
+}
+
+// AssertHeader checks the decoded value of a header of the message.
+func (m *Message) AssertHeader(tb testing.TB, name, want string) bool {
+	tb.Helper()
+	if !m.assertParsed(tb) {
+		return false
+	}
+	if got := m.Envelope.GetHeader(name); got != want {
+		tb.Errorf("smtptest: %s header is %q, wanted %q", name, got, want)
+		return false
+	}
+	return true
+}
+
+// AssertTextContains checks that the text body of the message contains substr.
+func (m *Message) AssertTextContains(tb testing.TB, substr string) bool {
+	tb.Helper()
+	if !m.assertParsed(tb) {
+		return false
+	}
+	if !strings.Contains(m.Envelope.Text, substr) {
+		tb.Errorf("smtptest: text body %q does not contain %q", m.Envelope.Text, substr)
+		return false
+	}
+	return true
+}
+
+// AssertHTMLContains checks that the HTML body of the message contains substr.
+func (m *Message) AssertHTMLContains(tb testing.TB, substr string) bool {
+	tb.Helper()
+	if !m.assertParsed(tb) {
+		return false
+	}
+	if !strings.Contains(m.Envelope.HTML, substr) {
+		tb.Errorf("smtptest: HTML body %q does not contain %q", m.Envelope.HTML, substr)
+		return false
+	}
+	return true
+}
+
+// AssertAttachment checks that the message has an attachment with the specified file name, and
+// returns it.
+func (m *Message) AssertAttachment(tb testing.TB, fileName string) *enmime.Part {
+	tb.Helper()
+	if !m.assertParsed(tb) {
+		return nil
+	}
+	names := make([]string, 0, len(m.Envelope.Attachments))
+	for _, p := range m.Envelope.Attachments {
+		if p.FileName == fileName {
+			return p
+		}
+		names = append(names, p.FileName)
+	}
+	tb.Errorf("smtptest: no attachment named %q, found %q", fileName, names)
+	return nil
+}
+
+// assertParsed checks the message content was parsed.
+func (m *Message) assertParsed(tb testing.TB) bool {
+	tb.Helper()
+	if m.Envelope == nil {
+		tb.Errorf("smtptest: message was not parsed: %v", m.ParseErr)
+		return false
+	}
+	return true
+}
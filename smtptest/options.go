@@ -0,0 +1,98 @@
+package smtptest
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// Option configures a Server.
+type Option interface {
+	apply(s *Server)
+}
+
+// LMTP serves LMTP (RFC 2033) instead of SMTP.  Clients must greet with LHLO, and the server
+// replies to the message content once for each accepted recipient.
+func LMTP() Option {
+	return lmtpOption{}
+}
+
+type lmtpOption struct{}
+
+func (lmtpOption) apply(s *Server) {
+	s.lmtp = true
+}
+
+// Listener serves connections accepted by l, such as a Unix socket listener, instead of listening
+// on a random TCP port.  The listener is closed with the server.
+func Listener(l net.Listener) Option {
+	return listenerOption{l}
+}
+
+type listenerOption struct {
+	l net.Listener
+}
+
+func (o listenerOption) apply(s *Server) {
+	s.listener = o.l
+}
+
+// Extensions sets the extensions advertised in reply to EHLO and LHLO, which are 8BITMIME,
+// SMTPUTF8, BINARYMIME and CHUNKING by default.  STARTTLS is advertised when StartTLS is set.
+func Extensions(exts ...string) Option {
+	return extensionsOption(exts)
+}
+
+type extensionsOption []string
+
+func (o extensionsOption) apply(s *Server) {
+	s.extensions = append([]string(nil), o...)
+}
+
+// StartTLS enables the STARTTLS extension, using cfg for the server side of the connection.
+func StartTLS(cfg *tls.Config) Option {
+	return startTLSOption{cfg}
+}
+
+type startTLSOption struct {
+	cfg *tls.Config
+}
+
+func (o startTLSOption) apply(s *Server) {
+	s.tlsConfig = o.cfg
+}
+
+// RcptResponse sets a function choosing the reply to each RCPT command, allowing tests to reject
+// recipients.  Recipients are accepted when it returns a zero Reply.
+func RcptResponse(f func(recipient string) Reply) Option {
+	return rcptResponseOption(f)
+}
+
+type rcptResponseOption func(recipient string) Reply
+
+func (o rcptResponseOption) apply(s *Server) {
+	s.rcptReply = o
+}
+
+// DataResponse sets a function choosing the reply to the message content.  For SMTP it is called
+// once with an empty recipient; for LMTP it is called for each accepted recipient.  The message is
+// accepted when it returns a zero Reply, and is only recorded if accepted for some recipient.
+func DataResponse(f func(recipient string, msg *Message) Reply) Option {
+	return dataResponseOption(f)
+}
+
+type dataResponseOption func(recipient string, msg *Message) Reply
+
+func (o dataResponseOption) apply(s *Server) {
+	s.dataReply = o
+}
+
+// BufferSize sets the capacity of the C channel, 100 by default.
+func BufferSize(n int) Option {
+	return bufferSizeOption(n)
+}
+
+type bufferSizeOption int
+
+func (o bufferSizeOption) apply(s *Server) {
+	s.bufferSize = max(int(o), 0)
+}
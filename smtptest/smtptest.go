@@ -0,0 +1,481 @@
+// Package smtptest provides an in-process SMTP and LMTP server for testing code that sends mail,
+// such as MailBuilder.Send.  Received messages are parsed with enmime, and exposed through a
+// channel and a slice along with assertion helpers.
+package smtptest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/internal/textproto"
+)
+
+// DefaultWaitTimeout is how long WaitForMessages waits for messages to arrive.
+var DefaultWaitTimeout = 5 * time.Second
+
+// Reply is a reply code and text sent by the server.  A zero Code selects the default reply.
+type Reply struct {
+	Code    int
+	Message string
+}
+
+// Server is an SMTP or LMTP server listening on a local address.  It accepts any sender, and by
+// default any recipient.
+type Server struct {
+	// Addr is the address the server is listening on, a host:port pair for TCP listeners.
+	Addr string
+	// C receives each message as it is accepted.  Messages are not sent to C when its buffer is
+	// full; they are always available from Messages.
+	C <-chan *Message
+
+	listener   net.Listener
+	lmtp       bool
+	extensions []string
+	tlsConfig  *tls.Config
+	rcptReply  func(recipient string) Reply
+	dataReply  func(recipient string, msg *Message) Reply
+	bufferSize int
+
+	c        chan *Message
+	mu       sync.Mutex
+	cond     *sync.Cond
+	messages []*Message
+	conns    map[net.Conn]bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a random port of 127.0.0.1, and closes it when the test ends.  The
+// test fails if the server cannot be started.
+func NewServer(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+	s := &Server{
+		extensions: []string{"8BITMIME", "SMTPUTF8", "BINARYMIME", "CHUNKING"},
+		bufferSize: 100,
+		conns:      make(map[net.Conn]bool),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(s)
+		}
+	}
+	if s.listener == nil {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			tb.Fatalf("smtptest: failed to listen: %v", err)
+		}
+		s.listener = l
+	}
+	s.Addr = s.listener.Addr().String()
+	s.c = make(chan *Message, s.bufferSize)
+	s.C = s.c
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(1)
+	go s.serve()
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server, closing its listener and any open connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	_ = s.listener.Close()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Messages returns the messages accepted so far, in the order they were received.
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message(nil), s.messages...)
+}
+
+// Reset discards the messages accepted so far, including those buffered in C.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	for len(s.c) > 0 {
+		<-s.c
+	}
+}
+
+// WaitForMessages waits up to DefaultWaitTimeout until at least n messages have been accepted,
+// and returns them.  The test fails if they do not arrive in time.
+func (s *Server) WaitForMessages(tb testing.TB, n int) []*Message {
+	tb.Helper()
+	timer := time.AfterFunc(DefaultWaitTimeout, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(DefaultWaitTimeout)
+
+	s.mu.Lock()
+	for len(s.messages) < n && !s.closed && time.Now().Before(deadline) {
+		s.cond.Wait()
+	}
+	msgs := append([]*Message(nil), s.messages...)
+	s.mu.Unlock()
+	if len(msgs) < n {
+		tb.Fatalf("smtptest: received %d messages, wanted %d", len(msgs), n)
+	}
+	return msgs
+}
+
+// AssertCount checks that exactly n messages have been accepted.
+func (s *Server) AssertCount(tb testing.TB, n int) bool {
+	tb.Helper()
+	if got := len(s.Messages()); got != n {
+		tb.Errorf("smtptest: received %d messages, wanted %d", got, n)
+		return false
+	}
+	return true
+}
+
+// serve accepts connections until the listener is closed.
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			sess := &session{server: s, conn: conn, tp: textproto.NewConn(conn)}
+			sess.run()
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// deliver records an accepted message.
+func (s *Server) deliver(msg *Message) {
+	s.mu.Lock()
+	s.messages = append(s.messages, msg)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	select {
+	case s.c <- msg:
+	default:
+	}
+}
+
+// session holds the state of a single client connection.
+type session struct {
+	server     *Server
+	conn       net.Conn
+	tp         *textproto.Conn
+	greeted    bool
+	tls        bool
+	from       string
+	mailParams []string
+	recipients []string
+	chunks     bytes.Buffer
+	binary     bool
+}
+
+// run handles commands until the client quits or the connection fails.
+func (s *session) run() {
+	proto := "ESMTP"
+	if s.server.lmtp {
+		proto = "LMTP"
+	}
+	if !s.reply(220, "localhost "+proto+" enmime smtptest") {
+		return
+	}
+	for {
+		line, err := s.tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		var ok bool
+		switch verb {
+		case "HELO", "EHLO", "LHLO":
+			ok = s.hello(verb)
+		case "STARTTLS":
+			ok = s.startTLS()
+		case "MAIL":
+			ok = s.mail(arg)
+		case "RCPT":
+			ok = s.rcpt(arg)
+		case "DATA":
+			ok = s.data()
+		case "BDAT":
+			ok = s.bdat(arg)
+		case "RSET":
+			s.reset()
+			ok = s.reply(250, "OK")
+		case "NOOP":
+			ok = s.reply(250, "OK")
+		case "VRFY":
+			ok = s.reply(252, "Cannot VRFY user")
+		case "QUIT":
+			s.reply(221, "Bye")
+			return
+		default:
+			ok = s.reply(502, "Command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// reply writes a reply, returning false if the connection failed.
+func (s *session) reply(code int, lines ...string) bool {
+	for i, line := range lines {
+		sep := " "
+		if i < len(lines)-1 {
+			sep = "-"
+		}
+		if err := s.tp.PrintfLine("%d%s%s", code, sep, line); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *session) hello(verb string) bool {
+	if s.server.lmtp != (verb == "LHLO") {
+		if s.server.lmtp {
+			return s.reply(500, "LMTP requires LHLO")
+		}
+		return s.reply(500, "LHLO is only valid for LMTP")
+	}
+	s.greeted = true
+	s.reset()
+	if verb == "HELO" {
+		return s.reply(250, "localhost")
+	}
+	lines := append([]string{"localhost"}, s.server.extensions...)
+	if s.server.tlsConfig != nil && !s.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	return s.reply(250, lines...)
+}
+
+func (s *session) startTLS() bool {
+	if s.server.tlsConfig == nil || s.tls {
+		return s.reply(502, "STARTTLS not available")
+	}
+	if !s.reply(220, "Ready to start TLS") {
+		return false
+	}
+	tc := tls.Server(s.conn, s.server.tlsConfig)
+	if err := tc.Handshake(); err != nil {
+		return false
+	}
+	s.tp = textproto.NewConn(tc)
+	s.tls = true
+	s.greeted = false
+	s.reset()
+	return true
+}
+
+func (s *session) mail(arg string) bool {
+	if !s.greeted {
+		return s.reply(503, "Send hello first")
+	}
+	if s.from != "" {
+		return s.reply(503, "Nested MAIL command")
+	}
+	path, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		return s.reply(501, "Syntax: MAIL FROM:<address>")
+	}
+	s.from = path
+	if s.from == "" {
+		// Null reverse-path, as used by delivery status notifications.
+		s.from = "<>"
+	}
+	s.mailParams = params
+	for _, p := range params {
+		if strings.EqualFold(p, "BODY=BINARYMIME") {
+			s.binary = true
+		}
+	}
+	return s.reply(250, "OK")
+}
+
+func (s *session) rcpt(arg string) bool {
+	if s.from == "" {
+		return s.reply(503, "Need MAIL command")
+	}
+	path, _, ok := parsePath(arg, "TO:")
+	if !ok || path == "" {
+		return s.reply(501, "Syntax: RCPT TO:<address>")
+	}
+	r := Reply{}
+	if s.server.rcptReply != nil {
+		r = s.server.rcptReply(path)
+	}
+	if r.Code == 0 {
+		r = Reply{Code: 250, Message: "OK"}
+	}
+	if r.Code >= 200 && r.Code < 300 {
+		s.recipients = append(s.recipients, path)
+	}
+	return s.reply(r.Code, r.Message)
+}
+
+func (s *session) data() bool {
+	if s.from == "" {
+		return s.reply(503, "Need MAIL command")
+	}
+	if len(s.recipients) == 0 {
+		return s.reply(554, "No valid recipients")
+	}
+	if s.binary {
+		return s.reply(503, "BINARYMIME requires BDAT")
+	}
+	if !s.reply(354, "End data with <CR><LF>.<CR><LF>") {
+		return false
+	}
+	data, err := io.ReadAll(s.tp.DotReader())
+	if err != nil {
+		return false
+	}
+	// The DotReader converts line endings to LF; restore them.
+	return s.finish(bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")))
+}
+
+func (s *session) bdat(arg string) bool {
+	fields := strings.Fields(arg)
+	size, err := 0, error(nil)
+	if len(fields) > 0 {
+		size, err = strconv.Atoi(fields[0])
+	}
+	if len(fields) == 0 || len(fields) > 2 || err != nil || size < 0 {
+		return s.reply(501, "Syntax: BDAT size [LAST]")
+	}
+	last := len(fields) == 2 && strings.EqualFold(fields[1], "LAST")
+	// The chunk must be consumed before any reply.
+	if _, err := io.CopyN(&s.chunks, s.tp.R, int64(size)); err != nil {
+		return false
+	}
+	if s.from == "" {
+		s.chunks.Reset()
+		return s.reply(503, "Need MAIL command")
+	}
+	if len(s.recipients) == 0 {
+		s.chunks.Reset()
+		return s.reply(554, "No valid recipients")
+	}
+	if !last {
+		return s.reply(250, fmt.Sprintf("%d octets received", size))
+	}
+	data := append([]byte(nil), s.chunks.Bytes()...)
+	return s.finish(data)
+}
+
+// finish accepts the message content of the current transaction, and replies once for SMTP, or
+// once per recipient for LMTP.
+func (s *session) finish(data []byte) bool {
+	msg := &Message{
+		From:       s.from,
+		Params:     s.mailParams,
+		Recipients: s.recipients,
+		Data:       data,
+		TLS:        s.tls,
+	}
+	msg.Envelope, msg.ParseErr = enmime.ReadEnvelope(bytes.NewReader(data))
+	defer s.reset()
+
+	reply := func(rcpt string) Reply {
+		r := Reply{}
+		if s.server.dataReply != nil {
+			r = s.server.dataReply(rcpt, msg)
+		}
+		if r.Code == 0 {
+			r = Reply{Code: 250, Message: "OK: queued"}
+		}
+		return r
+	}
+	if !s.server.lmtp {
+		r := reply("")
+		if r.Code >= 200 && r.Code < 300 {
+			s.server.deliver(msg)
+		}
+		return s.reply(r.Code, r.Message)
+	}
+
+	var delivered []string
+	replies := make([]Reply, len(s.recipients))
+	for i, rcpt := range s.recipients {
+		replies[i] = reply(rcpt)
+		if replies[i].Code >= 200 && replies[i].Code < 300 {
+			delivered = append(delivered, rcpt)
+		}
+	}
+	if len(delivered) > 0 {
+		msg.Recipients = delivered
+		s.server.deliver(msg)
+	}
+	for _, r := range replies {
+		if !s.reply(r.Code, r.Message) {
+			return false
+		}
+	}
+	return true
+}
+
+// reset ends the current mail transaction.
+func (s *session) reset() {
+	s.from = ""
+	s.mailParams = nil
+	s.recipients = nil
+	s.chunks.Reset()
+	s.binary = false
+}
+
+// parsePath parses the "FROM:<path> params" argument of MAIL, or "TO:<path> params" of RCPT.
+func parsePath(arg, prefix string) (path string, params []string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimLeft(arg[len(prefix):], " ")
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
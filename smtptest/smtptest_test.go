@@ -0,0 +1,135 @@
+package smtptest_test
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jhillyerd/enmime/v2/smtptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBuilder() enmime.MailBuilder {
+	return enmime.Builder().
+		From("Alice", "alice@example.com").
+		To("Bob", "bob@example.com").
+		Subject("Hello").
+		Text([]byte("Hi Bob\n.\nBye")).
+		HTML([]byte("<p>Hi Bob</p>")).
+		AddAttachment([]byte("a,b"), "text/csv", "data.csv")
+}
+
+func TestServerSMTP(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	err := testBuilder().Send(enmime.NewSMTP(srv.Addr, nil))
+	require.NoError(t, err)
+
+	msg := <-srv.C
+	msg.AssertFrom(t, "alice@example.com")
+	msg.AssertRecipients(t, "bob@example.com")
+	msg.AssertHeader(t, "Subject", "Hello")
+	msg.AssertTextContains(t, "Hi Bob\r\n.\r\nBye")
+	msg.AssertHTMLContains(t, "<p>Hi Bob</p>")
+	att := msg.AssertAttachment(t, "data.csv")
+	require.NotNil(t, att)
+	assert.Equal(t, "a,b", string(att.Content))
+	assert.True(t, strings.HasSuffix(string(msg.Data), "\r\n"))
+	srv.AssertCount(t, 1)
+
+	srv.Reset()
+	srv.AssertCount(t, 0)
+}
+
+func TestServerRejections(t *testing.T) {
+	srv := smtptest.NewServer(t,
+		smtptest.RcptResponse(func(rcpt string) smtptest.Reply {
+			if rcpt == "gone@example.com" {
+				return smtptest.Reply{Code: 550, Message: "5.1.1 No such user"}
+			}
+			return smtptest.Reply{}
+		}),
+		smtptest.Extensions("8BITMIME"),
+	)
+	c := enmime.NewSMTPClient(srv.Addr)
+	defer func() { _ = c.Close() }()
+
+	p, err := testBuilder().Build()
+	require.NoError(t, err)
+	res, err := c.SendPartContext(context.Background(), "alice@example.com",
+		[]string{"bob@example.com", "gone@example.com"}, p)
+	require.NoError(t, err)
+	require.Len(t, res.Rejected, 1)
+	assert.Equal(t, 550, res.Rejected[0].Code)
+
+	msgs := srv.WaitForMessages(t, 1)
+	msgs[0].AssertRecipients(t, "bob@example.com")
+	assert.Contains(t, msgs[0].Params, "BODY=8BITMIME")
+}
+
+func TestServerBinary(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	c := enmime.NewSMTPClient(srv.Addr)
+	defer func() { _ = c.Close() }()
+
+	p, err := testBuilder().Build()
+	require.NoError(t, err)
+	require.NoError(t, c.SendPart("alice@example.com", []string{"bob@example.com"}, p))
+	msgs := srv.WaitForMessages(t, 1)
+	assert.Contains(t, msgs[0].Params, "BODY=BINARYMIME")
+	msgs[0].AssertTextContains(t, "Hi Bob")
+}
+
+func TestServerLMTP(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "lmtp.sock"))
+	require.NoError(t, err)
+	srv := smtptest.NewServer(t,
+		smtptest.Listener(l),
+		smtptest.LMTP(),
+		smtptest.DataResponse(func(rcpt string, _ *smtptest.Message) smtptest.Reply {
+			if rcpt == "full@example.com" {
+				return smtptest.Reply{Code: 452, Message: "4.2.2 Mailbox full"}
+			}
+			return smtptest.Reply{}
+		}),
+	)
+
+	conn, err := net.Dial("unix", srv.Addr)
+	require.NoError(t, err)
+	tp := textproto.NewConn(conn)
+	defer func() { _ = tp.Close() }()
+	cmd := func(code int, format string, args ...any) {
+		t.Helper()
+		require.NoError(t, tp.PrintfLine(format, args...))
+		_, _, err := tp.ReadResponse(code)
+		require.NoError(t, err)
+	}
+
+	_, _, err = tp.ReadResponse(220)
+	require.NoError(t, err)
+	cmd(500, "EHLO localhost")
+	cmd(250, "LHLO localhost")
+	cmd(250, "MAIL FROM:<alice@example.com>")
+	cmd(250, "RCPT TO:<bob@example.com>")
+	cmd(250, "RCPT TO:<full@example.com>")
+	cmd(354, "DATA")
+	w := tp.DotWriter()
+	_, err = w.Write([]byte("Subject: LMTP\r\n\r\nHello\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, _, err = tp.ReadResponse(250)
+	require.NoError(t, err)
+	code, _, err := tp.ReadResponse(250)
+	assert.Error(t, err)
+	assert.Equal(t, 452, code)
+	cmd(221, "QUIT")
+
+	msgs := srv.WaitForMessages(t, 1)
+	msgs[0].AssertRecipients(t, "bob@example.com")
+	msgs[0].AssertHeader(t, "Subject", "LMTP")
+	assert.Equal(t, "Subject: LMTP\r\n\r\nHello\r\n", string(msgs[0].Data))
+}